@@ -0,0 +1,285 @@
+package bannerimpl
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidBundle  = errors.New("invalid banner bundle")
+	ErrBundleNotFound = errors.New("banner bundle not found")
+)
+
+// bundleLimits bound what an upload may expand to. Sizes are counted on the
+// bytes actually read, not the sizes the zip headers claim.
+type bundleLimits struct {
+	MaxFiles      int
+	MaxFileBytes  int64
+	MaxTotalBytes int64
+}
+
+func defaultBundleLimits() bundleLimits {
+	return bundleLimits{MaxFiles: 100, MaxFileBytes: 2 << 20, MaxTotalBytes: 10 << 20}
+}
+
+// bundle is an extracted HTML5 creative keyed by slash-separated path.
+type bundle struct {
+	Files      map[string][]byte
+	EntryPoint string
+}
+
+// bundleManifest is what is kept on the banner once the files are stored.
+type bundleManifest struct {
+	BannerID   int64
+	EntryPoint string
+	Files      []string
+}
+
+type bundleStorage interface {
+	put(ctx context.Context, key string, data []byte) error
+	get(ctx context.Context, key string) ([]byte, error)
+}
+
+type bundleSource interface {
+	// getBundle returns ErrBundleNotFound when the banner has no bundle.
+	getBundle(ctx context.Context, bannerID int64) (*bundleManifest, error)
+}
+
+// bundlePath cleans a zip entry name. Absolute names, backslashes, drive
+// letters and names that climb out of the bundle are rejected.
+func bundlePath(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsAny(name, "\\:\x00") {
+		return "", fmt.Errorf("%w: unsafe path %q", ErrInvalidBundle, name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: unsafe path %q", ErrInvalidBundle, name)
+		}
+	}
+
+	return path.Clean(name), nil
+}
+
+// extractBundle unzips an upload in memory, enforcing the limits as it
+// reads, and finds the entry point. Directories and macOS metadata are
+// skipped; symlinks are rejected.
+func extractBundle(data []byte, limits bundleLimits) (*bundle, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+
+	b := &bundle{Files: map[string][]byte{}}
+	var total int64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || path.Base(f.Name) == ".DS_Store" {
+			continue
+		}
+		if f.Mode()&^0o777 != 0 {
+			return nil, fmt.Errorf("%w: %q is not a regular file", ErrInvalidBundle, f.Name)
+		}
+
+		name, err := bundlePath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := b.Files[name]; ok {
+			return nil, fmt.Errorf("%w: %q appears twice", ErrInvalidBundle, name)
+		}
+		if len(b.Files) == limits.MaxFiles {
+			return nil, fmt.Errorf("%w: more than %d files", ErrInvalidBundle, limits.MaxFiles)
+		}
+
+		content, err := readBundleFile(f, min(limits.MaxFileBytes, limits.MaxTotalBytes-total))
+		if err != nil {
+			return nil, err
+		}
+		total += int64(len(content))
+		b.Files[name] = content
+	}
+
+	b.EntryPoint, err = detectEntryPoint(b.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func readBundleFile(f *zip.File, limit int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBundle, f.Name, err)
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("%w: %s expands past the size limit", ErrInvalidBundle, f.Name)
+	}
+
+	return content, nil
+}
+
+// detectEntryPoint prefers index.html at the root, then index.html in the
+// single top-level folder many tools zip into, then the only HTML file.
+func detectEntryPoint(files map[string][]byte) (string, error) {
+	if _, ok := files["index.html"]; ok {
+		return "index.html", nil
+	}
+
+	tops := map[string]bool{}
+	var html []string
+	for name := range files {
+		top, _, _ := strings.Cut(name, "/")
+		tops[top] = true
+		if ext := strings.ToLower(path.Ext(name)); ext == ".html" || ext == ".htm" {
+			html = append(html, name)
+		}
+	}
+	if len(tops) == 1 {
+		for top := range tops {
+			if _, ok := files[top+"/index.html"]; ok {
+				return top + "/index.html", nil
+			}
+		}
+	}
+	if len(html) == 1 {
+		return html[0], nil
+	}
+
+	return "", fmt.Errorf("%w: no single entry point among %d html files", ErrInvalidBundle, len(html))
+}
+
+var (
+	clickTagVar = regexp.MustCompile(`(?i)\b(var|let|const)\s+clickTag\s*=\s*("[^"\n]*"|'[^'\n]*')`)
+	headTag     = regexp.MustCompile(`(?i)<head\b[^>]*>`)
+)
+
+// injectClickTag points the creative's clickTag at clickURL. Existing
+// clickTag declarations are rewritten; otherwise one is added at the top of
+// the head, before the creative's own scripts run.
+func injectClickTag(html []byte, clickURL string) []byte {
+	// json.Marshal escapes <, > and &, so the value cannot close the script.
+	quoted, _ := json.Marshal(clickURL)
+
+	if clickTagVar.Match(html) {
+		return clickTagVar.ReplaceAllFunc(html, func(m []byte) []byte {
+			keyword := clickTagVar.FindSubmatch(m)[1]
+			return []byte(fmt.Sprintf("%s clickTag = %s", keyword, quoted))
+		})
+	}
+
+	script := []byte(fmt.Sprintf("<script>var clickTag = %s;</script>", quoted))
+	if loc := headTag.FindIndex(html); loc != nil {
+		return append(append(append([]byte{}, html[:loc[1]]...), script...), html[loc[1]:]...)
+	}
+
+	return append(script, html...)
+}
+
+func bundleKey(bannerID int64, name string) string {
+	return "banners/" + strconv.FormatInt(bannerID, 10) + "/bundle/" + name
+}
+
+// storeBundle writes the extracted files to storage and returns the
+// manifest to save on the banner.
+func storeBundle(ctx context.Context, storage bundleStorage, bannerID int64, b *bundle) (*bundleManifest, error) {
+	names := make([]string, 0, len(b.Files))
+	for name := range b.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := storage.put(ctx, bundleKey(bannerID, name), b.Files[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &bundleManifest{BannerID: bannerID, EntryPoint: b.EntryPoint, Files: names}, nil
+}
+
+// previewHandler serves a stored bundle at <prefix>/<banner id>/<path>.
+// The entry point gets the clickTag from the clickTag query parameter, or
+// "#" for a plain preview.
+type previewHandler struct {
+	pathPrefix string
+	source     bundleSource
+	storage    bundleStorage
+}
+
+func (h *previewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, h.pathPrefix)
+	idPart, file, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+	bannerID, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || bannerID <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	manifest, err := h.source.getBundle(r.Context(), bannerID)
+	if errors.Is(err, ErrBundleNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if file == "" {
+		http.Redirect(w, r, path.Join(h.pathPrefix, idPart, manifest.EntryPoint), http.StatusFound)
+		return
+	}
+	name, err := bundlePath(file)
+	if err != nil || !slices.Contains(manifest.Files, name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, err := h.storage.get(r.Context(), bundleKey(bannerID, name))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if name == manifest.EntryPoint {
+		clickTag := "#"
+		if v := r.URL.Query().Get("clickTag"); v != "" {
+			u, err := url.Parse(v)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				http.Error(w, "clickTag must be an http(s) url", http.StatusBadRequest)
+				return
+			}
+			clickTag = v
+		}
+		content = injectClickTag(content, clickTag)
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// The creative's scripts run in a sandbox without access to our origin.
+	w.Header().Set("Content-Security-Policy", "sandbox allow-scripts allow-popups allow-popups-to-escape-sandbox")
+	_, _ = w.Write(content)
+}
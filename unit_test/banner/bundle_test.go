@@ -0,0 +1,426 @@
+package bannerimpl
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type zipEntry struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+func buildZip(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.mode != 0 {
+			h.SetMode(e.mode)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatalf("expected no error, but got %q", err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatalf("expected no error, but got %q", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	return buf.Bytes()
+}
+
+// liarZip stores size deflated zero bytes under a header that claims the
+// entry is 10 bytes.
+func liarZip(t *testing.T, size int) []byte {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	_, _ = fw.Write(bytes.Repeat([]byte{0}, size))
+	_ = fw.Close()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "index.html",
+		Method:             zip.Deflate,
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: 10,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	_, _ = w.Write(compressed.Bytes())
+	_ = zw.Close()
+
+	return buf.Bytes()
+}
+
+func TestExtractBundle(t *testing.T) {
+	small := bundleLimits{MaxFiles: 3, MaxFileBytes: 1 << 10, MaxTotalBytes: 1536}
+
+	testCases := []struct {
+		name               string
+		data               []byte
+		limits             bundleLimits
+		expectedEntryPoint string
+		expectedFiles      []string
+		expectedError      error
+	}{
+		{
+			name: "extract bundle Success - root index",
+			data: buildZip(t,
+				zipEntry{name: "index.html", content: "<html></html>"},
+				zipEntry{name: "js/main.js", content: "1"},
+				zipEntry{name: "js/"},
+				zipEntry{name: "__MACOSX/._index.html", content: "junk"},
+				zipEntry{name: "img/.DS_Store", content: "junk"},
+			),
+			limits:             defaultBundleLimits(),
+			expectedEntryPoint: "index.html",
+			expectedFiles:      []string{"index.html", "js/main.js"},
+		},
+		{
+			name: "extract bundle Success - index in single folder",
+			data: buildZip(t,
+				zipEntry{name: "banner_300x250/index.html", content: "<html></html>"},
+				zipEntry{name: "banner_300x250/other.html", content: "<html></html>"},
+			),
+			limits:             defaultBundleLimits(),
+			expectedEntryPoint: "banner_300x250/index.html",
+			expectedFiles:      []string{"banner_300x250/index.html", "banner_300x250/other.html"},
+		},
+		{
+			name:               "extract bundle Success - only html file",
+			data:               buildZip(t, zipEntry{name: "ad.htm", content: "<html></html>"}, zipEntry{name: "ad.js", content: "1"}),
+			limits:             defaultBundleLimits(),
+			expectedEntryPoint: "ad.htm",
+			expectedFiles:      []string{"ad.htm", "ad.js"},
+		},
+		{
+			name:          "extract bundle Error - parent traversal",
+			data:          buildZip(t, zipEntry{name: "index.html", content: "x"}, zipEntry{name: "../evil.html", content: "x"}),
+			limits:        defaultBundleLimits(),
+			expectedError: ErrInvalidBundle,
+		},
+		{
+			name:          "extract bundle Error - nested traversal",
+			data:          buildZip(t, zipEntry{name: "index.html", content: "x"}, zipEntry{name: "a/../../evil.html", content: "x"}),
+			limits:        defaultBundleLimits(),
+			expectedError: ErrInvalidBundle,
+		},
+		{
+			name:          "extract bundle Error - absolute path",
+			data:          buildZip(t, zipEntry{name: "/etc/passwd", content: "x"}),
+			limits:        defaultBundleLimits(),
+			expectedError: ErrInvalidBundle,
+		},
+		{
+			name:          "extract bundle Error - windows traversal",
+			data:          buildZip(t, zipEntry{name: "..\\evil.html", content: "x"}),
+			limits:        defaultBundleLimits(),
+			expectedError: ErrInvalidBundle,
+		},
+		{
+			name:          "extract bundle Error - symlink",
+			data:          buildZip(t, zipEntry{name: "index.html", content: "/etc/passwd", mode: os.ModeSymlink | 0o777}),
+			limits:        defaultBundleLimits(),
+			expectedError: ErrInvalidBundle,
+		},
+		{
+			name:          "extract bundle Error - zip bomb file",
+			data:          buildZip(t, zipEntry{name: "index.html", content: strings.Repeat("0", 3<<20)}),
+			limits:        defaultBundleLimits(),
+			expectedError: ErrInvalidBundle,
+		},
+		{
+			name: "extract bundle Error - total size",
+			data: buildZip(t,
+				zipEntry{name: "index.html", content: strings.Repeat("a", 1000)},
+				zipEntry{name: "b.js", content: strings.Repeat("b", 1000)},
+			),
+			limits:        small,
+			expectedError: ErrInvalidBundle,
+		},
+		{
+			name: "extract bundle Error - too many files",
+			data: buildZip(t,
+				zipEntry{name: "index.html", content: "x"},
+				zipEntry{name: "a.js", content: "x"},
+				zipEntry{name: "b.js", content: "x"},
+				zipEntry{name: "c.js", content: "x"},
+			),
+			limits:        small,
+			expectedError: ErrInvalidBundle,
+		},
+		{
+			name:          "extract bundle Error - no entry point",
+			data:          buildZip(t, zipEntry{name: "a.html", content: "x"}, zipEntry{name: "b.html", content: "x"}),
+			limits:        defaultBundleLimits(),
+			expectedError: ErrInvalidBundle,
+		},
+		{
+			name:          "extract bundle Error - not a zip",
+			data:          []byte("not a zip"),
+			limits:        defaultBundleLimits(),
+			expectedError: ErrInvalidBundle,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := extractBundle(tc.data, tc.limits)
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			} else if err != nil {
+				if !errors.Is(err, tc.expectedError) {
+					t.Errorf("expected %q, got %q", tc.expectedError, err)
+				}
+				return
+			}
+
+			if result.EntryPoint != tc.expectedEntryPoint {
+				t.Errorf("expected entry point %q, got %q", tc.expectedEntryPoint, result.EntryPoint)
+			}
+			names := make([]string, 0, len(result.Files))
+			for name := range result.Files {
+				names = append(names, name)
+			}
+			if len(names) != len(tc.expectedFiles) {
+				t.Errorf("expected files %v, got %v", tc.expectedFiles, names)
+			}
+			for _, name := range tc.expectedFiles {
+				if _, ok := result.Files[name]; !ok {
+					t.Errorf("expected %s extracted, got %v", name, names)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractBundleLyingHeader(t *testing.T) {
+	limits := bundleLimits{MaxFiles: 10, MaxFileBytes: 1 << 10, MaxTotalBytes: 1 << 20}
+
+	_, err := extractBundle(liarZip(t, 1<<20), limits)
+	if err == nil {
+		t.Fatalf("expected error %q, but got none", ErrInvalidBundle)
+	}
+	if !errors.Is(err, ErrInvalidBundle) {
+		t.Errorf("expected %q, got %q", ErrInvalidBundle, err)
+	}
+}
+
+func TestInjectClickTag(t *testing.T) {
+	testCases := []struct {
+		name     string
+		html     string
+		clickURL string
+		expected string
+	}{
+		{
+			name:     "inject clickTag Success - into head",
+			html:     `<html><HEAD lang="en"><title>ad</title></HEAD><body></body></html>`,
+			clickURL: "https://go.example.com/t/abc?sub1=a&sub2=b",
+			expected: `<html><HEAD lang="en"><script>var clickTag = "https://go.example.com/t/abc?sub1=a\u0026sub2=b";</script><title>ad</title></HEAD><body></body></html>`,
+		},
+		{
+			name:     "inject clickTag Success - existing declaration",
+			html:     `<head><script>var clickTag = 'https://brand.example/';</script></head>`,
+			clickURL: "https://go.example.com/t/abc",
+			expected: `<head><script>var clickTag = "https://go.example.com/t/abc";</script></head>`,
+		},
+		{
+			name:     "inject clickTag Success - no head",
+			html:     `<div>ad</div>`,
+			clickURL: "https://go.example.com/t/abc",
+			expected: `<script>var clickTag = "https://go.example.com/t/abc";</script><div>ad</div>`,
+		},
+		{
+			name:     "inject clickTag Success - script breakout escaped",
+			html:     `<head></head>`,
+			clickURL: `https://x.example/"</script><script>alert(1)</script>`,
+			expected: `<head><script>var clickTag = "https://x.example/\"\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e";</script></head>`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := string(injectClickTag([]byte(tc.html), tc.clickURL))
+			if result != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, result)
+			}
+		})
+	}
+}
+
+type fakeBundleStorage struct {
+	objects map[string][]byte
+	err     error
+}
+
+func (f *fakeBundleStorage) put(_ context.Context, key string, data []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.objects[key] = data
+	return nil
+}
+
+func (f *fakeBundleStorage) get(_ context.Context, key string) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	data, ok := f.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("no object %s", key)
+	}
+	return data, nil
+}
+
+type fakeBundleSource struct {
+	manifests map[int64]*bundleManifest
+}
+
+func (f *fakeBundleSource) getBundle(_ context.Context, bannerID int64) (*bundleManifest, error) {
+	m, ok := f.manifests[bannerID]
+	if !ok {
+		return nil, ErrBundleNotFound
+	}
+	return m, nil
+}
+
+func TestPreviewHandler(t *testing.T) {
+	b, err := extractBundle(buildZip(t,
+		zipEntry{name: "ad/index.html", content: "<html><head></head><body><img src=bg.png></body></html>"},
+		zipEntry{name: "ad/bg.png", content: "\x89PNG"},
+		zipEntry{name: "ad/main.js", content: "console.log(clickTag)"},
+	), defaultBundleLimits())
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+
+	storage := &fakeBundleStorage{objects: map[string][]byte{}}
+	manifest, err := storeBundle(context.Background(), storage, 42, b)
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	expectedManifest := &bundleManifest{BannerID: 42, EntryPoint: "ad/index.html", Files: []string{"ad/bg.png", "ad/index.html", "ad/main.js"}}
+	if !reflect.DeepEqual(manifest, expectedManifest) {
+		t.Fatalf("expected %+v, got %+v", expectedManifest, manifest)
+	}
+	if _, ok := storage.objects["banners/42/bundle/ad/main.js"]; !ok {
+		t.Fatalf("expected the files stored by banner, got %v", storage.objects)
+	}
+
+	h := &previewHandler{
+		pathPrefix: "/banners/preview/",
+		source:     &fakeBundleSource{manifests: map[int64]*bundleManifest{42: manifest}},
+		storage:    storage,
+	}
+
+	testCases := []struct {
+		name                string
+		path                string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+		expectedLocation    string
+	}{
+		{
+			name:             "preview banner Success - redirect to entry point",
+			path:             "/banners/preview/42/",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "/banners/preview/42/ad/index.html",
+		},
+		{
+			name:                "preview banner Success - entry point",
+			path:                "/banners/preview/42/ad/index.html",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        `<html><head><script>var clickTag = "#";</script></head><body><img src=bg.png></body></html>`,
+		},
+		{
+			name:                "preview banner Success - entry point with clickTag",
+			path:                "/banners/preview/42/ad/index.html?clickTag=https%3A%2F%2Fgo.example.com%2Ft%2Fabc",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        `<html><head><script>var clickTag = "https://go.example.com/t/abc";</script></head><body><img src=bg.png></body></html>`,
+		},
+		{
+			name:                "preview banner Success - asset",
+			path:                "/banners/preview/42/ad/main.js",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/javascript; charset=utf-8",
+			expectedBody:        "console.log(clickTag)",
+		},
+		{
+			name:           "preview banner Error - javascript clickTag",
+			path:           "/banners/preview/42/ad/index.html?clickTag=javascript:alert(1)",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "preview banner Error - traversal",
+			path:           "/banners/preview/42/ad/../../41/index.html",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "preview banner Error - file not in bundle",
+			path:           "/banners/preview/42/ad/secret.txt",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "preview banner Error - no bundle",
+			path:           "/banners/preview/7/index.html",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path, req.URL.RawQuery, _ = strings.Cut(tc.path, "?")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatus, rec.Code)
+			}
+			if tc.expectedLocation != "" && rec.Header().Get("Location") != tc.expectedLocation {
+				t.Errorf("expected location %s, got %s", tc.expectedLocation, rec.Header().Get("Location"))
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tc.expectedContentType {
+				t.Errorf("expected content type %s, got %s", tc.expectedContentType, ct)
+			}
+			if !strings.HasPrefix(rec.Header().Get("Content-Security-Policy"), "sandbox") {
+				t.Errorf("expected a sandbox policy, got %q", rec.Header().Get("Content-Security-Policy"))
+			}
+			if rec.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %s, got %s", tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package bannerimpl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var ErrInvalidCreative = errors.New("invalid banner creative")
+
+// iabSize is a standard IAB ad unit.
+type iabSize struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+var iabSizes = []iabSize{
+	{"Medium Rectangle", 300, 250},
+	{"Large Rectangle", 336, 280},
+	{"Square", 250, 250},
+	{"Small Square", 200, 200},
+	{"Leaderboard", 728, 90},
+	{"Large Leaderboard", 970, 90},
+	{"Billboard", 970, 250},
+	{"Full Banner", 468, 60},
+	{"Half Page", 300, 600},
+	{"Wide Skyscraper", 160, 600},
+	{"Skyscraper", 120, 600},
+	{"Mobile Leaderboard", 320, 50},
+	{"Mobile Banner", 300, 50},
+	{"Large Mobile Banner", 320, 100},
+}
+
+// creativeMimeTypes are the upload types the image decoders can read.
+var creativeMimeTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+const (
+	// defaultMaxCreativeBytes is the IAB initial load limit of 150 KB.
+	defaultMaxCreativeBytes = 150 * 1024
+	thumbnailSize           = 150
+)
+
+type variantKind string
+
+const (
+	variantThumbnail variantKind = "thumbnail"
+	variantResized   variantKind = "resized"
+)
+
+type ingestConfig struct {
+	MaxBytes int64
+	Sizes    []iabSize
+}
+
+func defaultIngestConfig() ingestConfig {
+	return ingestConfig{MaxBytes: defaultMaxCreativeBytes, Sizes: iabSizes}
+}
+
+// creativeInfo is what ingestion stores on the banner.
+type creativeInfo struct {
+	Width    int
+	Height   int
+	MimeType string
+	Bytes    int64
+	// Checksum is the hex SHA-256 of the upload.
+	Checksum string
+	SizeName string
+}
+
+type creativeVariant struct {
+	Kind     variantKind
+	Width    int
+	Height   int
+	MimeType string
+	Data     []byte
+	Checksum string
+}
+
+// inspectCreative reads an upload and checks its weight, type and
+// dimensions. It reads at most MaxBytes+1 bytes and decodes the image only
+// once its header shows a standard size.
+func inspectCreative(r io.Reader, cfg ingestConfig) (*creativeInfo, image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, cfg.MaxBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) > cfg.MaxBytes {
+		return nil, nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidCreative, cfg.MaxBytes)
+	}
+
+	// The sniffed type is used rather than the client's Content-Type.
+	mimeType := http.DetectContentType(data)
+	if !creativeMimeTypes[mimeType] {
+		return nil, nil, fmt.Errorf("%w: type %s is not allowed", ErrInvalidCreative, mimeType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCreative, err)
+	}
+
+	info := &creativeInfo{
+		Width:    config.Width,
+		Height:   config.Height,
+		MimeType: mimeType,
+		Bytes:    int64(len(data)),
+		Checksum: checksum(data),
+	}
+	for _, size := range cfg.Sizes {
+		if size.Width == info.Width && size.Height == info.Height {
+			info.SizeName = size.Name
+		}
+	}
+	if info.SizeName == "" {
+		return nil, nil, fmt.Errorf("%w: %dx%d is not a standard IAB size", ErrInvalidCreative, info.Width, info.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCreative, err)
+	}
+
+	return info, img, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ingestCreative validates an upload and generates its thumbnail and a
+// resized copy for every other configured size with the same aspect ratio.
+func ingestCreative(r io.Reader, cfg ingestConfig) (*creativeInfo, []*creativeVariant, error) {
+	info, src, err := inspectCreative(r, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tw, th := fitWithin(info.Width, info.Height, thumbnailSize, thumbnailSize)
+	thumb, err := encodeVariant(variantThumbnail, resizeImage(src, tw, th), info.MimeType)
+	if err != nil {
+		return nil, nil, err
+	}
+	variants := []*creativeVariant{thumb}
+
+	for _, size := range cfg.Sizes {
+		if (size.Width == info.Width && size.Height == info.Height) || !sameAspect(info.Width, info.Height, size.Width, size.Height) {
+			continue
+		}
+		v, err := encodeVariant(variantResized, resizeImage(src, size.Width, size.Height), info.MimeType)
+		if err != nil {
+			return nil, nil, err
+		}
+		variants = append(variants, v)
+	}
+
+	return info, variants, nil
+}
+
+// sameAspect reports whether two sizes' aspect ratios are within 1%.
+func sameAspect(w1, h1, w2, h2 int) bool {
+	a, b := w1*h2, w2*h1
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff*100 <= a
+}
+
+// fitWithin scales w x h down, keeping the aspect ratio, so it fits in
+// maxW x maxH. Sizes that already fit are returned unchanged.
+func fitWithin(w, h, maxW, maxH int) (int, int) {
+	if w <= maxW && h <= maxH {
+		return w, h
+	}
+	if w*maxH > h*maxW {
+		return maxW, max(1, h*maxW/w)
+	}
+	return max(1, w*maxH/h), maxH
+}
+
+// resizeImage scales src to w x h by averaging the source pixels each
+// destination pixel covers, which keeps thin lines when shrinking.
+func resizeImage(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, src, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := b.Dx(), b.Dy()
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := rgba.RGBAAt(b.Min.X+sx, b.Min.Y+sy)
+					r, g, bl, a = r+uint32(c.R), g+uint32(c.G), bl+uint32(c.B), a+uint32(c.A)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)})
+		}
+	}
+
+	return dst
+}
+
+// encodeVariant writes JPEG creatives as JPEG and the others as PNG, since
+// re-quantizing a GIF loses more than it saves.
+func encodeVariant(kind variantKind, img image.Image, mimeType string) (*creativeVariant, error) {
+	var buf bytes.Buffer
+	out := "image/png"
+	var err error
+	if mimeType == "image/jpeg" {
+		out = mimeType
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &creativeVariant{
+		Kind:     kind,
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+		MimeType: out,
+		Data:     buf.Bytes(),
+		Checksum: checksum(buf.Bytes()),
+	}, nil
+}
+
+// sizeFilter is the size part of a banner search. Zero fields match any
+// banner.
+type sizeFilter struct {
+	Width    int
+	Height   int
+	MimeType string
+}
+
+// conditions returns the SQL conditions and arguments for the filter, to
+// be joined into the search's WHERE clause.
+func (f sizeFilter) conditions() ([]string, []interface{}) {
+	conds := make([]string, 0, 3)
+	args := make([]interface{}, 0, 3)
+	if f.Width > 0 {
+		conds = append(conds, "b.width = ?")
+		args = append(args, f.Width)
+	}
+	if f.Height > 0 {
+		conds = append(conds, "b.height = ?")
+		args = append(args, f.Height)
+	}
+	if f.MimeType != "" {
+		conds = append(conds, "b.mime_type = ?")
+		args = append(args, strings.ToLower(f.MimeType))
+	}
+
+	return conds, args
+}
+
+func (f sizeFilter) match(info *creativeInfo) bool {
+	return (f.Width == 0 || info.Width == f.Width) &&
+		(f.Height == 0 || info.Height == f.Height) &&
+		(f.MimeType == "" || strings.EqualFold(info.MimeType, f.MimeType))
+}
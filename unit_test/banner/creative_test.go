@@ -0,0 +1,265 @@
+package bannerimpl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, format string, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, testImage(w, h))
+	case "jpeg":
+		err = jpeg.Encode(&buf, testImage(w, h), nil)
+	case "gif":
+		err = gif.Encode(&buf, testImage(w, h), nil)
+	}
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	return buf.Bytes()
+}
+
+func TestIngestCreative(t *testing.T) {
+	type size struct{ Width, Height int }
+
+	testCases := []struct {
+		name             string
+		format           string
+		width            int
+		height           int
+		expectedMimeType string
+		expectedSizeName string
+		expectedVariants []size
+		expectedVarMime  string
+	}{
+		{
+			name:             "ingest creative Success - png medium rectangle",
+			format:           "png",
+			width:            300,
+			height:           250,
+			expectedMimeType: "image/png",
+			expectedSizeName: "Medium Rectangle",
+			expectedVariants: []size{{150, 125}, {336, 280}},
+			expectedVarMime:  "image/png",
+		},
+		{
+			name:             "ingest creative Success - jpeg leaderboard",
+			format:           "jpeg",
+			width:            728,
+			height:           90,
+			expectedMimeType: "image/jpeg",
+			expectedSizeName: "Leaderboard",
+			expectedVariants: []size{{150, 18}},
+			expectedVarMime:  "image/jpeg",
+		},
+		{
+			name:             "ingest creative Success - gif small square",
+			format:           "gif",
+			width:            200,
+			height:           200,
+			expectedMimeType: "image/gif",
+			expectedSizeName: "Small Square",
+			expectedVariants: []size{{150, 150}, {250, 250}},
+			expectedVarMime:  "image/png",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := encodeTestImage(t, tc.format, tc.width, tc.height)
+			sum := sha256.Sum256(data)
+
+			info, variants, err := ingestCreative(bytes.NewReader(data), defaultIngestConfig())
+			if err != nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+
+			expected := &creativeInfo{
+				Width:    tc.width,
+				Height:   tc.height,
+				MimeType: tc.expectedMimeType,
+				Bytes:    int64(len(data)),
+				Checksum: hex.EncodeToString(sum[:]),
+				SizeName: tc.expectedSizeName,
+			}
+			if !reflect.DeepEqual(info, expected) {
+				t.Errorf("expected %+v, got %+v", expected, info)
+			}
+
+			if len(variants) != len(tc.expectedVariants) {
+				t.Fatalf("expected %d variants, got %d", len(tc.expectedVariants), len(variants))
+			}
+			if variants[0].Kind != variantThumbnail {
+				t.Errorf("expected the thumbnail first, got %s", variants[0].Kind)
+			}
+			for i, v := range variants {
+				if i > 0 && v.Kind != variantResized {
+					t.Errorf("expected a resized variant, got %s", v.Kind)
+				}
+
+				img, format, err := image.Decode(bytes.NewReader(v.Data))
+				if err != nil {
+					t.Fatalf("expected no error, but got %q", err)
+				}
+				got := size{img.Bounds().Dx(), img.Bounds().Dy()}
+				if got != tc.expectedVariants[i] || v.Width != got.Width || v.Height != got.Height {
+					t.Errorf("expected variant %v, got %v (%dx%d)", tc.expectedVariants[i], got, v.Width, v.Height)
+				}
+				if v.MimeType != tc.expectedVarMime || "image/"+format != v.MimeType {
+					t.Errorf("expected %s variant, got %s encoded as %s", tc.expectedVarMime, v.MimeType, format)
+				}
+				if sum := sha256.Sum256(v.Data); v.Checksum != hex.EncodeToString(sum[:]) {
+					t.Errorf("expected the variant checksum to match its data")
+				}
+			}
+		})
+	}
+}
+
+func TestInspectCreativeError(t *testing.T) {
+	corrupt := encodeTestImage(t, "png", 300, 250)[:40]
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		maxBytes int64
+	}{
+		{name: "inspect creative Error - not an IAB size", data: encodeTestImage(t, "png", 301, 250)},
+		{name: "inspect creative Error - too heavy", data: encodeTestImage(t, "png", 300, 250), maxBytes: 100},
+		{name: "inspect creative Error - wrong file type", data: []byte("<html><body>banner</body></html>")},
+		{name: "inspect creative Error - svg is not allowed", data: []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="300" height="250"></svg>`)},
+		{name: "inspect creative Error - corrupt image", data: corrupt},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultIngestConfig()
+			if tc.maxBytes > 0 {
+				cfg.MaxBytes = tc.maxBytes
+			}
+
+			_, _, err := inspectCreative(bytes.NewReader(tc.data), cfg)
+			if err == nil {
+				t.Fatalf("expected error %q, but got none", ErrInvalidCreative)
+			}
+			if !errors.Is(err, ErrInvalidCreative) {
+				t.Errorf("expected %q, got %q", ErrInvalidCreative, err)
+			}
+		})
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			if (x+y)%2 == 0 {
+				src.SetRGBA(x, y, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+			} else {
+				src.SetRGBA(x, y, color.RGBA{A: 0xff})
+			}
+		}
+	}
+
+	dst := resizeImage(src, 2, 1)
+	for x := 0; x < 2; x++ {
+		if c := dst.RGBAAt(x, 0); c != (color.RGBA{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}) {
+			t.Errorf("expected the 2x2 block averaged to grey, got %v", c)
+		}
+	}
+
+	if w, h := fitWithin(120, 600, thumbnailSize, thumbnailSize); w != 30 || h != 150 {
+		t.Errorf("expected 30x150, got %dx%d", w, h)
+	}
+	if w, h := fitWithin(100, 50, thumbnailSize, thumbnailSize); w != 100 || h != 50 {
+		t.Errorf("expected 100x50 unchanged, got %dx%d", w, h)
+	}
+}
+
+func TestSizeFilter(t *testing.T) {
+	banners := []*creativeInfo{
+		{Width: 300, Height: 250, MimeType: "image/png"},
+		{Width: 300, Height: 600, MimeType: "image/jpeg"},
+		{Width: 728, Height: 90, MimeType: "image/png"},
+		{Width: 300, Height: 250, MimeType: "image/gif"},
+	}
+
+	testCases := []struct {
+		name               string
+		filter             sizeFilter
+		expectedMatches    []int
+		expectedConditions []string
+		expectedArgs       []interface{}
+	}{
+		{
+			name:               "search banner Success - by width",
+			filter:             sizeFilter{Width: 300},
+			expectedMatches:    []int{0, 1, 3},
+			expectedConditions: []string{"b.width = ?"},
+			expectedArgs:       []interface{}{300},
+		},
+		{
+			name:               "search banner Success - by size",
+			filter:             sizeFilter{Width: 300, Height: 250},
+			expectedMatches:    []int{0, 3},
+			expectedConditions: []string{"b.width = ?", "b.height = ?"},
+			expectedArgs:       []interface{}{300, 250},
+		},
+		{
+			name:               "search banner Success - by size and type",
+			filter:             sizeFilter{Width: 300, Height: 250, MimeType: "IMAGE/GIF"},
+			expectedMatches:    []int{3},
+			expectedConditions: []string{"b.width = ?", "b.height = ?", "b.mime_type = ?"},
+			expectedArgs:       []interface{}{300, 250, "image/gif"},
+		},
+		{
+			name:               "search banner Success - no size",
+			expectedMatches:    []int{0, 1, 2, 3},
+			expectedConditions: []string{},
+			expectedArgs:       []interface{}{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matches := make([]int, 0)
+			for i, b := range banners {
+				if tc.filter.match(b) {
+					matches = append(matches, i)
+				}
+			}
+			if !reflect.DeepEqual(matches, tc.expectedMatches) {
+				t.Errorf("expected banners %v, got %v", tc.expectedMatches, matches)
+			}
+
+			conds, args := tc.filter.conditions()
+			if !reflect.DeepEqual(conds, tc.expectedConditions) || !reflect.DeepEqual(args, tc.expectedArgs) {
+				t.Errorf("expected %v %v, got %v %v", tc.expectedConditions, tc.expectedArgs, conds, args)
+			}
+		})
+	}
+}
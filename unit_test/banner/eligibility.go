@@ -0,0 +1,169 @@
+package bannerimpl
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTargeting = errors.New("invalid banner targeting")
+
+// bannerTargeting is when and where a banner may be served. A zero
+// PublishAt or UnpublishAt leaves that side of the window open, and an
+// empty Countries or Languages list targets everyone.
+type bannerTargeting struct {
+	PublishAt   time.Time
+	UnpublishAt time.Time
+	// Countries are ISO 3166-1 alpha-2 codes.
+	Countries []string
+	// Languages are BCP 47 tags; "en" targets every English variant while
+	// "en-PH" targets only that one.
+	Languages  []string
+	BrandID    int64
+	CampaignID int64
+}
+
+// normalize validates the targeting and returns it with upper-case
+// countries and lower-case languages, sorted and without duplicates.
+func (t bannerTargeting) normalize() (bannerTargeting, error) {
+	if !t.PublishAt.IsZero() && !t.UnpublishAt.IsZero() && !t.UnpublishAt.After(t.PublishAt) {
+		return t, fmt.Errorf("%w: unpublish must be after publish", ErrInvalidTargeting)
+	}
+
+	countries := make([]string, 0, len(t.Countries))
+	for _, c := range t.Countries {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if len(c) != 2 || !isLetters(c) {
+			return t, fmt.Errorf("%w: bad country %q", ErrInvalidTargeting, c)
+		}
+		countries = append(countries, c)
+	}
+
+	languages := make([]string, 0, len(t.Languages))
+	for _, l := range t.Languages {
+		l = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(l), "_", "-"))
+		if primary := primaryLanguage(l); len(primary) < 2 || len(primary) > 3 || !isLetters(primary) {
+			return t, fmt.Errorf("%w: bad language %q", ErrInvalidTargeting, l)
+		}
+		languages = append(languages, l)
+	}
+
+	sort.Strings(countries)
+	sort.Strings(languages)
+	t.Countries, t.Languages = slices.Compact(countries), slices.Compact(languages)
+
+	return t, nil
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func primaryLanguage(tag string) string {
+	primary, _, _ := strings.Cut(tag, "-")
+	return primary
+}
+
+// market is where an affiliate's traffic comes from.
+type market struct {
+	Country  string
+	Language string
+}
+
+// published reports whether now is in the window [PublishAt, UnpublishAt).
+func (t bannerTargeting) published(now time.Time) bool {
+	return (t.PublishAt.IsZero() || !now.Before(t.PublishAt)) &&
+		(t.UnpublishAt.IsZero() || now.Before(t.UnpublishAt))
+}
+
+func (t bannerTargeting) targets(m market) bool {
+	if len(t.Countries) > 0 && !slices.ContainsFunc(t.Countries, func(c string) bool { return strings.EqualFold(c, m.Country) }) {
+		return false
+	}
+	if len(t.Languages) == 0 {
+		return true
+	}
+
+	lang := strings.ToLower(strings.ReplaceAll(m.Language, "_", "-"))
+	for _, l := range t.Languages {
+		if l == lang || (!strings.Contains(l, "-") && l == primaryLanguage(lang)) {
+			return true
+		}
+	}
+	return false
+}
+
+// eligibleBanner is a banner as the eligibility check sees it. Enabled is
+// the manual on/off status, which the window and targeting do not
+// override.
+type eligibleBanner struct {
+	ID        int64
+	Enabled   bool
+	Targeting bannerTargeting
+}
+
+// eligibilityFilter keeps the banners an affiliate can use: enabled,
+// published at At and targeting the affiliate's market.
+type eligibilityFilter struct {
+	Market market
+	At     time.Time
+}
+
+func (f eligibilityFilter) match(b *eligibleBanner) bool {
+	return b.Enabled && b.Targeting.published(f.At) && b.Targeting.targets(f.Market)
+}
+
+// filterEligible keeps the banners eligible under f, in order.
+func filterEligible(banners []*eligibleBanner, f eligibilityFilter) []*eligibleBanner {
+	eligible := make([]*eligibleBanner, 0, len(banners))
+	for _, b := range banners {
+		if f.match(b) {
+			eligible = append(eligible, b)
+		}
+	}
+
+	return eligible
+}
+
+// bannerGroup is the banners of one brand campaign.
+type bannerGroup struct {
+	BrandID    int64
+	CampaignID int64
+	BannerIDs  []int64
+}
+
+// groupBanners groups banners by brand and campaign, ordered by brand then
+// campaign. Banners without a brand or campaign fall in the zero group.
+func groupBanners(banners []*eligibleBanner) []*bannerGroup {
+	groups := map[[2]int64]*bannerGroup{}
+	for _, b := range banners {
+		key := [2]int64{b.Targeting.BrandID, b.Targeting.CampaignID}
+		g, ok := groups[key]
+		if !ok {
+			g = &bannerGroup{BrandID: key[0], CampaignID: key[1]}
+			groups[key] = g
+		}
+		g.BannerIDs = append(g.BannerIDs, b.ID)
+	}
+
+	result := make([]*bannerGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].BrandID != result[j].BrandID {
+			return result[i].BrandID < result[j].BrandID
+		}
+		return result[i].CampaignID < result[j].CampaignID
+	})
+
+	return result
+}
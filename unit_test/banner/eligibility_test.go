@@ -0,0 +1,222 @@
+package bannerimpl
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	publishAt   = time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	unpublishAt = time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func TestBannerTargetingNormalize(t *testing.T) {
+	testCases := []struct {
+		name          string
+		targeting     bannerTargeting
+		expectedError error
+		expected      bannerTargeting
+	}{
+		{
+			name: "normalize targeting Success",
+			targeting: bannerTargeting{
+				PublishAt:   publishAt,
+				UnpublishAt: unpublishAt,
+				Countries:   []string{"th", " PH", "TH"},
+				Languages:   []string{"en_PH", "TH", "en-PH"},
+			},
+			expected: bannerTargeting{
+				PublishAt:   publishAt,
+				UnpublishAt: unpublishAt,
+				Countries:   []string{"PH", "TH"},
+				Languages:   []string{"en-ph", "th"},
+			},
+		},
+		{
+			name:          "normalize targeting Error - unpublish before publish",
+			targeting:     bannerTargeting{PublishAt: unpublishAt, UnpublishAt: publishAt},
+			expectedError: ErrInvalidTargeting,
+		},
+		{
+			name:          "normalize targeting Error - bad country",
+			targeting:     bannerTargeting{Countries: []string{"PHL"}},
+			expectedError: ErrInvalidTargeting,
+		},
+		{
+			name:          "normalize targeting Error - bad language",
+			targeting:     bannerTargeting{Languages: []string{"english"}},
+			expectedError: ErrInvalidTargeting,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tc.targeting.normalize()
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, but got %v", tc.expectedError, err)
+			}
+			if err == nil && !reflect.DeepEqual(result, tc.expected) {
+				t.Fatalf("expected %+v, but got %+v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestEligibilityFilterMatch(t *testing.T) {
+	targeted := &eligibleBanner{
+		ID:      1,
+		Enabled: true,
+		Targeting: bannerTargeting{
+			PublishAt:   publishAt,
+			UnpublishAt: unpublishAt,
+			Countries:   []string{"PH", "TH"},
+			Languages:   []string{"en", "th-th"},
+		},
+	}
+	during := publishAt.Add(24 * time.Hour)
+	ph := market{Country: "PH", Language: "en-PH"}
+
+	testCases := []struct {
+		name     string
+		banner   *eligibleBanner
+		filter   eligibilityFilter
+		expected bool
+	}{
+		{
+			name:     "eligible Success - inside the window",
+			banner:   targeted,
+			filter:   eligibilityFilter{Market: ph, At: during},
+			expected: true,
+		},
+		{
+			name:     "eligible Success - at publish time",
+			banner:   targeted,
+			filter:   eligibilityFilter{Market: ph, At: publishAt},
+			expected: true,
+		},
+		{
+			name:   "eligible Error - just before publish time",
+			banner: targeted,
+			filter: eligibilityFilter{Market: ph, At: publishAt.Add(-time.Second)},
+		},
+		{
+			name:     "eligible Success - just before unpublish time",
+			banner:   targeted,
+			filter:   eligibilityFilter{Market: ph, At: unpublishAt.Add(-time.Second)},
+			expected: true,
+		},
+		{
+			name:   "eligible Error - at unpublish time",
+			banner: targeted,
+			filter: eligibilityFilter{Market: ph, At: unpublishAt},
+		},
+		{
+			name:   "eligible Error - country not targeted",
+			banner: targeted,
+			filter: eligibilityFilter{Market: market{Country: "VN", Language: "en"}, At: during},
+		},
+		{
+			name:   "eligible Error - language not targeted",
+			banner: targeted,
+			filter: eligibilityFilter{Market: market{Country: "PH", Language: "fil"}, At: during},
+		},
+		{
+			name:   "eligible Error - only a regional variant targeted",
+			banner: targeted,
+			filter: eligibilityFilter{Market: market{Country: "TH", Language: "th"}, At: during},
+		},
+		{
+			name:     "eligible Success - regional variant",
+			banner:   targeted,
+			filter:   eligibilityFilter{Market: market{Country: "th", Language: "th_TH"}, At: during},
+			expected: true,
+		},
+		{
+			name:   "eligible Error - disabled",
+			banner: &eligibleBanner{ID: 2, Targeting: targeted.Targeting},
+			filter: eligibilityFilter{Market: ph, At: during},
+		},
+		{
+			name:     "eligible Success - untargeted banner",
+			banner:   &eligibleBanner{ID: 3, Enabled: true},
+			filter:   eligibilityFilter{Market: market{Country: "VN", Language: "vi"}, At: during},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.match(tc.banner); got != tc.expected {
+				t.Fatalf("expected eligible %v, but got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestFilterEligible(t *testing.T) {
+	banners := []*eligibleBanner{
+		{ID: 1, Enabled: true, Targeting: bannerTargeting{Countries: []string{"PH"}}},
+		{ID: 2, Enabled: true, Targeting: bannerTargeting{Countries: []string{"TH"}}},
+		{ID: 3, Enabled: true, Targeting: bannerTargeting{PublishAt: publishAt}},
+		{ID: 4, Enabled: true, Targeting: bannerTargeting{UnpublishAt: publishAt}},
+		{ID: 5, Enabled: true, Targeting: bannerTargeting{Languages: []string{"th"}}},
+		{ID: 6, Enabled: false},
+		{ID: 7, Enabled: true},
+	}
+
+	testCases := []struct {
+		name     string
+		filter   eligibilityFilter
+		expected []int64
+	}{
+		{
+			name:     "filter eligible Success - before publish",
+			filter:   eligibilityFilter{Market: market{Country: "PH", Language: "en"}, At: publishAt.Add(-time.Hour)},
+			expected: []int64{1, 4, 7},
+		},
+		{
+			name:     "filter eligible Success - after publish",
+			filter:   eligibilityFilter{Market: market{Country: "PH", Language: "en"}, At: publishAt},
+			expected: []int64{1, 3, 7},
+		},
+		{
+			name:     "filter eligible Success - other market",
+			filter:   eligibilityFilter{Market: market{Country: "TH", Language: "th"}, At: publishAt},
+			expected: []int64{2, 3, 5, 7},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ids []int64
+			for _, b := range filterEligible(banners, tc.filter) {
+				ids = append(ids, b.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Fatalf("expected banners %v, but got %v", tc.expected, ids)
+			}
+		})
+	}
+}
+
+func TestGroupBanners(t *testing.T) {
+	banners := []*eligibleBanner{
+		{ID: 1, Targeting: bannerTargeting{BrandID: 2, CampaignID: 5}},
+		{ID: 2, Targeting: bannerTargeting{BrandID: 1, CampaignID: 9}},
+		{ID: 3},
+		{ID: 4, Targeting: bannerTargeting{BrandID: 2, CampaignID: 5}},
+		{ID: 5, Targeting: bannerTargeting{BrandID: 1, CampaignID: 3}},
+	}
+
+	expected := []*bannerGroup{
+		{BannerIDs: []int64{3}},
+		{BrandID: 1, CampaignID: 3, BannerIDs: []int64{5}},
+		{BrandID: 1, CampaignID: 9, BannerIDs: []int64{2}},
+		{BrandID: 2, CampaignID: 5, BannerIDs: []int64{1, 4}},
+	}
+	if result := groupBanners(banners); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %v, but got %v", expected, result)
+	}
+}
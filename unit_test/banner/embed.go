@@ -0,0 +1,106 @@
+package bannerimpl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidEmbed = errors.New("cannot build banner embed code")
+
+// bannerParam on a tracking URL tells the click which banner it came from.
+const bannerParam = "banner_id"
+
+type embedBanner struct {
+	ID       int64
+	ImageURL string
+	AltText  string
+	Width    int
+	Height   int
+}
+
+// embedTracking is the affiliate's tracking link; URL is its full short
+// URL.
+type embedTracking struct {
+	ID  int64
+	URL string
+}
+
+type embedCode struct {
+	HTML       string
+	IFrame     string
+	JavaScript string
+	BBCode     string
+}
+
+// generateEmbedCode builds the snippets an affiliate pastes to show banner
+// b through tracking link tr. pixelBase is the impression pixel endpoint;
+// the banner and tracking IDs are added to it.
+func generateEmbedCode(b embedBanner, tr embedTracking, pixelBase string) (*embedCode, error) {
+	image, err := httpURL(b.ImageURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: image: %v", ErrInvalidEmbed, err)
+	}
+	click, err := httpURL(tr.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: tracking link: %v", ErrInvalidEmbed, err)
+	}
+	pixel, err := httpURL(pixelBase)
+	if err != nil {
+		return nil, fmt.Errorf("%w: pixel: %v", ErrInvalidEmbed, err)
+	}
+
+	q := click.Query()
+	q.Set(bannerParam, strconv.FormatInt(b.ID, 10))
+	click.RawQuery = q.Encode()
+
+	q = pixel.Query()
+	q.Set("b", strconv.FormatInt(b.ID, 10))
+	q.Set("t", strconv.FormatInt(tr.ID, 10))
+	pixel.RawQuery = q.Encode()
+
+	snippet := fmt.Sprintf(
+		`<a href="%s" target="_blank" rel="sponsored noopener"><img src="%s" width="%d" height="%d" alt="%s" style="border:0"></a>`+
+			`<img src="%s" width="1" height="1" alt="" style="position:absolute;visibility:hidden">`,
+		html.EscapeString(click.String()), html.EscapeString(image.String()), b.Width, b.Height, html.EscapeString(b.AltText),
+		html.EscapeString(pixel.String()),
+	)
+
+	// json.Marshal escapes <, > and &, so the snippet cannot end the script.
+	quoted, _ := json.Marshal(snippet)
+
+	return &embedCode{
+		HTML: snippet,
+		IFrame: fmt.Sprintf(`<iframe srcdoc="%s" width="%d" height="%d" title="%s" frameborder="0" scrolling="no" style="border:0"></iframe>`,
+			html.EscapeString(snippet), b.Width, b.Height, html.EscapeString(b.AltText)),
+		JavaScript: fmt.Sprintf(`<script>(function(s){var d=document.createElement("div");d.innerHTML=%s;s.parentNode.insertBefore(d,s);})(document.currentScript);</script>`,
+			quoted),
+		BBCode: fmt.Sprintf(`[url=%s][img alt="%s"]%s[/img][/url][img]%s[/img]`,
+			bbcodeURL(click.String()), bbcodeText(b.AltText), bbcodeURL(image.String()), bbcodeURL(pixel.String())),
+	}, nil
+}
+
+func httpURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http(s) url", raw)
+	}
+	return u, nil
+}
+
+// bbcodeURL percent-encodes the characters that would end a BBCode tag.
+func bbcodeURL(s string) string {
+	return strings.NewReplacer("[", "%5B", "]", "%5D", `"`, "%22").Replace(s)
+}
+
+// bbcodeText drops the characters BBCode has no escape for.
+func bbcodeText(s string) string {
+	return strings.NewReplacer("[", "", "]", "", `"`, "").Replace(s)
+}
@@ -0,0 +1,152 @@
+package bannerimpl
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const (
+	testPixelBase = "https://ads.example.com/b/i"
+	testAltText   = `Spring <sale> "50%" & [more]`
+	// The banner ID is added to the tracking link's own query.
+	testClickURL = "https://go.example.com/t/abc?banner_id=12&src=mail"
+	testPixelURL = "https://ads.example.com/b/i?b=12&t=7"
+)
+
+func testEmbedCode(t *testing.T) *embedCode {
+	t.Helper()
+
+	code, err := generateEmbedCode(
+		embedBanner{ID: 12, ImageURL: "https://cdn.example.com/b/12.png", AltText: testAltText, Width: 300, Height: 250},
+		embedTracking{ID: 7, URL: "https://go.example.com/t/abc?src=mail"},
+		testPixelBase,
+	)
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	return code
+}
+
+func TestGenerateEmbedCodeHTML(t *testing.T) {
+	code := testEmbedCode(t)
+
+	for _, want := range []string{
+		`<a href="` + html.EscapeString(testClickURL) + `"`,
+		`<img src="https://cdn.example.com/b/12.png" width="300" height="250"`,
+		`alt="Spring &lt;sale&gt; &#34;50%&#34; &amp; [more]"`,
+		`<img src="` + html.EscapeString(testPixelURL) + `" width="1" height="1"`,
+	} {
+		if !strings.Contains(code.HTML, want) {
+			t.Fatalf("expected html to contain %q, but got %q", want, code.HTML)
+		}
+	}
+	if strings.Contains(code.HTML, "<sale>") {
+		t.Fatalf("expected alt text to be escaped, but got %q", code.HTML)
+	}
+}
+
+func TestGenerateEmbedCodeIFrame(t *testing.T) {
+	code := testEmbedCode(t)
+
+	m := regexp.MustCompile(`srcdoc="([^"]*)"`).FindStringSubmatch(code.IFrame)
+	if m == nil {
+		t.Fatalf("expected a srcdoc attribute, but got %q", code.IFrame)
+	}
+	if doc := html.UnescapeString(m[1]); doc != code.HTML {
+		t.Fatalf("expected srcdoc %q, but got %q", code.HTML, doc)
+	}
+	if !strings.Contains(code.IFrame, `title="Spring &lt;sale&gt; &#34;50%&#34; &amp; [more]"`) {
+		t.Fatalf("expected escaped title, but got %q", code.IFrame)
+	}
+	if n := strings.Count(code.IFrame, "<"); n != 2 {
+		t.Fatalf("expected only the iframe tags, but got %d in %q", n, code.IFrame)
+	}
+}
+
+func TestGenerateEmbedCodeJavaScript(t *testing.T) {
+	code := testEmbedCode(t)
+
+	body := strings.TrimSuffix(strings.TrimPrefix(code.JavaScript, "<script>"), "</script>")
+	if strings.Contains(body, "<") {
+		t.Fatalf("expected the markup to be escaped inside the script, but got %q", body)
+	}
+
+	m := regexp.MustCompile(`innerHTML=("(?:[^"\\]|\\.)*");`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("expected an innerHTML assignment, but got %q", body)
+	}
+	var snippet string
+	if err := json.Unmarshal([]byte(m[1]), &snippet); err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	if snippet != code.HTML {
+		t.Fatalf("expected script to write %q, but got %q", code.HTML, snippet)
+	}
+}
+
+func TestGenerateEmbedCodeBBCode(t *testing.T) {
+	code := testEmbedCode(t)
+
+	want := `[url=` + testClickURL + `][img alt="Spring <sale> 50% & more"]https://cdn.example.com/b/12.png[/img][/url]` +
+		`[img]` + testPixelURL + `[/img]`
+	if code.BBCode != want {
+		t.Fatalf("expected %q, but got %q", want, code.BBCode)
+	}
+
+	code, err := generateEmbedCode(
+		embedBanner{ID: 12, ImageURL: "https://cdn.example.com/b/[12].png", Width: 300, Height: 250},
+		embedTracking{ID: 7, URL: "https://go.example.com/t/abc"},
+		testPixelBase,
+	)
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	if strings.Count(code.BBCode, "[") != 6 || strings.Count(code.BBCode, "]") != 6 {
+		t.Fatalf("expected brackets in urls to be encoded, but got %q", code.BBCode)
+	}
+}
+
+func TestGenerateEmbedCodeInvalid(t *testing.T) {
+	testCases := []struct {
+		name      string
+		imageURL  string
+		trackURL  string
+		pixelBase string
+	}{
+		{
+			name:      "generate embed code Error - script tracking url",
+			imageURL:  "https://cdn.example.com/b/12.png",
+			trackURL:  "javascript:alert(1)",
+			pixelBase: testPixelBase,
+		},
+		{
+			name:      "generate embed code Error - ftp image",
+			imageURL:  "ftp://cdn.example.com/b/12.png",
+			trackURL:  "https://go.example.com/t/abc",
+			pixelBase: testPixelBase,
+		},
+		{
+			name:      "generate embed code Error - relative pixel",
+			imageURL:  "https://cdn.example.com/b/12.png",
+			trackURL:  "https://go.example.com/t/abc",
+			pixelBase: "/b/i",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := generateEmbedCode(
+				embedBanner{ID: 12, ImageURL: tc.imageURL, Width: 300, Height: 250},
+				embedTracking{ID: 7, URL: tc.trackURL},
+				tc.pixelBase,
+			)
+			if !errors.Is(err, ErrInvalidEmbed) {
+				t.Fatalf("expected error %q, but got %v", ErrInvalidEmbed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package bannerimpl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var ErrUnknownPlacement = errors.New("banner is not served with this tracking link")
+
+// transparentGIF is a 1x1 transparent GIF.
+var transparentGIF = []byte{
+	'G', 'I', 'F', '8', '9', 'a', 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0xff, 0xff, 0xff,
+	0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00,
+	0x2c, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00,
+	0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// bannerEvent is an impression, click or conversion of a banner served
+// with a tracking link. AffiliateID is the owner of the tracking link.
+// Clicks and conversions are the tracking log's: the redirect captures
+// bannerParam on the click and postbacks copy it to the conversion.
+type bannerEvent struct {
+	BannerID    int64
+	TrackingID  int64
+	AffiliateID int64
+	CreatedAt   time.Time
+}
+
+type statsStore interface {
+	// getPlacement returns the affiliate of the tracking link, or
+	// ErrUnknownPlacement when the banner or link does not exist.
+	getPlacement(ctx context.Context, bannerID, trackingID int64) (int64, error)
+	createImpression(ctx context.Context, e *bannerEvent) error
+}
+
+// placement resolves the banner and tracking IDs of a pixel or click into
+// an event. ok is false when the IDs are missing, malformed or unknown.
+func placement(ctx context.Context, store statsStore, rawBanner, rawTracking string, now time.Time) (*bannerEvent, bool, error) {
+	bannerID, err := strconv.ParseInt(rawBanner, 10, 64)
+	if err != nil || bannerID <= 0 {
+		return nil, false, nil
+	}
+	trackingID, err := strconv.ParseInt(rawTracking, 10, 64)
+	if err != nil || trackingID <= 0 {
+		return nil, false, nil
+	}
+
+	affiliateID, err := store.getPlacement(ctx, bannerID, trackingID)
+	if errors.Is(err, ErrUnknownPlacement) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return &bannerEvent{BannerID: bannerID, TrackingID: trackingID, AffiliateID: affiliateID, CreatedAt: now}, true, nil
+}
+
+// pixelHandler serves the impression pixel the embed code loads with the
+// b (banner) and t (tracking link) parameters. The pixel is served even
+// when nothing is recorded so the affiliate's page never shows a broken
+// image.
+type pixelHandler struct {
+	store   statsStore
+	now     func() time.Time
+	onError func(error)
+}
+
+func (h *pixelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		e, ok, err := placement(r.Context(), h.store, q.Get("b"), q.Get("t"), h.now())
+		if err == nil && ok {
+			err = h.store.createImpression(r.Context(), e)
+		}
+		if err != nil && h.onError != nil {
+			h.onError(err)
+		}
+	}
+
+	// Every load has to reach us to be counted.
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, private")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Content-Length", strconv.Itoa(len(transparentGIF)))
+	_, _ = w.Write(transparentGIF)
+}
+
+// placedEvents keeps the clicks or conversions whose banner is served with
+// their tracking link and sets their AffiliateID. bannerParam comes from
+// the visitor, so it is checked here before the event is counted.
+func placedEvents(ctx context.Context, store statsStore, events []*bannerEvent) ([]*bannerEvent, error) {
+	type placementKey struct{ bannerID, trackingID int64 }
+	affiliates := map[placementKey]int64{}
+
+	placed := make([]*bannerEvent, 0, len(events))
+	for _, e := range events {
+		key := placementKey{bannerID: e.BannerID, trackingID: e.TrackingID}
+		affiliateID, ok := affiliates[key]
+		if !ok {
+			var err error
+			affiliateID, err = store.getPlacement(ctx, e.BannerID, e.TrackingID)
+			if errors.Is(err, ErrUnknownPlacement) {
+				affiliateID = 0
+			} else if err != nil {
+				return nil, err
+			}
+			affiliates[key] = affiliateID
+		}
+		if affiliateID == 0 {
+			continue
+		}
+
+		placedEvent := *e
+		placedEvent.AffiliateID = affiliateID
+		placed = append(placed, &placedEvent)
+	}
+
+	return placed, nil
+}
+
+// bannerStatsQuery selects the events in [From, To). Zero IDs and times
+// do not filter.
+type bannerStatsQuery struct {
+	BannerID    int64
+	AffiliateID int64
+	From        time.Time
+	To          time.Time
+	GroupByDay  bool
+}
+
+func (q bannerStatsQuery) match(e *bannerEvent) bool {
+	return (q.BannerID == 0 || e.BannerID == q.BannerID) &&
+		(q.AffiliateID == 0 || e.AffiliateID == q.AffiliateID) &&
+		(q.From.IsZero() || !e.CreatedAt.Before(q.From)) &&
+		(q.To.IsZero() || e.CreatedAt.Before(q.To))
+}
+
+// bannerStatsRow is the performance of a banner for one affiliate. Day is
+// the UTC day when the query groups by day and zero otherwise.
+type bannerStatsRow struct {
+	BannerID    int64
+	AffiliateID int64
+	Day         time.Time
+	Impressions int64
+	Clicks      int64
+	Conversions int64
+	// CTR is clicks per impression, 0 when there were no impressions.
+	CTR float64
+}
+
+type bannerStatsKey struct {
+	bannerID, affiliateID int64
+	day                   time.Time
+}
+
+// summarizeBannerStats totals the events per banner and affiliate, and per
+// day when asked. Rows are ordered by day, banner, then affiliate.
+func summarizeBannerStats(q bannerStatsQuery, impressions, clicks, conversions []*bannerEvent) []*bannerStatsRow {
+	rows := map[bannerStatsKey]*bannerStatsRow{}
+	add := func(events []*bannerEvent, count func(*bannerStatsRow)) {
+		for _, e := range events {
+			if !q.match(e) {
+				continue
+			}
+			key := bannerStatsKey{bannerID: e.BannerID, affiliateID: e.AffiliateID}
+			if q.GroupByDay {
+				key.day = e.CreatedAt.UTC().Truncate(24 * time.Hour)
+			}
+			row, ok := rows[key]
+			if !ok {
+				row = &bannerStatsRow{BannerID: key.bannerID, AffiliateID: key.affiliateID, Day: key.day}
+				rows[key] = row
+			}
+			count(row)
+		}
+	}
+	add(impressions, func(r *bannerStatsRow) { r.Impressions++ })
+	add(clicks, func(r *bannerStatsRow) { r.Clicks++ })
+	add(conversions, func(r *bannerStatsRow) { r.Conversions++ })
+
+	result := make([]*bannerStatsRow, 0, len(rows))
+	for _, row := range rows {
+		if row.Impressions > 0 {
+			row.CTR = float64(row.Clicks) / float64(row.Impressions)
+		}
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if !a.Day.Equal(b.Day) {
+			return a.Day.Before(b.Day)
+		}
+		if a.BannerID != b.BannerID {
+			return a.BannerID < b.BannerID
+		}
+		return a.AffiliateID < b.AffiliateID
+	})
+
+	return result
+}
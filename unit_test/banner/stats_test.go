@@ -0,0 +1,230 @@
+package bannerimpl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeStatsStore knows the placements in affiliates, keyed by banner and
+// tracking ID.
+type fakeStatsStore struct {
+	affiliates  map[[2]int64]int64
+	err         error
+	impressions []*bannerEvent
+}
+
+func (s *fakeStatsStore) getPlacement(_ context.Context, bannerID, trackingID int64) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	affiliateID, ok := s.affiliates[[2]int64{bannerID, trackingID}]
+	if !ok {
+		return 0, ErrUnknownPlacement
+	}
+	return affiliateID, nil
+}
+
+func (s *fakeStatsStore) createImpression(_ context.Context, e *bannerEvent) error {
+	s.impressions = append(s.impressions, e)
+	return nil
+}
+
+var statsNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestPixelHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		query    string
+		err      error
+		recorded []*bannerEvent
+	}{
+		{
+			name:     "serve pixel Success",
+			method:   http.MethodGet,
+			query:    "b=12&t=7",
+			recorded: []*bannerEvent{{BannerID: 12, TrackingID: 7, AffiliateID: 3, CreatedAt: statsNow}},
+		},
+		{
+			name:   "serve pixel Success - unknown placement",
+			method: http.MethodGet,
+			query:  "b=12&t=8",
+		},
+		{
+			name:   "serve pixel Success - malformed ids",
+			method: http.MethodGet,
+			query:  "b=abc&t=7",
+		},
+		{
+			name:   "serve pixel Success - head is not counted",
+			method: http.MethodHead,
+			query:  "b=12&t=7",
+		},
+		{
+			name:   "serve pixel Error",
+			method: http.MethodGet,
+			query:  "b=12&t=7",
+			err:    fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &fakeStatsStore{affiliates: map[[2]int64]int64{{12, 7}: 3}, err: tc.err}
+			var reported error
+			h := &pixelHandler{store: store, now: func() time.Time { return statsNow }, onError: func(err error) { reported = err }}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tc.method, "/b/i?"+tc.query, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, but got %d", http.StatusOK, rec.Code)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store, no-cache, must-revalidate, private" {
+				t.Fatalf("expected the pixel not to be cached, but got %q", got)
+			}
+			if tc.method == http.MethodGet {
+				img, err := gif.Decode(bytes.NewReader(rec.Body.Bytes()))
+				if err != nil {
+					t.Fatalf("expected no error, but got %q", err)
+				}
+				if b := img.Bounds(); b.Dx() != 1 || b.Dy() != 1 {
+					t.Fatalf("expected a 1x1 pixel, but got %v", b)
+				}
+				if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+					t.Fatalf("expected a transparent pixel, but got alpha %d", a)
+				}
+			}
+			if reported != tc.err {
+				t.Fatalf("expected error %v, but got %v", tc.err, reported)
+			}
+			if !reflect.DeepEqual(store.impressions, tc.recorded) {
+				t.Fatalf("expected impressions %v, but got %v", tc.recorded, store.impressions)
+			}
+		})
+	}
+}
+
+func TestPlacedEvents(t *testing.T) {
+	events := []*bannerEvent{
+		{BannerID: 12, TrackingID: 7, CreatedAt: statsNow},
+		{BannerID: 12, TrackingID: 8, CreatedAt: statsNow},
+		{BannerID: 13, TrackingID: 7, CreatedAt: statsNow},
+		{BannerID: 12, TrackingID: 7, CreatedAt: statsNow.Add(time.Hour)},
+	}
+
+	testCases := []struct {
+		name           string
+		err            error
+		expectedError  error
+		expectedResult []*bannerEvent
+	}{
+		{
+			name: "placed events Success",
+			expectedResult: []*bannerEvent{
+				{BannerID: 12, TrackingID: 7, AffiliateID: 3, CreatedAt: statsNow},
+				{BannerID: 12, TrackingID: 7, AffiliateID: 3, CreatedAt: statsNow.Add(time.Hour)},
+			},
+		},
+		{
+			name:          "placed events Error",
+			err:           fmt.Errorf("error"),
+			expectedError: fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &fakeStatsStore{affiliates: map[[2]int64]int64{{12, 7}: 3}, err: tc.err}
+
+			result, err := placedEvents(context.Background(), store, events)
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+			if !reflect.DeepEqual(result, tc.expectedResult) {
+				t.Fatalf("expected events %v, but got %v", tc.expectedResult, result)
+			}
+			if events[0].AffiliateID != 0 {
+				t.Fatalf("expected the input events to be left alone")
+			}
+		})
+	}
+}
+
+func TestSummarizeBannerStats(t *testing.T) {
+	day1 := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	event := func(bannerID, affiliateID int64, at time.Time) *bannerEvent {
+		return &bannerEvent{BannerID: bannerID, TrackingID: affiliateID * 10, AffiliateID: affiliateID, CreatedAt: at}
+	}
+	repeat := func(n int, e *bannerEvent) []*bannerEvent {
+		events := make([]*bannerEvent, n)
+		for i := range events {
+			events[i] = e
+		}
+		return events
+	}
+
+	var impressions, clicks, conversions []*bannerEvent
+	impressions = append(impressions, repeat(200, event(1, 1, day1.Add(time.Hour)))...)
+	impressions = append(impressions, repeat(50, event(1, 1, day2.Add(time.Hour)))...)
+	impressions = append(impressions, repeat(100, event(1, 2, day1.Add(time.Hour)))...)
+	impressions = append(impressions, repeat(40, event(2, 1, day2.Add(time.Hour)))...)
+	clicks = append(clicks, repeat(10, event(1, 1, day1.Add(2*time.Hour)))...)
+	clicks = append(clicks, repeat(5, event(1, 1, day2.Add(2*time.Hour)))...)
+	clicks = append(clicks, repeat(3, event(1, 2, day1.Add(2*time.Hour)))...)
+	clicks = append(clicks, repeat(2, event(3, 1, day1.Add(2*time.Hour)))...)
+	conversions = append(conversions, repeat(2, event(1, 1, day2.Add(3*time.Hour)))...)
+
+	testCases := []struct {
+		name     string
+		query    bannerStatsQuery
+		expected []*bannerStatsRow
+	}{
+		{
+			name:  "summarize banner stats Success - per banner and affiliate",
+			query: bannerStatsQuery{},
+			expected: []*bannerStatsRow{
+				{BannerID: 1, AffiliateID: 1, Impressions: 250, Clicks: 15, Conversions: 2, CTR: 0.06},
+				{BannerID: 1, AffiliateID: 2, Impressions: 100, Clicks: 3, CTR: 0.03},
+				{BannerID: 2, AffiliateID: 1, Impressions: 40},
+				// Clicks without impressions have no CTR.
+				{BannerID: 3, AffiliateID: 1, Clicks: 2},
+			},
+		},
+		{
+			name:  "summarize banner stats Success - per affiliate per day",
+			query: bannerStatsQuery{AffiliateID: 1, BannerID: 1, GroupByDay: true},
+			expected: []*bannerStatsRow{
+				{BannerID: 1, AffiliateID: 1, Day: day1, Impressions: 200, Clicks: 10, CTR: 0.05},
+				{BannerID: 1, AffiliateID: 1, Day: day2, Impressions: 50, Clicks: 5, Conversions: 2, CTR: 0.1},
+			},
+		},
+		{
+			name:  "summarize banner stats Success - date range",
+			query: bannerStatsQuery{From: day2, To: day2.AddDate(0, 0, 1)},
+			expected: []*bannerStatsRow{
+				{BannerID: 1, AffiliateID: 1, Impressions: 50, Clicks: 5, Conversions: 2, CTR: 0.1},
+				{BannerID: 2, AffiliateID: 1, Impressions: 40},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := summarizeBannerStats(tc.query, impressions, clicks, conversions)
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Fatalf("expected %v, but got %v", tc.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package depositimpl
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DepositChange is a single field-level edit stored with a deposit log entry.
+type DepositChange struct {
+	DepositLogID int64     `db:"deposit_log_id" json:"depositLogId"`
+	Field        string    `db:"field" json:"field"`
+	OldValue     string    `db:"old_value" json:"oldValue"`
+	NewValue     string    `db:"new_value" json:"newValue"`
+	Actor        string    `db:"actor" json:"actor"`
+	Reason       string    `db:"reason" json:"reason"`
+	CreatedAt    time.Time `db:"created_at" json:"createdAt"`
+}
+
+// DepositLogChanges pairs a deposit log entry with the changes recorded on it.
+type DepositLogChanges struct {
+	DepositLogID int64            `json:"depositLogId"`
+	Changes      []*DepositChange `json:"changes"`
+}
+
+// formatAmount renders an amount the way it is stored in the change history:
+// at least two decimals, and more when the amount has them, so sub-cent
+// edits stay visible.
+func formatAmount(amount float64) string {
+	s := strconv.FormatFloat(amount, 'f', -1, 64)
+	dot := strings.IndexByte(s, '.')
+	switch {
+	case dot < 0:
+		return s + ".00"
+	case len(s)-dot-1 < 2:
+		return s + "0"
+	}
+	return s
+}
+
+// amountChange returns the change for an amount edit, or nil when the
+// amount is unchanged.
+func amountChange(oldAmount, newAmount float64, actor, reason string, now time.Time) *DepositChange {
+	if oldAmount == newAmount {
+		return nil
+	}
+
+	return &DepositChange{
+		Field:     "amount",
+		OldValue:  formatAmount(oldAmount),
+		NewValue:  formatAmount(newAmount),
+		Actor:     actor,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+}
+
+// diffFields compares two snapshots of a deposit keyed by field name and
+// returns one change per field whose value differs, ordered by field.
+func diffFields(before, after map[string]string, actor, reason string, now time.Time) []*DepositChange {
+	fields := make([]string, 0, len(after))
+	for field := range after {
+		fields = append(fields, field)
+	}
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := make([]*DepositChange, 0)
+	for _, field := range fields {
+		if before[field] == after[field] {
+			continue
+		}
+
+		changes = append(changes, &DepositChange{
+			Field:     field,
+			OldValue:  before[field],
+			NewValue:  after[field],
+			Actor:     actor,
+			Reason:    reason,
+			CreatedAt: now,
+		})
+	}
+
+	return changes
+}
+
+// attachLogID sets the deposit log entry the changes belong to once the
+// log row has been saved.
+func attachLogID(changes []*DepositChange, depositLogID int64) {
+	for _, change := range changes {
+		change.DepositLogID = depositLogID
+	}
+}
+
+// groupChangesByLog groups changes under their log entries, keeping the
+// order of logIDs so the result lines up with getDepositLog.
+func groupChangesByLog(logIDs []int64, changes []*DepositChange) []*DepositLogChanges {
+	byLog := make(map[int64][]*DepositChange, len(logIDs))
+	for _, change := range changes {
+		byLog[change.DepositLogID] = append(byLog[change.DepositLogID], change)
+	}
+
+	result := make([]*DepositLogChanges, 0, len(logIDs))
+	for _, id := range logIDs {
+		result = append(result, &DepositLogChanges{
+			DepositLogID: id,
+			Changes:      byLog[id],
+		})
+	}
+
+	return result
+}
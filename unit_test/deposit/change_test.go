@@ -0,0 +1,116 @@
+package depositimpl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAmountChange(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		oldAmount      float64
+		newAmount      float64
+		expectedResult *DepositChange
+	}{
+		{
+			name:      "amount change Success",
+			oldAmount: 100,
+			newAmount: 150.5,
+			expectedResult: &DepositChange{
+				Field:     "amount",
+				OldValue:  "100.00",
+				NewValue:  "150.50",
+				Actor:     "admin",
+				Reason:    "wrong amount",
+				CreatedAt: now,
+			},
+		},
+		{
+			name:      "amount change Success- Sub Cent",
+			oldAmount: 100,
+			newAmount: 100.001,
+			expectedResult: &DepositChange{
+				Field:     "amount",
+				OldValue:  "100.00",
+				NewValue:  "100.001",
+				Actor:     "admin",
+				Reason:    "wrong amount",
+				CreatedAt: now,
+			},
+		},
+		{
+			name:           "amount change Success- Unchanged",
+			oldAmount:      100.25,
+			newAmount:      100.25,
+			expectedResult: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := amountChange(tc.oldAmount, tc.newAmount, "admin", "wrong amount", now)
+			if tc.expectedResult == nil {
+				if result != nil {
+					t.Fatalf("expected no change, got %+v", result)
+				}
+				return
+			}
+
+			if result == nil || *result != *tc.expectedResult {
+				t.Fatalf("expected %+v, got %+v", tc.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestDiffFields(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	before := map[string]string{"amount": "100.00", "currency": "USD", "note": "first"}
+	after := map[string]string{"amount": "150.00", "currency": "USD", "partner_transaction_id": "tx-1"}
+
+	result := diffFields(before, after, "admin", "dispute", now)
+
+	expected := []DepositChange{
+		{Field: "amount", OldValue: "100.00", NewValue: "150.00", Actor: "admin", Reason: "dispute", CreatedAt: now},
+		{Field: "note", OldValue: "first", NewValue: "", Actor: "admin", Reason: "dispute", CreatedAt: now},
+		{Field: "partner_transaction_id", OldValue: "", NewValue: "tx-1", Actor: "admin", Reason: "dispute", CreatedAt: now},
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("expected %d changes, got %d", len(expected), len(result))
+	}
+	for i := range expected {
+		if *result[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], *result[i])
+		}
+	}
+}
+
+func TestGroupChangesByLog(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	first := []*DepositChange{amountChange(100, 150, "admin", "wrong amount", now)}
+	attachLogID(first, 1)
+	second := []*DepositChange{amountChange(150, 120, "finance", "partial chargeback", now)}
+	attachLogID(second, 3)
+
+	result := groupChangesByLog([]int64{1, 2, 3}, append(first, second...))
+
+	if len(result) != 3 {
+		t.Fatalf("expected 3 log entries, got %d", len(result))
+	}
+	if result[1].DepositLogID != 2 || len(result[1].Changes) != 0 {
+		t.Errorf("expected log 2 without changes, got %+v", result[1])
+	}
+
+	change := result[2].Changes[0]
+	if change.OldValue != "150.00" || change.NewValue != "120.00" || change.Actor != "finance" || change.Reason != "partial chargeback" {
+		t.Errorf("unexpected change for log 3: %+v", change)
+	}
+	if result[0].Changes[0].DepositLogID != 1 {
+		t.Errorf("expected change linked to log 1, got %d", result[0].Changes[0].DepositLogID)
+	}
+}
@@ -0,0 +1,135 @@
+package depositimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrDepositNotFound = errors.New("deposit not found")
+
+// depositEdit changes the amount and/or partner transaction ID of a
+// deposit; nil fields are left as they are.
+type depositEdit struct {
+	ID                   int64
+	Amount               *float64
+	PartnerTransactionID *string
+	Actor                string
+	Reason               string
+	UpdatedBy            int64
+	UpdatedAt            time.Time
+}
+
+type editableDeposit struct {
+	Amount               float64 `db:"amount"`
+	PartnerTransactionID string  `db:"partner_transaction_id"`
+}
+
+const (
+	selectEditableDepositSQL = `SELECT amount, partner_transaction_id FROM deposit WHERE id = ? FOR UPDATE`
+
+	updateEditableDepositSQL = `UPDATE deposit
+SET amount = ?, partner_transaction_id = ?, updated_by = ?, updated_at = ?
+WHERE id = ?`
+
+	insertDepositLogSQL = `INSERT INTO deposit_log (deposit_id, action, created_by, created_at) VALUES (?, ?, ?, ?)`
+
+	insertDepositChangeSQL = `INSERT INTO deposit_change
+(deposit_log_id, field, old_value, new_value, actor, reason, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	selectDepositChangesSQL = `SELECT deposit_log_id, field, old_value, new_value, actor, reason, created_at
+FROM deposit_change
+WHERE deposit_log_id IN (%s)
+ORDER BY deposit_log_id, id`
+)
+
+// editDeposit applies e and writes a deposit log entry with one change row
+// per edited field, all in one transaction. The deposit row is locked while
+// it is compared, so two edits cannot record the same old value. An edit
+// that changes nothing writes nothing.
+func editDeposit[T sqlTx](ctx context.Context, db txBeginner[T], e depositEdit) error {
+	return inTx(ctx, db, func(tx dbConn) error {
+		var current editableDeposit
+		err := tx.GetContext(ctx, &current, selectEditableDepositSQL, e.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrDepositNotFound, e.ID)
+		} else if err != nil {
+			return err
+		}
+
+		next := current
+		var changes []*DepositChange
+		if e.Amount != nil {
+			next.Amount = *e.Amount
+			if change := amountChange(current.Amount, next.Amount, e.Actor, e.Reason, e.UpdatedAt); change != nil {
+				changes = append(changes, change)
+			}
+		}
+		if e.PartnerTransactionID != nil {
+			next.PartnerTransactionID = *e.PartnerTransactionID
+			changes = append(changes, diffFields(
+				map[string]string{"partner_transaction_id": current.PartnerTransactionID},
+				map[string]string{"partner_transaction_id": next.PartnerTransactionID},
+				e.Actor, e.Reason, e.UpdatedAt,
+			)...)
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, updateEditableDepositSQL,
+			next.Amount, next.PartnerTransactionID, e.UpdatedBy, e.UpdatedAt, e.ID); err != nil {
+			return err
+		}
+
+		return saveDepositChanges(ctx, tx, e.ID, "edit", e.UpdatedBy, e.UpdatedAt, changes)
+	})
+}
+
+// saveDepositChanges writes a deposit log entry and the changes recorded on
+// it. It is called inside the transaction that made the changes.
+func saveDepositChanges(ctx context.Context, tx dbConn, depositID int64, action string, by int64, at time.Time, changes []*DepositChange) error {
+	result, err := tx.ExecContext(ctx, insertDepositLogSQL, depositID, action, by, at)
+	if err != nil {
+		return err
+	}
+	logID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	attachLogID(changes, logID)
+	for _, c := range changes {
+		if _, err := tx.ExecContext(ctx, insertDepositChangeSQL,
+			c.DepositLogID, c.Field, c.OldValue, c.NewValue, c.Actor, c.Reason, c.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getDepositLogChanges loads the changes of the given log entries, as
+// returned by getDepositLog, grouped in the same order.
+func getDepositLogChanges(ctx context.Context, db dbConn, logIDs []int64) ([]*DepositLogChanges, error) {
+	if len(logIDs) == 0 {
+		return []*DepositLogChanges{}, nil
+	}
+
+	args := make([]any, len(logIDs))
+	for i, id := range logIDs {
+		args[i] = id
+	}
+	query := fmt.Sprintf(selectDepositChangesSQL, strings.TrimSuffix(strings.Repeat("?, ", len(logIDs)), ", "))
+
+	var changes []*DepositChange
+	if err := db.SelectContext(ctx, &changes, query, args...); err != nil {
+		return nil, err
+	}
+
+	return groupChangesByLog(logIDs, changes), nil
+}
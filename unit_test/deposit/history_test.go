@@ -0,0 +1,270 @@
+package depositimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"main/pkg/affiliate/deposit"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeDepositLog struct {
+	ID        int64
+	DepositID int64
+	Action    string
+}
+
+type versionedStatus struct {
+	Status  deposit.Status
+	Version int64
+}
+
+// fakeDepositState is what a fakeDepositTx works on; it is copied into the
+// fakeDepositDB only on commit.
+type fakeDepositState struct {
+	deposits map[int64]editableDeposit
+	statuses map[int64]versionedStatus
+	logs     []fakeDepositLog
+	changes  []*DepositChange
+}
+
+func (s fakeDepositState) clone() fakeDepositState {
+	return fakeDepositState{
+		deposits: maps.Clone(s.deposits),
+		statuses: maps.Clone(s.statuses),
+		logs:     slices.Clone(s.logs),
+		changes:  slices.Clone(s.changes),
+	}
+}
+
+// fakeDepositDB runs the deposit write queries against in-memory rows.
+// failOn makes the query starting with that text fail.
+type fakeDepositDB struct {
+	fakeDepositState
+	failOn string
+}
+
+func (db *fakeDepositDB) BeginTxx(context.Context, *sql.TxOptions) (*fakeDepositTx, error) {
+	return &fakeDepositTx{db: db, fakeDepositState: db.clone()}, nil
+}
+
+type fakeDepositTx struct {
+	fakeDepositState
+	db *fakeDepositDB
+}
+
+func (tx *fakeDepositTx) Commit() error {
+	tx.db.fakeDepositState = tx.fakeDepositState
+	return nil
+}
+
+func (tx *fakeDepositTx) Rollback() error { return nil }
+
+func (tx *fakeDepositTx) fail(query string) error {
+	if tx.db.failOn != "" && strings.HasPrefix(query, tx.db.failOn) {
+		return fmt.Errorf("error")
+	}
+	return nil
+}
+
+func (tx *fakeDepositTx) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	if err := tx.fail(query); err != nil {
+		return nil, err
+	}
+
+	switch query {
+	case updateEditableDepositSQL:
+		tx.deposits[args[4].(int64)] = editableDeposit{Amount: args[0].(float64), PartnerTransactionID: args[1].(string)}
+	case updateDepositStatusSQL:
+		id := args[3].(int64)
+		current, ok := tx.statuses[id]
+		if !ok || current.Version != args[4].(int64) {
+			return fakeResult{}, nil
+		}
+		tx.statuses[id] = versionedStatus{Status: args[0].(deposit.Status), Version: current.Version + 1}
+	case insertDepositLogSQL:
+		id := int64(len(tx.logs) + 1)
+		tx.logs = append(tx.logs, fakeDepositLog{ID: id, DepositID: args[0].(int64), Action: args[1].(string)})
+		return fakeResult{lastInsertID: id, rowsAffected: 1}, nil
+	case insertDepositChangeSQL:
+		tx.changes = append(tx.changes, &DepositChange{
+			DepositLogID: args[0].(int64), Field: args[1].(string), OldValue: args[2].(string), NewValue: args[3].(string),
+			Actor: args[4].(string), Reason: args[5].(string), CreatedAt: args[6].(time.Time),
+		})
+	default:
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+
+	return fakeResult{rowsAffected: 1}, nil
+}
+
+func (tx *fakeDepositTx) GetContext(_ context.Context, dest any, query string, args ...any) error {
+	if err := tx.fail(query); err != nil {
+		return err
+	}
+
+	switch query {
+	case selectEditableDepositSQL:
+		d, ok := tx.deposits[args[0].(int64)]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		*dest.(*editableDeposit) = d
+	case selectDepositStatusSQL, selectDepositVersionSQL:
+		current, ok := tx.statuses[args[0].(int64)]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		if query == selectDepositStatusSQL {
+			*dest.(*deposit.Status) = current.Status
+		} else {
+			*dest.(*int64) = current.Version
+		}
+	default:
+		return fmt.Errorf("unexpected query %q", query)
+	}
+
+	return nil
+}
+
+func (tx *fakeDepositTx) SelectContext(_ context.Context, dest any, query string, args ...any) error {
+	if err := tx.fail(query); err != nil {
+		return err
+	}
+
+	ids := map[int64]bool{}
+	for _, arg := range args {
+		ids[arg.(int64)] = true
+	}
+	for _, c := range tx.changes {
+		if ids[c.DepositLogID] {
+			*dest.(*[]*DepositChange) = append(*dest.(*[]*DepositChange), c)
+		}
+	}
+
+	return nil
+}
+
+func TestEditDeposit(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	amount := func(v float64) *float64 { return &v }
+	text := func(v string) *string { return &v }
+
+	testCases := []struct {
+		name             string
+		edit             depositEdit
+		failOn           string
+		expectedError    error
+		expectedDeposit  editableDeposit
+		expectedChanges  []string
+		expectedLogCount int
+	}{
+		{
+			name:             "edit deposit Success",
+			edit:             depositEdit{ID: 1, Amount: amount(150.5), PartnerTransactionID: text("tx-2")},
+			expectedDeposit:  editableDeposit{Amount: 150.5, PartnerTransactionID: "tx-2"},
+			expectedChanges:  []string{"amount: 100.00 -> 150.50", "partner_transaction_id: tx-1 -> tx-2"},
+			expectedLogCount: 1,
+		},
+		{
+			name:             "edit deposit Success- Sub Cent",
+			edit:             depositEdit{ID: 1, Amount: amount(100.004)},
+			expectedDeposit:  editableDeposit{Amount: 100.004, PartnerTransactionID: "tx-1"},
+			expectedChanges:  []string{"amount: 100.00 -> 100.004"},
+			expectedLogCount: 1,
+		},
+		{
+			name:            "edit deposit Success- Unchanged",
+			edit:            depositEdit{ID: 1, Amount: amount(100), PartnerTransactionID: text("tx-1")},
+			expectedDeposit: editableDeposit{Amount: 100, PartnerTransactionID: "tx-1"},
+		},
+		{
+			name:            "edit deposit Error- Not Found",
+			edit:            depositEdit{ID: 2, Amount: amount(100)},
+			expectedError:   ErrDepositNotFound,
+			expectedDeposit: editableDeposit{Amount: 100, PartnerTransactionID: "tx-1"},
+		},
+		{
+			name:            "edit deposit Error- Rolled Back",
+			edit:            depositEdit{ID: 1, Amount: amount(150)},
+			failOn:          "INSERT INTO deposit_change",
+			expectedError:   fmt.Errorf("error"),
+			expectedDeposit: editableDeposit{Amount: 100, PartnerTransactionID: "tx-1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &fakeDepositDB{
+				fakeDepositState: fakeDepositState{deposits: map[int64]editableDeposit{1: {Amount: 100, PartnerTransactionID: "tx-1"}}},
+				failOn:           tc.failOn,
+			}
+			tc.edit.Actor, tc.edit.Reason, tc.edit.UpdatedAt = "admin", "dispute", now
+
+			err := editDeposit(context.Background(), db, tc.edit)
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+			if errors.Is(tc.expectedError, ErrDepositNotFound) && !errors.Is(err, ErrDepositNotFound) {
+				t.Fatalf("expected error %q, but got %q", ErrDepositNotFound, err)
+			}
+
+			if db.deposits[1] != tc.expectedDeposit {
+				t.Fatalf("expected deposit %+v, but got %+v", tc.expectedDeposit, db.deposits[1])
+			}
+			if len(db.logs) != tc.expectedLogCount {
+				t.Fatalf("expected %d log entries, but got %d", tc.expectedLogCount, len(db.logs))
+			}
+			var changes []string
+			for _, c := range db.changes {
+				if c.DepositLogID != 1 || c.Actor != "admin" || c.Reason != "dispute" {
+					t.Fatalf("expected change on log 1 by admin, but got %+v", c)
+				}
+				changes = append(changes, c.Field+": "+c.OldValue+" -> "+c.NewValue)
+			}
+			if !reflect.DeepEqual(changes, tc.expectedChanges) {
+				t.Fatalf("expected changes %v, but got %v", tc.expectedChanges, changes)
+			}
+		})
+	}
+}
+
+func TestGetDepositLogChanges(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	db := &fakeDepositDB{fakeDepositState: fakeDepositState{deposits: map[int64]editableDeposit{1: {Amount: 100}}}}
+
+	for _, v := range []float64{150, 120} {
+		if err := editDeposit(context.Background(), db, depositEdit{ID: 1, Amount: &v, Actor: "finance", UpdatedAt: now}); err != nil {
+			t.Fatalf("expected no error, but got %q", err)
+		}
+	}
+
+	tx, _ := db.BeginTxx(context.Background(), nil)
+	result, err := getDepositLogChanges(context.Background(), tx, []int64{2, 3, 1})
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+
+	if len(result) != 3 || result[0].DepositLogID != 2 || result[1].DepositLogID != 3 || result[2].DepositLogID != 1 {
+		t.Fatalf("expected the log order to be kept, but got %+v", result)
+	}
+	if len(result[1].Changes) != 0 {
+		t.Fatalf("expected log 3 without changes, but got %+v", result[1].Changes)
+	}
+	if c := result[0].Changes[0]; c.OldValue != "150.00" || c.NewValue != "120.00" {
+		t.Fatalf("expected 150.00 -> 120.00 on log 2, but got %+v", c)
+	}
+
+	empty, err := getDepositLogChanges(context.Background(), tx, nil)
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("expected no changes and no error, but got %v, %v", empty, err)
+	}
+}
@@ -0,0 +1,40 @@
+package depositimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// dbConn is the part of *sqlx.DB and *sqlx.Tx the deposit writes use.
+type dbConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+type sqlTx interface {
+	dbConn
+	Commit() error
+	Rollback() error
+}
+
+// txBeginner is satisfied by *sqlx.DB.
+type txBeginner[T sqlTx] interface {
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (T, error)
+}
+
+// inTx runs fn in a transaction, committing when it returns nil and rolling
+// back otherwise.
+func inTx[T sqlTx](ctx context.Context, db txBeginner[T], fn func(tx dbConn) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,99 @@
+package depositimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"main/pkg/affiliate/deposit"
+	"time"
+)
+
+var ErrConcurrentModification = errors.New("deposit was modified since it was read")
+
+// versionConn is the part of dbConn updateDepositStatusCAS uses.
+type versionConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+// depositStatusUpdate moves a deposit to Status only if it is still at the
+// Version it was read with. Actor and Reason are recorded with the change.
+type depositStatusUpdate struct {
+	ID        int64
+	Version   int64
+	Status    deposit.Status
+	Actor     string
+	Reason    string
+	UpdatedBy int64
+	UpdatedAt time.Time
+}
+
+const (
+	updateDepositStatusSQL = `UPDATE deposit
+SET status = ?, version = version + 1, updated_by = ?, updated_at = ?
+WHERE id = ? AND version = ?`
+
+	selectDepositVersionSQL = `SELECT version FROM deposit WHERE id = ?`
+
+	selectDepositStatusSQL = `SELECT status FROM deposit WHERE id = ? FOR UPDATE`
+)
+
+// updateDepositStatusCAS returns the new version, ErrDepositNotFound when
+// there is no such deposit, or ErrConcurrentModification when another
+// operator changed the deposit first.
+func updateDepositStatusCAS(ctx context.Context, db versionConn, u depositStatusUpdate) (int64, error) {
+	result, err := db.ExecContext(ctx, updateDepositStatusSQL, u.Status, u.UpdatedBy, u.UpdatedAt, u.ID, u.Version)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		var current int64
+		err := db.GetContext(ctx, &current, selectDepositVersionSQL, u.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: %d", ErrDepositNotFound, u.ID)
+		} else if err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: deposit %d at version %d, now %d", ErrConcurrentModification, u.ID, u.Version, current)
+	}
+
+	return u.Version + 1, nil
+}
+
+// updateDepositStatus moves a deposit to u.Status with updateDepositStatusCAS
+// and records the move in the change history, in one transaction. It
+// returns the new version.
+func updateDepositStatus[T sqlTx](ctx context.Context, db txBeginner[T], u depositStatusUpdate) (int64, error) {
+	var version int64
+	err := inTx(ctx, db, func(tx dbConn) error {
+		var current deposit.Status
+		err := tx.GetContext(ctx, &current, selectDepositStatusSQL, u.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrDepositNotFound, u.ID)
+		} else if err != nil {
+			return err
+		}
+
+		if version, err = updateDepositStatusCAS(ctx, tx, u); err != nil {
+			return err
+		}
+
+		changes := diffFields(
+			map[string]string{"status": fmt.Sprint(current)},
+			map[string]string{"status": fmt.Sprint(u.Status)},
+			u.Actor, u.Reason, u.UpdatedAt,
+		)
+		return saveDepositChanges(ctx, tx, u.ID, "status", u.UpdatedBy, u.UpdatedAt, changes)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
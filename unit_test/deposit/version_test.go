@@ -0,0 +1,206 @@
+package depositimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"main/pkg/affiliate/deposit"
+	"testing"
+	"time"
+)
+
+type fakeResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+// fakeDepositRows applies the update against in-memory versions, so a stale
+// version affects no rows.
+type fakeDepositRows struct {
+	versions map[int64]int64
+	err      error
+}
+
+func (f *fakeDepositRows) ExecContext(_ context.Context, _ string, args ...any) (sql.Result, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	id, version := args[3].(int64), args[4].(int64)
+	if current, ok := f.versions[id]; !ok || current != version {
+		return fakeResult{}, nil
+	}
+	f.versions[id]++
+
+	return fakeResult{rowsAffected: 1}, nil
+}
+
+func (f *fakeDepositRows) GetContext(_ context.Context, dest any, _ string, args ...any) error {
+	version, ok := f.versions[args[0].(int64)]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*dest.(*int64) = version
+	return nil
+}
+
+func TestUpdateDepositStatusCAS(t *testing.T) {
+	testCases := []struct {
+		name            string
+		update          depositStatusUpdate
+		err             error
+		expectedError   error
+		expectedVersion int64
+	}{
+		{
+			name:            "update deposit status Success",
+			update:          depositStatusUpdate{ID: 1, Version: 3, Status: 2},
+			expectedVersion: 4,
+		},
+		{
+			name:          "update deposit status Error- Concurrent Modification",
+			update:        depositStatusUpdate{ID: 1, Version: 2, Status: 3},
+			expectedError: ErrConcurrentModification,
+		},
+		{
+			name:          "update deposit status Error- Not Found",
+			update:        depositStatusUpdate{ID: 2, Version: 1, Status: 2},
+			expectedError: ErrDepositNotFound,
+		},
+		{
+			name:          "update deposit status Error",
+			update:        depositStatusUpdate{ID: 1, Version: 3, Status: 2},
+			err:           fmt.Errorf("error"),
+			expectedError: fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &fakeDepositRows{versions: map[int64]int64{1: 3}, err: tc.err}
+			tc.update.UpdatedAt = time.Now()
+
+			version, err := updateDepositStatusCAS(context.Background(), db, tc.update)
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, target := range []error{ErrConcurrentModification, ErrDepositNotFound} {
+				if errors.Is(tc.expectedError, target) != errors.Is(err, target) {
+					t.Fatalf("expected error %q, got %q", tc.expectedError, err)
+				}
+			}
+			if version != tc.expectedVersion {
+				t.Fatalf("expected version %d, got %d", tc.expectedVersion, version)
+			}
+		})
+	}
+}
+
+func TestUpdateDepositStatusCASRace(t *testing.T) {
+	db := &fakeDepositRows{versions: map[int64]int64{1: 1}}
+
+	_, first := updateDepositStatusCAS(context.Background(), db, depositStatusUpdate{ID: 1, Version: 1, Status: 2, UpdatedBy: 10})
+	_, second := updateDepositStatusCAS(context.Background(), db, depositStatusUpdate{ID: 1, Version: 1, Status: 3, UpdatedBy: 11})
+
+	if first != nil {
+		t.Fatalf("unexpected error: %v", first)
+	}
+	if !errors.Is(second, ErrConcurrentModification) {
+		t.Fatalf("expected error %q, got %v", ErrConcurrentModification, second)
+	}
+}
+
+func TestUpdateDepositStatus(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name            string
+		update          depositStatusUpdate
+		failOn          string
+		expectedError   error
+		expectedStatus  deposit.Status
+		expectedChanges []string
+	}{
+		{
+			name:            "update deposit status Success",
+			update:          depositStatusUpdate{ID: 1, Version: 3, Status: 2},
+			expectedStatus:  2,
+			expectedChanges: []string{"status: 1 -> 2"},
+		},
+		{
+			name:           "update deposit status Error- Concurrent Modification",
+			update:         depositStatusUpdate{ID: 1, Version: 2, Status: 2},
+			expectedError:  ErrConcurrentModification,
+			expectedStatus: 1,
+		},
+		{
+			name:           "update deposit status Error- Not Found",
+			update:         depositStatusUpdate{ID: 2, Version: 1, Status: 2},
+			expectedError:  ErrDepositNotFound,
+			expectedStatus: 1,
+		},
+		{
+			name:           "update deposit status Error- Rolled Back",
+			update:         depositStatusUpdate{ID: 1, Version: 3, Status: 2},
+			failOn:         "INSERT INTO deposit_change",
+			expectedError:  fmt.Errorf("error"),
+			expectedStatus: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &fakeDepositDB{
+				fakeDepositState: fakeDepositState{
+					deposits: map[int64]editableDeposit{1: {Amount: 100}},
+					statuses: map[int64]versionedStatus{1: {Status: 1, Version: 3}},
+				},
+				failOn: tc.failOn,
+			}
+			tc.update.Actor, tc.update.Reason, tc.update.UpdatedAt = "admin", "checked", now
+
+			version, err := updateDepositStatus(context.Background(), db, tc.update)
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, target := range []error{ErrConcurrentModification, ErrDepositNotFound} {
+				if errors.Is(tc.expectedError, target) != errors.Is(err, target) {
+					t.Fatalf("expected error %q, got %q", tc.expectedError, err)
+				}
+			}
+
+			if got := db.statuses[1].Status; got != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatus, got)
+			}
+			if tc.expectedError != nil {
+				if len(db.logs) != 0 || len(db.changes) != 0 {
+					t.Fatalf("expected nothing to be written, got %+v", db.fakeDepositState)
+				}
+				return
+			}
+
+			if version != 4 {
+				t.Fatalf("expected version %d, got %d", 4, version)
+			}
+			if len(db.logs) != 1 || db.logs[0].Action != "status" {
+				t.Fatalf("expected one status log, got %+v", db.logs)
+			}
+			var changes []string
+			for _, c := range db.changes {
+				changes = append(changes, c.Field+": "+c.OldValue+" -> "+c.NewValue)
+			}
+			if fmt.Sprint(changes) != fmt.Sprint(tc.expectedChanges) {
+				t.Fatalf("expected changes %v, got %v", tc.expectedChanges, changes)
+			}
+		})
+	}
+}
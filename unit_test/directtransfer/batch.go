@@ -0,0 +1,448 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingBatchColumn = errors.New("batch csv is missing a required column")
+	ErrEmptyBatch         = errors.New("batch csv has no rows")
+	ErrInvalidAmount      = errors.New("invalid amount")
+	ErrBatchNotFound      = errors.New("batch not found")
+)
+
+var requiredBatchColumns = []string{"affiliate_id", "currency", "amount", "transaction_id"}
+
+type batchRowStatus string
+
+const (
+	batchRowValid     batchRowStatus = "valid"
+	batchRowInvalid   batchRowStatus = "invalid"
+	batchRowCompleted batchRowStatus = "completed"
+	batchRowFailed    batchRowStatus = "failed"
+)
+
+type batchStatus string
+
+const (
+	batchRunning         batchStatus = "running"
+	batchCompleted       batchStatus = "completed"
+	batchPartiallyFailed batchStatus = "partially_failed"
+	batchFailed          batchStatus = "failed"
+)
+
+// batchRow is one CSV line. Amount is in minor units.
+type batchRow struct {
+	Line          int
+	AffiliateID   int64
+	Currency      string
+	Amount        int64
+	TransactionID string
+	Note          string
+	Status        batchRowStatus
+	Errors        []string
+	TransferID    int64
+}
+
+func (r *batchRow) invalidate(format string, args ...any) {
+	r.Status = batchRowInvalid
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+type batchRules struct {
+	Currencies []string
+	MaxAmount  int64
+}
+
+// batchLookup is what the preview reads. Its limits are checked against
+// each affiliate's running total in the batch, not one row at a time.
+type batchLookup interface {
+	limitSource
+	affiliateExists(ctx context.Context, affiliateID int64) (bool, error)
+	transactionExists(ctx context.Context, transactionID string) (bool, error)
+}
+
+type batchPreview struct {
+	Rows    []*batchRow
+	Valid   int
+	Invalid int
+	Totals  map[string]int64
+}
+
+type batchLog struct {
+	Action    string    `db:"action"`
+	Note      string    `db:"note"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type transferBatch struct {
+	ID     int64
+	Status batchStatus
+	Rows   []*batchRow
+	Logs   []batchLog
+}
+
+// batchLimitSource adds the rows already accepted in a preview to the
+// stored usage, as if they had been created.
+type batchLimitSource struct {
+	limitSource
+	accepted []usageRow
+}
+
+func (s *batchLimitSource) getTransferUsage(ctx context.Context, affiliateID int64, currency string, now time.Time) (transferUsage, error) {
+	u, err := s.limitSource.getTransferUsage(ctx, affiliateID, currency, now)
+	if err != nil {
+		return transferUsage{}, err
+	}
+
+	pending := usageFromTransfers(s.accepted, affiliateID, currency, now)
+	u.HourlyCount += pending.HourlyCount
+	u.DailyTotal += pending.DailyTotal
+	u.MonthlyTotal += pending.MonthlyTotal
+
+	return u, nil
+}
+
+// parseBatchCSV reads a batch upload. Columns are matched by header name;
+// rows that cannot be parsed are kept and marked invalid so the preview can
+// show them.
+func parseBatchCSV(r io.Reader) ([]*batchRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, ErrEmptyBatch
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range requiredBatchColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMissingBatchColumn, name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	rows := make([]*batchRow, 0)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := &batchRow{
+			Line:          line,
+			Currency:      strings.ToUpper(field(record, "currency")),
+			TransactionID: field(record, "transaction_id"),
+			Note:          field(record, "note"),
+			Status:        batchRowValid,
+		}
+
+		if id, err := strconv.ParseInt(field(record, "affiliate_id"), 10, 64); err != nil || id <= 0 {
+			row.invalidate("invalid affiliate_id %q", field(record, "affiliate_id"))
+		} else {
+			row.AffiliateID = id
+		}
+
+		if amount, err := parseMinorUnits(field(record, "amount")); err != nil {
+			row.invalidate("invalid amount %q", field(record, "amount"))
+		} else {
+			row.Amount = amount
+		}
+
+		if row.TransactionID == "" {
+			row.invalidate("transaction_id is required")
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	return rows, nil
+}
+
+// parseMinorUnits parses a positive decimal amount with at most two
+// fractional digits into minor units.
+func parseMinorUnits(s string) (int64, error) {
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" || len(fraction) > 2 || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return 0, ErrInvalidAmount
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+
+	var cents int64
+	if fraction != "" {
+		cents, err = strconv.ParseInt((fraction + "0")[:2], 10, 64)
+		if err != nil || strings.HasPrefix(fraction, "-") || strings.HasPrefix(fraction, "+") {
+			return 0, ErrInvalidAmount
+		}
+	}
+
+	amount := units*100 + cents
+	if amount <= 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	return amount, nil
+}
+
+// previewBatch is the dry run: every row is checked against the rules, the
+// affiliates, existing transfers and the transfer limits, and nothing is
+// written. Rows are checked in order, so a row that would take its
+// affiliate over a limit together with the rows above it is invalid.
+func previewBatch(ctx context.Context, rows []*batchRow, lookup batchLookup, rules batchRules, now time.Time) (*batchPreview, error) {
+	currencies := make(map[string]bool, len(rules.Currencies))
+	for _, c := range rules.Currencies {
+		currencies[strings.ToUpper(c)] = true
+	}
+
+	preview := &batchPreview{Rows: rows, Totals: make(map[string]int64)}
+	seen := make(map[string]int)
+	limits := &batchLimitSource{limitSource: lookup}
+
+	for _, row := range rows {
+		if row.Currency == "" || !currencies[row.Currency] {
+			row.invalidate("unsupported currency %q", row.Currency)
+		}
+
+		if rules.MaxAmount > 0 && row.Amount > rules.MaxAmount {
+			row.invalidate("amount exceeds the limit of %d", rules.MaxAmount)
+		}
+
+		if row.TransactionID != "" {
+			if first, ok := seen[row.TransactionID]; ok {
+				row.invalidate("duplicate transaction_id, first seen on line %d", first)
+			} else {
+				seen[row.TransactionID] = row.Line
+
+				exists, err := lookup.transactionExists(ctx, row.TransactionID)
+				if err != nil {
+					return nil, err
+				}
+				if exists {
+					row.invalidate("transaction_id %q already used", row.TransactionID)
+				}
+			}
+		}
+
+		if row.AffiliateID > 0 {
+			exists, err := lookup.affiliateExists(ctx, row.AffiliateID)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				row.invalidate("affiliate %d not found", row.AffiliateID)
+			}
+		}
+
+		if row.Status == batchRowValid {
+			err := checkTransferLimits(ctx, limits, row.AffiliateID, row.Currency, row.Amount, now)
+			var exceeded *LimitExceededError
+			if errors.As(err, &exceeded) {
+				row.invalidate("%v", exceeded)
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		if row.Status == batchRowValid {
+			preview.Valid++
+			preview.Totals[row.Currency] += row.Amount
+			limits.accepted = append(limits.accepted, usageRow{
+				AffiliateID: row.AffiliateID, Currency: row.Currency, Amount: row.Amount, CreatedAt: now,
+			})
+		} else {
+			preview.Invalid++
+		}
+	}
+
+	return preview, nil
+}
+
+const (
+	insertBatchSQL = `INSERT INTO direct_transfer_batch (status, valid_rows, invalid_rows, created_by, created_at) VALUES (?, ?, ?, ?, ?)`
+
+	finishBatchSQL = `UPDATE direct_transfer_batch SET status = ?, finished_at = ? WHERE id = ?`
+
+	insertBatchRowSQL = `INSERT INTO direct_transfer_batch_row
+(batch_id, line, affiliate_id, currency, amount, transaction_id, note, status, errors)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	updateBatchRowSQL = `UPDATE direct_transfer_batch_row
+SET status = ?, errors = ?, transfer_id = NULLIF(?, 0)
+WHERE batch_id = ? AND line = ?`
+
+	insertBatchLogSQL = `INSERT INTO direct_transfer_batch_log (batch_id, action, note, created_at) VALUES (?, ?, ?, ?)`
+
+	selectBatchSQL = `SELECT status FROM direct_transfer_batch WHERE id = ?`
+
+	selectBatchRowsSQL = `SELECT line, affiliate_id, currency, amount, transaction_id, note, status, errors, COALESCE(transfer_id, 0) AS transfer_id
+FROM direct_transfer_batch_row
+WHERE batch_id = ?
+ORDER BY line`
+
+	selectBatchLogsSQL = `SELECT action, note, created_at FROM direct_transfer_batch_log WHERE batch_id = ? ORDER BY id`
+)
+
+// batchRowRecord is a direct_transfer_batch_row; Errors are one per line.
+type batchRowRecord struct {
+	Line          int            `db:"line"`
+	AffiliateID   int64          `db:"affiliate_id"`
+	Currency      string         `db:"currency"`
+	Amount        int64          `db:"amount"`
+	TransactionID string         `db:"transaction_id"`
+	Note          string         `db:"note"`
+	Status        batchRowStatus `db:"status"`
+	Errors        string         `db:"errors"`
+	TransferID    int64          `db:"transfer_id"`
+}
+
+// executeBatch saves the preview as a batch and creates a transfer for
+// every valid row, recording the outcome per row and for the batch as a
+// whole as it goes. Each transfer is created in its own transaction by
+// create, so a batch that stops half way stays running with the rows it
+// got through; getBatch shows where it is.
+func executeBatch(ctx context.Context, db dbConn, preview *batchPreview, createdBy int64, create func(context.Context, *batchRow) (int64, error), now func() time.Time) (*transferBatch, error) {
+	batch := &transferBatch{Status: batchRunning, Rows: preview.Rows}
+
+	result, err := db.ExecContext(ctx, insertBatchSQL, batch.Status, preview.Valid, preview.Invalid, createdBy, now())
+	if err != nil {
+		return nil, err
+	}
+	if batch.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	for _, row := range preview.Rows {
+		if _, err := db.ExecContext(ctx, insertBatchRowSQL, batch.ID, row.Line, row.AffiliateID, row.Currency,
+			row.Amount, row.TransactionID, row.Note, row.Status, strings.Join(row.Errors, "\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := batch.log(ctx, db, "started", fmt.Sprintf("%d valid, %d invalid rows", preview.Valid, preview.Invalid), now()); err != nil {
+		return nil, err
+	}
+
+	completed, failed := 0, 0
+	for _, row := range preview.Rows {
+		if row.Status != batchRowValid {
+			continue
+		}
+
+		id, err := create(ctx, row)
+		if err != nil {
+			row.Status = batchRowFailed
+			row.Errors = append(row.Errors, err.Error())
+			failed++
+			if err := batch.log(ctx, db, "row_failed", fmt.Sprintf("line %d: %v", row.Line, err), now()); err != nil {
+				return nil, err
+			}
+		} else {
+			row.Status = batchRowCompleted
+			row.TransferID = id
+			completed++
+		}
+
+		if _, err := db.ExecContext(ctx, updateBatchRowSQL,
+			row.Status, strings.Join(row.Errors, "\n"), row.TransferID, batch.ID, row.Line); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case completed == 0:
+		batch.Status = batchFailed
+	case failed == 0:
+		batch.Status = batchCompleted
+	default:
+		batch.Status = batchPartiallyFailed
+	}
+
+	finishedAt := now()
+	if _, err := db.ExecContext(ctx, finishBatchSQL, batch.Status, finishedAt, batch.ID); err != nil {
+		return nil, err
+	}
+	note := fmt.Sprintf("%d completed, %d failed, %d skipped", completed, failed, preview.Invalid)
+	if err := batch.log(ctx, db, string(batch.Status), note, finishedAt); err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+func (b *transferBatch) log(ctx context.Context, db dbConn, action, note string, at time.Time) error {
+	if _, err := db.ExecContext(ctx, insertBatchLogSQL, b.ID, action, note, at); err != nil {
+		return err
+	}
+
+	b.Logs = append(b.Logs, batchLog{Action: action, Note: note, CreatedAt: at})
+
+	return nil
+}
+
+// getBatch loads a batch with its rows and log, to follow one that is
+// running or review one that has finished.
+func getBatch(ctx context.Context, db dbConn, id int64) (*transferBatch, error) {
+	batch := &transferBatch{ID: id}
+	err := db.GetContext(ctx, &batch.Status, selectBatchSQL, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrBatchNotFound, id)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var records []batchRowRecord
+	if err := db.SelectContext(ctx, &records, selectBatchRowsSQL, id); err != nil {
+		return nil, err
+	}
+	batch.Rows = make([]*batchRow, 0, len(records))
+	for _, r := range records {
+		row := &batchRow{
+			Line: r.Line, AffiliateID: r.AffiliateID, Currency: r.Currency, Amount: r.Amount,
+			TransactionID: r.TransactionID, Note: r.Note, Status: r.Status, TransferID: r.TransferID,
+		}
+		if r.Errors != "" {
+			row.Errors = strings.Split(r.Errors, "\n")
+		}
+		batch.Rows = append(batch.Rows, row)
+	}
+
+	if err := db.SelectContext(ctx, &batch.Logs, selectBatchLogsSQL, id); err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
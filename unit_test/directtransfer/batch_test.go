@@ -0,0 +1,412 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeBatchLookup struct {
+	affiliates   map[int64]bool
+	transactions map[string]bool
+	limits       []transferLimit
+	usage        map[int64]transferUsage
+	err          error
+}
+
+func (f *fakeBatchLookup) getLimits(context.Context, int64) ([]transferLimit, error) {
+	return f.limits, f.err
+}
+
+func (f *fakeBatchLookup) getTransferUsage(_ context.Context, affiliateID int64, _ string, _ time.Time) (transferUsage, error) {
+	return f.usage[affiliateID], f.err
+}
+
+func (f *fakeBatchLookup) affiliateExists(_ context.Context, affiliateID int64) (bool, error) {
+	return f.affiliates[affiliateID], f.err
+}
+
+func (f *fakeBatchLookup) transactionExists(_ context.Context, transactionID string) (bool, error) {
+	return f.transactions[transactionID], f.err
+}
+
+const batchFixture = `affiliate_id,currency,amount,transaction_id,note
+1,usd,100.50,tx-1,october retainer
+2,USD,20,tx-2,
+99,USD,10,tx-3,unknown affiliate
+1,XYZ,10,tx-4,bad currency
+1,USD,abc,tx-5,bad amount
+1,USD,10,tx-1,duplicate in file
+2,USD,10,tx-old,already paid
+2,USD,6000,tx-6,over limit
+x,USD,10,tx-7,bad affiliate
+`
+
+func TestParseBatchCSV(t *testing.T) {
+	testCases := []struct {
+		name          string
+		csv           string
+		expectedError error
+		expectedRows  int
+	}{
+		{
+			name:          "parse batch csv success",
+			csv:           batchFixture,
+			expectedError: nil,
+			expectedRows:  9,
+		},
+		{
+			name:          "parse batch csv success - reordered columns",
+			csv:           "transaction_id,amount,currency,affiliate_id\ntx-1,1.5,EUR,3\n",
+			expectedError: nil,
+			expectedRows:  1,
+		},
+		{
+			name:          "parse batch csv error - missing column",
+			csv:           "affiliate_id,currency,amount\n1,USD,10\n",
+			expectedError: ErrMissingBatchColumn,
+		},
+		{
+			name:          "parse batch csv error - no rows",
+			csv:           "affiliate_id,currency,amount,transaction_id\n",
+			expectedError: ErrEmptyBatch,
+		},
+		{
+			name:          "parse batch csv error - empty file",
+			csv:           "",
+			expectedError: ErrEmptyBatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := parseBatchCSV(strings.NewReader(tc.csv))
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if len(rows) != tc.expectedRows {
+				t.Errorf("expected %v rows, got %v", tc.expectedRows, len(rows))
+			}
+		})
+	}
+}
+
+func TestParseMinorUnits(t *testing.T) {
+	testCases := []struct {
+		input          string
+		expectedResult int64
+		expectedError  error
+	}{
+		{input: "100", expectedResult: 10000},
+		{input: "100.5", expectedResult: 10050},
+		{input: "0.01", expectedResult: 1},
+		{input: "0", expectedError: ErrInvalidAmount},
+		{input: "-5", expectedError: ErrInvalidAmount},
+		{input: "1.234", expectedError: ErrInvalidAmount},
+		{input: "1.-2", expectedError: ErrInvalidAmount},
+		{input: "abc", expectedError: ErrInvalidAmount},
+		{input: "", expectedError: ErrInvalidAmount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := parseMinorUnits(tc.input)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if result != tc.expectedResult {
+				t.Errorf("expected %v, got %v", tc.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestPreviewBatch(t *testing.T) {
+	rows, err := parseBatchCSV(strings.NewReader(batchFixture))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lookup := &fakeBatchLookup{
+		affiliates:   map[int64]bool{1: true, 2: true},
+		transactions: map[string]bool{"tx-old": true},
+	}
+	rules := batchRules{Currencies: []string{"USD", "EUR"}, MaxAmount: 500000}
+
+	preview, err := previewBatch(context.Background(), rows, lookup, rules, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"tx-1":   "",
+		"tx-2":   "",
+		"tx-3":   "affiliate 99 not found",
+		"tx-4":   `unsupported currency "XYZ"`,
+		"tx-5":   `invalid amount "abc"`,
+		"tx-old": `transaction_id "tx-old" already used`,
+		"tx-6":   "amount exceeds the limit of 500000",
+		"tx-7":   `invalid affiliate_id "x"`,
+	}
+
+	for _, row := range preview.Rows {
+		if row.Line == 7 {
+			if row.Status != batchRowInvalid || !reflect.DeepEqual(row.Errors, []string{"duplicate transaction_id, first seen on line 2"}) {
+				t.Errorf("line 7: expected duplicate error, got %v %v", row.Status, row.Errors)
+			}
+			continue
+		}
+
+		want := expected[row.TransactionID]
+		if want == "" {
+			if row.Status != batchRowValid {
+				t.Errorf("line %d: expected valid, got %v", row.Line, row.Errors)
+			}
+			continue
+		}
+		if row.Status != batchRowInvalid || len(row.Errors) != 1 || row.Errors[0] != want {
+			t.Errorf("line %d: expected %q, got %v", row.Line, want, row.Errors)
+		}
+	}
+
+	if preview.Valid != 2 || preview.Invalid != 7 {
+		t.Errorf("expected 2 valid and 7 invalid, got %v and %v", preview.Valid, preview.Invalid)
+	}
+	if preview.Totals["USD"] != 12050 {
+		t.Errorf("expected %v, got %v", 12050, preview.Totals["USD"])
+	}
+}
+
+func TestPreviewBatchLookupError(t *testing.T) {
+	rows := []*batchRow{{Line: 2, AffiliateID: 1, Currency: "USD", Amount: 100, TransactionID: "tx-1", Status: batchRowValid}}
+	lookup := &fakeBatchLookup{err: fmt.Errorf("error")}
+
+	_, err := previewBatch(context.Background(), rows, lookup, batchRules{Currencies: []string{"USD"}}, time.Now())
+	if err == nil {
+		t.Errorf("expected %v, got %v", lookup.err, err)
+	}
+}
+
+func TestPreviewBatchLimits(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	row := func(line int, affiliateID, amount int64) *batchRow {
+		return &batchRow{Line: line, AffiliateID: affiliateID, Currency: "USD", Amount: amount, TransactionID: fmt.Sprintf("tx-%d", line), Status: batchRowValid}
+	}
+	rows := []*batchRow{row(2, 1, 8000), row(3, 1, 6000), row(4, 2, 6000), row(5, 1, 4000), row(6, 2, 2000)}
+
+	lookup := &fakeBatchLookup{
+		affiliates: map[int64]bool{1: true, 2: true},
+		limits: []transferLimit{
+			{AffiliateID: 1, DailyTotal: 15000},
+			{AffiliateID: 2, Currency: "USD", HourlyCount: 2},
+		},
+		usage: map[int64]transferUsage{1: {DailyTotal: 3000}, 2: {HourlyCount: 1}},
+	}
+
+	preview, err := previewBatch(context.Background(), rows, lookup, batchRules{Currencies: []string{"USD"}}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Affiliate 1 had 3000 today: 8000 fits, 6000 more does not, 4000 does.
+	// Affiliate 2 had one transfer this hour, so only one more fits.
+	expected := map[int]string{
+		2: "",
+		3: "transfer limit exceeded: affiliate 1 USD daily_total limit 15000, would be 17000",
+		4: "",
+		5: "",
+		6: "transfer limit exceeded: affiliate 2 USD hourly_count limit 2, would be 3",
+	}
+	for _, row := range preview.Rows {
+		want := expected[row.Line]
+		switch {
+		case want == "" && row.Status != batchRowValid:
+			t.Errorf("line %d: expected valid, got %v", row.Line, row.Errors)
+		case want != "" && (row.Status != batchRowInvalid || !reflect.DeepEqual(row.Errors, []string{want})):
+			t.Errorf("line %d: expected %q, got %v", row.Line, want, row.Errors)
+		}
+	}
+	if preview.Totals["USD"] != 18000 {
+		t.Errorf("expected %v, got %v", 18000, preview.Totals["USD"])
+	}
+}
+
+// fakeBatchDB keeps the batch tables in memory.
+type fakeBatchDB struct {
+	status batchStatus
+	rows   map[int]batchRowRecord
+	logs   []batchLog
+	err    error
+}
+
+func (db *fakeBatchDB) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	if db.err != nil {
+		return nil, db.err
+	}
+
+	switch query {
+	case insertBatchSQL:
+		db.status, db.rows = args[0].(batchStatus), map[int]batchRowRecord{}
+		return fakeResult{lastInsertID: 9, rowsAffected: 1}, nil
+	case finishBatchSQL:
+		db.status = args[0].(batchStatus)
+	case insertBatchRowSQL:
+		db.rows[args[1].(int)] = batchRowRecord{
+			Line: args[1].(int), AffiliateID: args[2].(int64), Currency: args[3].(string), Amount: args[4].(int64),
+			TransactionID: args[5].(string), Note: args[6].(string), Status: args[7].(batchRowStatus), Errors: args[8].(string),
+		}
+	case updateBatchRowSQL:
+		r := db.rows[args[4].(int)]
+		r.Status, r.Errors, r.TransferID = args[0].(batchRowStatus), args[1].(string), args[2].(int64)
+		db.rows[r.Line] = r
+	case insertBatchLogSQL:
+		db.logs = append(db.logs, batchLog{Action: args[1].(string), Note: args[2].(string), CreatedAt: args[3].(time.Time)})
+	default:
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+
+	return fakeResult{rowsAffected: 1}, nil
+}
+
+func (db *fakeBatchDB) GetContext(_ context.Context, dest any, _ string, args ...any) error {
+	if db.status == "" || args[0].(int64) != 9 {
+		return sql.ErrNoRows
+	}
+	*dest.(*batchStatus) = db.status
+	return nil
+}
+
+func (db *fakeBatchDB) SelectContext(_ context.Context, dest any, query string, _ ...any) error {
+	switch query {
+	case selectBatchRowsSQL:
+		lines := slices.Sorted(maps.Keys(db.rows))
+		for _, line := range lines {
+			*dest.(*[]batchRowRecord) = append(*dest.(*[]batchRowRecord), db.rows[line])
+		}
+	case selectBatchLogsSQL:
+		*dest.(*[]batchLog) = append(*dest.(*[]batchLog), db.logs...)
+	default:
+		return fmt.Errorf("unexpected query %q", query)
+	}
+	return nil
+}
+
+func TestExecuteBatch(t *testing.T) {
+	now := func() time.Time { return time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC) }
+
+	testCases := []struct {
+		name           string
+		failOn         map[string]bool
+		invalid        bool
+		expectedStatus batchStatus
+		expectedNote   string
+	}{
+		{
+			name:           "execute batch success",
+			failOn:         map[string]bool{},
+			expectedStatus: batchCompleted,
+			expectedNote:   "3 completed, 0 failed, 1 skipped",
+		},
+		{
+			name:           "execute batch partially failed",
+			failOn:         map[string]bool{"tx-2": true},
+			expectedStatus: batchPartiallyFailed,
+			expectedNote:   "2 completed, 1 failed, 1 skipped",
+		},
+		{
+			name:           "execute batch failed",
+			failOn:         map[string]bool{"tx-1": true, "tx-2": true, "tx-3": true},
+			expectedStatus: batchFailed,
+			expectedNote:   "0 completed, 3 failed, 1 skipped",
+		},
+		{
+			name:           "execute batch failed - every row invalid",
+			invalid:        true,
+			expectedStatus: batchFailed,
+			expectedNote:   "0 completed, 0 failed, 4 skipped",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rows := []*batchRow{
+				{Line: 2, TransactionID: "tx-1", Status: batchRowValid},
+				{Line: 3, TransactionID: "tx-2", Status: batchRowValid},
+				{Line: 4, TransactionID: "tx-bad", Status: batchRowInvalid},
+				{Line: 5, TransactionID: "tx-3", Status: batchRowValid},
+			}
+			preview := &batchPreview{Rows: rows, Valid: 3, Invalid: 1}
+			if tc.invalid {
+				for _, row := range rows {
+					row.Status = batchRowInvalid
+				}
+				preview.Valid, preview.Invalid = 0, 4
+			}
+
+			var created []string
+			create := func(_ context.Context, row *batchRow) (int64, error) {
+				if tc.failOn[row.TransactionID] {
+					return 0, fmt.Errorf("error")
+				}
+				created = append(created, row.TransactionID)
+				return int64(row.Line), nil
+			}
+
+			db := &fakeBatchDB{}
+			batch, err := executeBatch(context.Background(), db, preview, 2, create, now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if batch.ID != 9 || batch.Status != tc.expectedStatus {
+				t.Errorf("expected batch 9 %v, got %v %v", tc.expectedStatus, batch.ID, batch.Status)
+			}
+
+			saved, err := getBatch(context.Background(), db, batch.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(saved, batch) {
+				t.Errorf("expected the saved batch %+v, got %+v", batch, saved)
+			}
+			if last := batch.Logs[len(batch.Logs)-1]; last.Note != tc.expectedNote {
+				t.Errorf("expected %v, got %v", tc.expectedNote, last.Note)
+			}
+			for _, id := range created {
+				if id == "tx-bad" {
+					t.Errorf("invalid row was executed")
+				}
+			}
+			for _, row := range rows {
+				switch {
+				case row.TransactionID == "tx-bad" && row.Status != batchRowInvalid,
+					tc.failOn[row.TransactionID] && row.Status != batchRowFailed,
+					row.Status == batchRowCompleted && row.TransferID != int64(row.Line):
+					t.Errorf("line %d: unexpected row %+v", row.Line, row)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteBatchError(t *testing.T) {
+	rows := []*batchRow{{Line: 2, TransactionID: "tx-1", Status: batchRowValid}}
+	create := func(context.Context, *batchRow) (int64, error) { return 1, nil }
+
+	_, err := executeBatch(context.Background(), &fakeBatchDB{err: fmt.Errorf("error")}, &batchPreview{Rows: rows, Valid: 1}, 2, create, time.Now)
+	if err == nil {
+		t.Fatalf("expected error %q, but got none", "error")
+	}
+
+	_, err = getBatch(context.Background(), &fakeBatchDB{}, 9)
+	if !errors.Is(err, ErrBatchNotFound) {
+		t.Fatalf("expected error %q, but got %v", ErrBatchNotFound, err)
+	}
+}
@@ -0,0 +1,96 @@
+package directtransferimpl
+
+import (
+	"errors"
+	"fmt"
+	"main/pkg/affiliate/ledger/ledgerimpl"
+	"time"
+)
+
+var ErrInvalidStatusTransition = errors.New("invalid transfer status transition")
+
+type journalStatus string
+
+const (
+	journalStatusPending   journalStatus = "pending"
+	journalStatusCompleted journalStatus = "completed"
+	journalStatusRejected  journalStatus = "rejected"
+)
+
+// journalTransfer holds the transfer fields the ledger needs. Amount is in
+// minor units.
+type journalTransfer struct {
+	ID          int64
+	AffiliateID int64
+	Currency    string
+	Amount      int64
+}
+
+func (t journalTransfer) account(accountType ledgerimpl.AccountType) ledgerimpl.AccountKey {
+	key := ledgerimpl.AccountKey{Currency: t.Currency, Type: accountType}
+	if accountType != ledgerimpl.AccountOperatorClearing {
+		key.AffiliateID = t.AffiliateID
+	}
+
+	return key
+}
+
+// createJournalEntry books a new pending transfer: the operator clearing
+// account owes the affiliate's pending account.
+func createJournalEntry(t journalTransfer, now time.Time) (*ledgerimpl.JournalEntry, error) {
+	return newTransferEntry(t, string(journalStatusPending), now,
+		t.account(ledgerimpl.AccountOperatorClearing),
+		t.account(ledgerimpl.AccountAffiliatePending),
+	)
+}
+
+// statusJournalEntry books a status change. Completing a transfer moves the
+// amount from pending to payable; rejecting it returns the amount to the
+// operator clearing account.
+func statusJournalEntry(t journalTransfer, from, to journalStatus, now time.Time) (*ledgerimpl.JournalEntry, error) {
+	if from != journalStatusPending {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, from, to)
+	}
+
+	switch to {
+	case journalStatusCompleted:
+		return newTransferEntry(t, string(to), now,
+			t.account(ledgerimpl.AccountAffiliatePending),
+			t.account(ledgerimpl.AccountAffiliatePayable),
+		)
+	case journalStatusRejected:
+		return newTransferEntry(t, string(to), now,
+			t.account(ledgerimpl.AccountAffiliatePending),
+			t.account(ledgerimpl.AccountOperatorClearing),
+		)
+	default:
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, from, to)
+	}
+}
+
+// refundJournalEntry books a refund or reversal, t being the compensating
+// transfer: the amount goes back from the affiliate's payable account to
+// the operator clearing account.
+func refundJournalEntry(t journalTransfer, kind transferKind, now time.Time) (*ledgerimpl.JournalEntry, error) {
+	return newTransferEntry(t, string(kind), now,
+		t.account(ledgerimpl.AccountAffiliatePayable),
+		t.account(ledgerimpl.AccountOperatorClearing),
+	)
+}
+
+func newTransferEntry(t journalTransfer, event string, now time.Time, debit, credit ledgerimpl.AccountKey) (*ledgerimpl.JournalEntry, error) {
+	entry := &ledgerimpl.JournalEntry{
+		Reference: fmt.Sprintf("transfer:%d:%s", t.ID, event),
+		Postings: []ledgerimpl.Posting{
+			{Account: debit, Debit: t.Amount},
+			{Account: credit, Credit: t.Amount},
+		},
+		CreatedAt: now,
+	}
+
+	if err := entry.Validate(); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,122 @@
+package directtransferimpl
+
+import (
+	"errors"
+	"main/pkg/affiliate/ledger/ledgerimpl"
+	"testing"
+	"time"
+)
+
+func TestCreateJournalEntry(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		transfer      journalTransfer
+		expectedError error
+	}{
+		{
+			name:          "create journal entry success",
+			transfer:      journalTransfer{ID: 1, AffiliateID: 7, Currency: "USD", Amount: 10000},
+			expectedError: nil,
+		},
+		{
+			name:          "create journal entry error - zero amount",
+			transfer:      journalTransfer{ID: 1, AffiliateID: 7, Currency: "USD"},
+			expectedError: ledgerimpl.ErrInvalidPosting,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, err := createJournalEntry(tc.transfer, now)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if entry.Reference != "transfer:1:pending" {
+				t.Errorf("expected %v, got %v", "transfer:1:pending", entry.Reference)
+			}
+			pending := ledgerimpl.AccountKey{AffiliateID: 7, Currency: "USD", Type: ledgerimpl.AccountAffiliatePending}
+			if got := ledgerimpl.Balance([]*ledgerimpl.JournalEntry{entry}, pending); got != -10000 {
+				t.Errorf("expected %v, got %v", -10000, got)
+			}
+		})
+	}
+}
+
+func TestStatusJournalEntry(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	transfer := journalTransfer{ID: 1, AffiliateID: 7, Currency: "USD", Amount: 10000}
+
+	pending := ledgerimpl.AccountKey{AffiliateID: 7, Currency: "USD", Type: ledgerimpl.AccountAffiliatePending}
+	payable := ledgerimpl.AccountKey{AffiliateID: 7, Currency: "USD", Type: ledgerimpl.AccountAffiliatePayable}
+	clearing := ledgerimpl.AccountKey{Currency: "USD", Type: ledgerimpl.AccountOperatorClearing}
+
+	testCases := []struct {
+		name             string
+		from             journalStatus
+		to               journalStatus
+		expectedError    error
+		expectedBalances map[ledgerimpl.AccountKey]int64
+	}{
+		{
+			name:          "status journal entry success - completed",
+			from:          journalStatusPending,
+			to:            journalStatusCompleted,
+			expectedError: nil,
+			expectedBalances: map[ledgerimpl.AccountKey]int64{
+				pending:  0,
+				payable:  -10000,
+				clearing: 10000,
+			},
+		},
+		{
+			name:          "status journal entry success - rejected",
+			from:          journalStatusPending,
+			to:            journalStatusRejected,
+			expectedError: nil,
+			expectedBalances: map[ledgerimpl.AccountKey]int64{
+				pending:  0,
+				payable:  0,
+				clearing: 0,
+			},
+		},
+		{
+			name:          "status journal entry error - already completed",
+			from:          journalStatusCompleted,
+			to:            journalStatusRejected,
+			expectedError: ErrInvalidStatusTransition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			created, err := createJournalEntry(transfer, now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			entry, err := statusJournalEntry(transfer, tc.from, tc.to, now)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			entries := []*ledgerimpl.JournalEntry{created, entry}
+			for account, expected := range tc.expectedBalances {
+				if got := ledgerimpl.Balance(entries, account); got != expected {
+					t.Errorf("%s: expected %v, got %v", account.Type, expected, got)
+				}
+			}
+			if imbalances := ledgerimpl.CheckInvariants(entries); len(imbalances) != 0 {
+				t.Errorf("expected balanced ledger, got %v", imbalances)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package directtransferimpl
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type LimitRule string
+
+const (
+	LimitRuleSingleTransferMax LimitRule = "single_transfer_max"
+	LimitRuleHourlyCount       LimitRule = "hourly_count"
+	LimitRuleDailyTotal        LimitRule = "daily_total"
+	LimitRuleMonthlyTotal      LimitRule = "monthly_total"
+)
+
+// LimitExceededError names the limit a new transfer would breach. A zero
+// AffiliateID means the global limit was breached.
+type LimitExceededError struct {
+	Rule        LimitRule
+	AffiliateID int64
+	Currency    string
+	Limit       int64
+	Actual      int64
+}
+
+func (e *LimitExceededError) Error() string {
+	scope := "global"
+	if e.AffiliateID != 0 {
+		scope = fmt.Sprintf("affiliate %d", e.AffiliateID)
+	}
+
+	return fmt.Sprintf("transfer limit exceeded: %s %s %s limit %d, would be %d", scope, e.Currency, e.Rule, e.Limit, e.Actual)
+}
+
+// transferLimit is a limit row. AffiliateID 0 is the global limit across
+// all affiliates and an empty Currency applies to every currency. Zero
+// values mean no limit; amounts are in minor units.
+type transferLimit struct {
+	AffiliateID       int64  `db:"affiliate_id"`
+	Currency          string `db:"currency"`
+	SingleTransferMax int64  `db:"single_transfer_max"`
+	HourlyCount       int64  `db:"hourly_count"`
+	DailyTotal        int64  `db:"daily_total"`
+	MonthlyTotal      int64  `db:"monthly_total"`
+}
+
+// transferUsage is what already counts against the limits: transfers in
+// the last hour, since the start of the day and since the start of the
+// month (UTC).
+type transferUsage struct {
+	HourlyCount  int64 `db:"hourly_count"`
+	DailyTotal   int64 `db:"daily_total"`
+	MonthlyTotal int64 `db:"monthly_total"`
+}
+
+type limitSource interface {
+	getLimits(ctx context.Context, affiliateID int64) ([]transferLimit, error)
+	getTransferUsage(ctx context.Context, affiliateID int64, currency string, now time.Time) (transferUsage, error)
+}
+
+// checkTransferLimits checks a new transfer against the affiliate's and the
+// global limits.
+func checkTransferLimits(ctx context.Context, src limitSource, affiliateID int64, currency string, amount int64, now time.Time) error {
+	limits, err := src.getLimits(ctx, affiliateID)
+	if err != nil {
+		return err
+	}
+
+	usage := make(map[int64]transferUsage, 2)
+	for _, limit := range limits {
+		if limit.AffiliateID != 0 && limit.AffiliateID != affiliateID {
+			continue
+		}
+		if limit.Currency != "" && limit.Currency != currency {
+			continue
+		}
+
+		if limit.SingleTransferMax > 0 && amount > limit.SingleTransferMax {
+			return limitExceeded(LimitRuleSingleTransferMax, limit, currency, limit.SingleTransferMax, amount)
+		}
+
+		if limit.HourlyCount == 0 && limit.DailyTotal == 0 && limit.MonthlyTotal == 0 {
+			continue
+		}
+
+		u, ok := usage[limit.AffiliateID]
+		if !ok {
+			if u, err = src.getTransferUsage(ctx, limit.AffiliateID, currency, now); err != nil {
+				return err
+			}
+			usage[limit.AffiliateID] = u
+		}
+
+		if limit.HourlyCount > 0 && u.HourlyCount+1 > limit.HourlyCount {
+			return limitExceeded(LimitRuleHourlyCount, limit, currency, limit.HourlyCount, u.HourlyCount+1)
+		}
+		if limit.DailyTotal > 0 && u.DailyTotal+amount > limit.DailyTotal {
+			return limitExceeded(LimitRuleDailyTotal, limit, currency, limit.DailyTotal, u.DailyTotal+amount)
+		}
+		if limit.MonthlyTotal > 0 && u.MonthlyTotal+amount > limit.MonthlyTotal {
+			return limitExceeded(LimitRuleMonthlyTotal, limit, currency, limit.MonthlyTotal, u.MonthlyTotal+amount)
+		}
+	}
+
+	return nil
+}
+
+const (
+	// FOR UPDATE locks the limit rows that apply, so creates under the same
+	// limits run one at a time until each commits.
+	selectTransferLimitsSQL = `SELECT affiliate_id, currency, single_transfer_max, hourly_count, daily_total, monthly_total
+FROM direct_transfer_limit
+WHERE affiliate_id IN (0, ?)
+ORDER BY affiliate_id, currency
+FOR UPDATE`
+
+	selectTransferUsageSQL = `SELECT
+COALESCE(SUM(created_at > ?), 0) AS hourly_count,
+COALESCE(SUM(CASE WHEN created_at >= ? THEN amount ELSE 0 END), 0) AS daily_total,
+COALESCE(SUM(CASE WHEN created_at >= ? THEN amount ELSE 0 END), 0) AS monthly_total
+FROM direct_transfer
+WHERE (? = 0 OR affiliate_id = ?) AND currency = ? AND kind = 'transfer' AND status <> 'rejected'
+AND created_at >= ? AND created_at <= ?`
+)
+
+// sqlLimitSource reads the limits and usage inside a transaction.
+type sqlLimitSource struct {
+	tx dbConn
+}
+
+func (s sqlLimitSource) getLimits(ctx context.Context, affiliateID int64) ([]transferLimit, error) {
+	limits := make([]transferLimit, 0)
+	if err := s.tx.SelectContext(ctx, &limits, selectTransferLimitsSQL, affiliateID); err != nil {
+		return nil, err
+	}
+
+	return limits, nil
+}
+
+// getTransferUsage uses the windows of usageFromTransfers. Rejected
+// transfers and refunds do not count.
+func (s sqlLimitSource) getTransferUsage(ctx context.Context, affiliateID int64, currency string, now time.Time) (transferUsage, error) {
+	now = now.UTC()
+	hourAgo := now.Add(-time.Hour)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := monthStart
+	if hourAgo.Before(from) {
+		from = hourAgo
+	}
+
+	var usage transferUsage
+	err := s.tx.GetContext(ctx, &usage, selectTransferUsageSQL,
+		hourAgo, dayStart, monthStart, affiliateID, affiliateID, currency, from, now)
+
+	return usage, err
+}
+
+func limitExceeded(rule LimitRule, limit transferLimit, currency string, max, actual int64) error {
+	return &LimitExceededError{
+		Rule:        rule,
+		AffiliateID: limit.AffiliateID,
+		Currency:    currency,
+		Limit:       max,
+		Actual:      actual,
+	}
+}
+
+type usageRow struct {
+	AffiliateID int64
+	Currency    string
+	Amount      int64
+	CreatedAt   time.Time
+}
+
+// usageFromTransfers computes transferUsage from transfer rows using the
+// same windows as the usage query. AffiliateID 0 counts every affiliate.
+func usageFromTransfers(rows []usageRow, affiliateID int64, currency string, now time.Time) transferUsage {
+	now = now.UTC()
+	hourAgo := now.Add(-time.Hour)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var usage transferUsage
+	for _, row := range rows {
+		if (affiliateID != 0 && row.AffiliateID != affiliateID) || row.Currency != currency || row.CreatedAt.After(now) {
+			continue
+		}
+
+		if row.CreatedAt.After(hourAgo) {
+			usage.HourlyCount++
+		}
+		if !row.CreatedAt.Before(dayStart) {
+			usage.DailyTotal += row.Amount
+		}
+		if !row.CreatedAt.Before(monthStart) {
+			usage.MonthlyTotal += row.Amount
+		}
+	}
+
+	return usage
+}
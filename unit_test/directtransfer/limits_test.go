@@ -0,0 +1,194 @@
+package directtransferimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fakeLimitSource struct {
+	limits    []transferLimit
+	transfers []usageRow
+	err       error
+}
+
+func (f *fakeLimitSource) getLimits(_ context.Context, affiliateID int64) ([]transferLimit, error) {
+	result := make([]transferLimit, 0)
+	for _, limit := range f.limits {
+		if limit.AffiliateID == 0 || limit.AffiliateID == affiliateID {
+			result = append(result, limit)
+		}
+	}
+	return result, f.err
+}
+
+func (f *fakeLimitSource) getTransferUsage(_ context.Context, affiliateID int64, currency string, now time.Time) (transferUsage, error) {
+	return usageFromTransfers(f.transfers, affiliateID, currency, now), nil
+}
+
+func TestCheckTransferLimits(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	transfers := []usageRow{
+		{AffiliateID: 1, Currency: "USD", Amount: 30000, CreatedAt: time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC)},
+		{AffiliateID: 1, Currency: "USD", Amount: 20000, CreatedAt: time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)},
+		{AffiliateID: 1, Currency: "USD", Amount: 5000, CreatedAt: time.Date(2026, 10, 19, 11, 30, 0, 0, time.UTC)},
+		{AffiliateID: 1, Currency: "USD", Amount: 5000, CreatedAt: time.Date(2026, 10, 19, 11, 45, 0, 0, time.UTC)},
+		{AffiliateID: 1, Currency: "EUR", Amount: 90000, CreatedAt: time.Date(2026, 10, 19, 11, 50, 0, 0, time.UTC)},
+		{AffiliateID: 2, Currency: "USD", Amount: 400000, CreatedAt: time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)},
+	}
+
+	testCases := []struct {
+		name           string
+		limits         []transferLimit
+		affiliateID    int64
+		currency       string
+		amount         int64
+		expectedRule   LimitRule
+		expectedScope  int64
+		expectedActual int64
+	}{
+		{
+			name:        "check limits success",
+			limits:      []transferLimit{{AffiliateID: 1, SingleTransferMax: 50000, HourlyCount: 5, DailyTotal: 100000, MonthlyTotal: 500000}},
+			affiliateID: 1,
+			currency:    "USD",
+			amount:      10000,
+		},
+		{
+			name:           "check limits error - single transfer max",
+			limits:         []transferLimit{{AffiliateID: 1, SingleTransferMax: 50000}},
+			affiliateID:    1,
+			currency:       "USD",
+			amount:         50001,
+			expectedRule:   LimitRuleSingleTransferMax,
+			expectedScope:  1,
+			expectedActual: 50001,
+		},
+		{
+			name:           "check limits error - hourly count",
+			limits:         []transferLimit{{AffiliateID: 1, HourlyCount: 2}},
+			affiliateID:    1,
+			currency:       "USD",
+			amount:         100,
+			expectedRule:   LimitRuleHourlyCount,
+			expectedScope:  1,
+			expectedActual: 3,
+		},
+		{
+			name:           "check limits error - daily total",
+			limits:         []transferLimit{{AffiliateID: 1, DailyTotal: 40000}},
+			affiliateID:    1,
+			currency:       "USD",
+			amount:         10001,
+			expectedRule:   LimitRuleDailyTotal,
+			expectedScope:  1,
+			expectedActual: 40001,
+		},
+		{
+			name:           "check limits error - monthly total",
+			limits:         []transferLimit{{AffiliateID: 1, MonthlyTotal: 70000}},
+			affiliateID:    1,
+			currency:       "USD",
+			amount:         10001,
+			expectedRule:   LimitRuleMonthlyTotal,
+			expectedScope:  1,
+			expectedActual: 70001,
+		},
+		{
+			name:           "check limits error - global daily total",
+			limits:         []transferLimit{{AffiliateID: 1, DailyTotal: 100000}, {DailyTotal: 450000}},
+			affiliateID:    1,
+			currency:       "USD",
+			amount:         30000,
+			expectedRule:   LimitRuleDailyTotal,
+			expectedScope:  0,
+			expectedActual: 460000,
+		},
+		{
+			name:        "check limits success - other currency limit",
+			limits:      []transferLimit{{AffiliateID: 1, Currency: "EUR", DailyTotal: 1}},
+			affiliateID: 1,
+			currency:    "USD",
+			amount:      10000,
+		},
+		{
+			name:        "check limits success - other affiliate limit",
+			limits:      []transferLimit{{AffiliateID: 2, SingleTransferMax: 1}},
+			affiliateID: 1,
+			currency:    "USD",
+			amount:      10000,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := &fakeLimitSource{limits: tc.limits, transfers: transfers}
+
+			err := checkTransferLimits(context.Background(), src, tc.affiliateID, tc.currency, tc.amount, now)
+			if tc.expectedRule == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var limitErr *LimitExceededError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("expected LimitExceededError, got %v", err)
+			}
+			if limitErr.Rule != tc.expectedRule || limitErr.AffiliateID != tc.expectedScope || limitErr.Actual != tc.expectedActual {
+				t.Errorf("expected %v for %v with %v, got %+v", tc.expectedRule, tc.expectedScope, tc.expectedActual, limitErr)
+			}
+		})
+	}
+}
+
+func TestCheckTransferLimitsSourceError(t *testing.T) {
+	src := &fakeLimitSource{err: fmt.Errorf("error")}
+
+	err := checkTransferLimits(context.Background(), src, 1, "USD", 100, time.Now())
+	if err == nil || errors.As(err, new(*LimitExceededError)) {
+		t.Errorf("expected %v, got %v", src.err, err)
+	}
+}
+
+func TestUsageFromTransfers(t *testing.T) {
+	now := time.Date(2026, 10, 19, 0, 30, 0, 0, time.UTC)
+
+	rows := []usageRow{
+		{AffiliateID: 1, Currency: "USD", Amount: 100, CreatedAt: time.Date(2026, 9, 30, 23, 59, 0, 0, time.UTC)},
+		{AffiliateID: 1, Currency: "USD", Amount: 200, CreatedAt: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{AffiliateID: 1, Currency: "USD", Amount: 300, CreatedAt: time.Date(2026, 10, 18, 23, 45, 0, 0, time.UTC)},
+		{AffiliateID: 1, Currency: "USD", Amount: 400, CreatedAt: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{AffiliateID: 2, Currency: "USD", Amount: 500, CreatedAt: time.Date(2026, 10, 19, 0, 10, 0, 0, time.UTC)},
+	}
+
+	testCases := []struct {
+		name           string
+		affiliateID    int64
+		expectedResult transferUsage
+	}{
+		{
+			name:           "usage from transfers - affiliate",
+			affiliateID:    1,
+			expectedResult: transferUsage{HourlyCount: 2, DailyTotal: 400, MonthlyTotal: 900},
+		},
+		{
+			name:           "usage from transfers - all affiliates",
+			affiliateID:    0,
+			expectedResult: transferUsage{HourlyCount: 3, DailyTotal: 900, MonthlyTotal: 1400},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := usageFromTransfers(rows, tc.affiliateID, "USD", now)
+			if result != tc.expectedResult {
+				t.Errorf("expected %+v, got %+v", tc.expectedResult, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package directtransferimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	dt "main/pkg/affiliate/directtransfer"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidGroupBy = errors.New("invalid group by")
+
+type GroupByDimension string
+
+const (
+	GroupByCurrency  GroupByDimension = "currency"
+	GroupByStatus    GroupByDimension = "status"
+	GroupByAffiliate GroupByDimension = "affiliate"
+	GroupByOperator  GroupByDimension = "operator"
+	GroupByDay       GroupByDimension = "day"
+	GroupByMonth     GroupByDimension = "month"
+)
+
+// aggregateSource is a transfer as seen by the aggregation. Amount is in
+// minor units and positive for every kind; refunds and reversals are
+// subtracted from the totals.
+type aggregateSource struct {
+	Currency    string       `db:"currency"`
+	Status      string       `db:"status"`
+	Kind        transferKind `db:"kind"`
+	AffiliateID int64        `db:"affiliate_id"`
+	OperatorID  int64        `db:"operator_id"`
+	CreatedAt   time.Time    `db:"created_at"`
+	Amount      int64        `db:"amount"`
+}
+
+func (s aggregateSource) signedAmount() int64 {
+	if s.Kind == transferKindRefund || s.Kind == transferKindReversal {
+		return -s.Amount
+	}
+	return s.Amount
+}
+
+// pivotRow is a group, a subtotal or the grand total. Keys holds one value
+// per group by dimension; a subtotal fills only the first Level keys and
+// the grand total has Level 0.
+type pivotRow struct {
+	Keys       []string
+	Level      int
+	Subtotal   bool
+	GrandTotal bool
+	Count      int64
+	Total      int64
+}
+
+func (d GroupByDimension) key(s aggregateSource) string {
+	switch d {
+	case GroupByCurrency:
+		return s.Currency
+	case GroupByStatus:
+		return s.Status
+	case GroupByAffiliate:
+		return strconv.FormatInt(s.AffiliateID, 10)
+	case GroupByOperator:
+		return strconv.FormatInt(s.OperatorID, 10)
+	case GroupByDay:
+		return s.CreatedAt.UTC().Format("2006-01-02")
+	case GroupByMonth:
+		return s.CreatedAt.UTC().Format("2006-01")
+	}
+
+	return ""
+}
+
+// less orders the keys of d; affiliate and operator IDs sort as numbers.
+func (d GroupByDimension) less(a, b string) bool {
+	if d == GroupByAffiliate || d == GroupByOperator {
+		x, errX := strconv.ParseInt(a, 10, 64)
+		y, errY := strconv.ParseInt(b, 10, 64)
+		if errX == nil && errY == nil {
+			return x < y
+		}
+	}
+	return a < b
+}
+
+func validateGroupBy(groupBy []GroupByDimension) error {
+	seen := make(map[GroupByDimension]bool, len(groupBy))
+	for _, d := range groupBy {
+		switch d {
+		case GroupByCurrency, GroupByStatus, GroupByAffiliate, GroupByOperator, GroupByDay, GroupByMonth:
+		default:
+			return fmt.Errorf("%w: unknown dimension %q", ErrInvalidGroupBy, d)
+		}
+		if seen[d] {
+			return fmt.Errorf("%w: dimension %q repeated", ErrInvalidGroupBy, d)
+		}
+		seen[d] = true
+	}
+
+	return nil
+}
+
+// pivotTransfers groups transfers by the given dimensions in order. Each
+// group is followed by a subtotal row once its children are listed and the
+// grand total comes last. Currencies are not converted, so group by currency
+// first when totals should be meaningful.
+func pivotTransfers(transfers []aggregateSource, groupBy []GroupByDimension) ([]*pivotRow, error) {
+	if err := validateGroupBy(groupBy); err != nil {
+		return nil, err
+	}
+
+	rows := make([]*pivotRow, 0)
+	total := pivotLevel(transfers, groupBy, nil, &rows)
+	total.GrandTotal = true
+	rows = append(rows, total)
+
+	return rows, nil
+}
+
+func pivotLevel(transfers []aggregateSource, groupBy []GroupByDimension, keys []string, rows *[]*pivotRow) *pivotRow {
+	row := &pivotRow{Keys: keys, Level: len(keys)}
+	for _, t := range transfers {
+		row.Count++
+		row.Total += t.signedAmount()
+	}
+
+	if len(keys) == len(groupBy) {
+		return row
+	}
+
+	dim := groupBy[len(keys)]
+	groups := make(map[string][]aggregateSource)
+	for _, t := range transfers {
+		k := dim.key(t)
+		groups[k] = append(groups[k], t)
+	}
+
+	values := make([]string, 0, len(groups))
+	for k := range groups {
+		values = append(values, k)
+	}
+	sort.Slice(values, func(i, j int) bool { return dim.less(values[i], values[j]) })
+
+	for _, v := range values {
+		childKeys := append(append(make([]string, 0, len(keys)+1), keys...), v)
+		child := pivotLevel(groups[v], groupBy, childKeys, rows)
+		child.Subtotal = len(childKeys) < len(groupBy)
+		*rows = append(*rows, child)
+	}
+
+	return row
+}
+
+// transferSearcher is the store's paged search, which searchTransfersPivot
+// extends.
+type transferSearcher interface {
+	Search(ctx context.Context, query *dt.SearchTransfersQuery) (*dt.SearchTransfersResult, error)
+}
+
+// transferSearchQuery is a SearchTransfersQuery with the dimensions to
+// pivot the matching transfers by.
+type transferSearchQuery struct {
+	*dt.SearchTransfersQuery
+	GroupBy []GroupByDimension
+}
+
+// transferSearchPivot is a page of search results with the pivot of every
+// transfer the search matches, not only the page.
+type transferSearchPivot struct {
+	*dt.SearchTransfersResult
+	Pivot []*pivotRow
+}
+
+const selectAggregateSourcesSQL = `SELECT currency, status, kind, affiliate_id, created_by AS operator_id, created_at, amount
+FROM direct_transfer`
+
+// searchTransfersPivot runs the store's search for query and pivots the
+// transfers matching the same conditions, which the caller builds from
+// query the way the store does. Without GroupBy only the page is returned.
+func searchTransfersPivot(ctx context.Context, store transferSearcher, db dbConn, query transferSearchQuery,
+	whereConditions []string, whereParams []any) (*transferSearchPivot, error) {
+	if err := validateGroupBy(query.GroupBy); err != nil {
+		return nil, err
+	}
+
+	page, err := store.Search(ctx, query.SearchTransfersQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &transferSearchPivot{SearchTransfersResult: page}
+	if len(query.GroupBy) == 0 {
+		return result, nil
+	}
+
+	sqlQuery := selectAggregateSourcesSQL
+	if len(whereConditions) > 0 {
+		sqlQuery += "\nWHERE " + strings.Join(whereConditions, " AND ")
+	}
+
+	sources := make([]aggregateSource, 0)
+	if err := db.SelectContext(ctx, &sources, sqlQuery, whereParams...); err != nil {
+		return nil, err
+	}
+
+	if result.Pivot, err = pivotTransfers(sources, query.GroupBy); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
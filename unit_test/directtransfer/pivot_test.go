@@ -0,0 +1,219 @@
+package directtransferimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	dt "main/pkg/affiliate/directtransfer"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPivotTransfers(t *testing.T) {
+	oct := time.Date(2026, 10, 3, 10, 0, 0, 0, time.UTC)
+	nov := time.Date(2026, 11, 5, 10, 0, 0, 0, time.UTC)
+
+	transfers := []aggregateSource{
+		{Currency: "USD", Status: "completed", AffiliateID: 1, CreatedAt: oct, Amount: 1000},
+		{Currency: "USD", Status: "pending", AffiliateID: 2, CreatedAt: oct, Amount: 500},
+		{Currency: "USD", Status: "completed", AffiliateID: 1, CreatedAt: nov, Amount: 250},
+		{Currency: "EUR", Status: "completed", AffiliateID: 1, CreatedAt: nov, Amount: 700},
+		{Currency: "USD", Status: "completed", Kind: transferKindRefund, AffiliateID: 10, CreatedAt: nov, Amount: 100},
+	}
+
+	testCases := []struct {
+		name           string
+		groupBy        []GroupByDimension
+		expectedResult []pivotRow
+	}{
+		{
+			name:    "pivot by currency and month",
+			groupBy: []GroupByDimension{GroupByCurrency, GroupByMonth},
+			expectedResult: []pivotRow{
+				{Keys: []string{"EUR", "2026-11"}, Level: 2, Count: 1, Total: 700},
+				{Keys: []string{"EUR"}, Level: 1, Subtotal: true, Count: 1, Total: 700},
+				{Keys: []string{"USD", "2026-10"}, Level: 2, Count: 2, Total: 1500},
+				{Keys: []string{"USD", "2026-11"}, Level: 2, Count: 2, Total: 150},
+				{Keys: []string{"USD"}, Level: 1, Subtotal: true, Count: 4, Total: 1650},
+				{Level: 0, GrandTotal: true, Count: 5, Total: 2350},
+			},
+		},
+		{
+			name:    "pivot by status",
+			groupBy: []GroupByDimension{GroupByStatus},
+			expectedResult: []pivotRow{
+				{Keys: []string{"completed"}, Level: 1, Count: 4, Total: 1850},
+				{Keys: []string{"pending"}, Level: 1, Count: 1, Total: 500},
+				{Level: 0, GrandTotal: true, Count: 5, Total: 2350},
+			},
+		},
+		{
+			name:    "pivot by affiliate",
+			groupBy: []GroupByDimension{GroupByAffiliate},
+			expectedResult: []pivotRow{
+				{Keys: []string{"1"}, Level: 1, Count: 3, Total: 1950},
+				{Keys: []string{"2"}, Level: 1, Count: 1, Total: 500},
+				{Keys: []string{"10"}, Level: 1, Count: 1, Total: -100},
+				{Level: 0, GrandTotal: true, Count: 5, Total: 2350},
+			},
+		},
+		{
+			name:    "pivot without group by",
+			groupBy: nil,
+			expectedResult: []pivotRow{
+				{Level: 0, GrandTotal: true, Count: 5, Total: 2350},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := pivotTransfers(transfers, tc.groupBy)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != len(tc.expectedResult) {
+				t.Fatalf("expected %d rows, got %d", len(tc.expectedResult), len(result))
+			}
+			for i, row := range result {
+				if !reflect.DeepEqual(*row, tc.expectedResult[i]) {
+					t.Errorf("row %d: expected %+v, got %+v", i, tc.expectedResult[i], *row)
+				}
+			}
+		})
+	}
+}
+
+func TestPivotTransfersInvalidGroupBy(t *testing.T) {
+	testCases := []struct {
+		name    string
+		groupBy []GroupByDimension
+	}{
+		{name: "pivot error - unknown dimension", groupBy: []GroupByDimension{"week"}},
+		{name: "pivot error - repeated dimension", groupBy: []GroupByDimension{GroupByDay, GroupByDay}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := pivotTransfers(nil, tc.groupBy)
+			if !errors.Is(err, ErrInvalidGroupBy) {
+				t.Errorf("expected %v, got %v", ErrInvalidGroupBy, err)
+			}
+		})
+	}
+}
+
+type fakeTransferSearcher struct {
+	err error
+}
+
+func (s fakeTransferSearcher) Search(context.Context, *dt.SearchTransfersQuery) (*dt.SearchTransfersResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &dt.SearchTransfersResult{}, nil
+}
+
+// fakeSourcesDB returns sources for the pivot query, recording the query it
+// was given.
+type fakeSourcesDB struct {
+	dbConn
+	sources []aggregateSource
+	query   string
+	err     error
+}
+
+func (db *fakeSourcesDB) SelectContext(_ context.Context, dest any, query string, _ ...any) error {
+	db.query = query
+	if db.err != nil {
+		return db.err
+	}
+	*dest.(*[]aggregateSource) = append(*dest.(*[]aggregateSource), db.sources...)
+	return nil
+}
+
+func TestSearchTransfersPivot(t *testing.T) {
+	sources := []aggregateSource{
+		{Currency: "USD", Kind: transferKindTransfer, Amount: 1000},
+		{Currency: "USD", Kind: transferKindReversal, Amount: 1000},
+		{Currency: "EUR", Kind: transferKindTransfer, Amount: 300},
+	}
+
+	testCases := []struct {
+		name          string
+		store         fakeTransferSearcher
+		db            *fakeSourcesDB
+		groupBy       []GroupByDimension
+		expectedError error
+		expectedPivot []pivotRow
+	}{
+		{
+			name:    "search transfers pivot success",
+			db:      &fakeSourcesDB{sources: sources},
+			groupBy: []GroupByDimension{GroupByCurrency},
+			expectedPivot: []pivotRow{
+				{Keys: []string{"EUR"}, Level: 1, Count: 1, Total: 300},
+				{Keys: []string{"USD"}, Level: 1, Count: 2, Total: 0},
+				{Level: 0, GrandTotal: true, Count: 3, Total: 300},
+			},
+		},
+		{
+			name: "search transfers pivot success - page only",
+			db:   &fakeSourcesDB{sources: sources},
+		},
+		{
+			name:          "search transfers pivot error - group by",
+			db:            &fakeSourcesDB{},
+			groupBy:       []GroupByDimension{"week"},
+			expectedError: ErrInvalidGroupBy,
+		},
+		{
+			name:          "search transfers pivot error - search",
+			store:         fakeTransferSearcher{err: fmt.Errorf("error")},
+			db:            &fakeSourcesDB{},
+			groupBy:       []GroupByDimension{GroupByCurrency},
+			expectedError: fmt.Errorf("error"),
+		},
+		{
+			name:          "search transfers pivot error - sources",
+			db:            &fakeSourcesDB{err: fmt.Errorf("error")},
+			groupBy:       []GroupByDimension{GroupByCurrency},
+			expectedError: fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query := transferSearchQuery{SearchTransfersQuery: &dt.SearchTransfersQuery{}, GroupBy: tc.groupBy}
+
+			result, err := searchTransfersPivot(context.Background(), tc.store, tc.db, query, []string{"affiliate_id = ?"}, []any{int64(7)})
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+			if errors.Is(tc.expectedError, ErrInvalidGroupBy) && !errors.Is(err, ErrInvalidGroupBy) {
+				t.Fatalf("expected error %q, but got %q", ErrInvalidGroupBy, err)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+
+			if result.SearchTransfersResult == nil {
+				t.Fatalf("expected the page to be kept")
+			}
+			if len(result.Pivot) != len(tc.expectedPivot) {
+				t.Fatalf("expected %d pivot rows, got %d", len(tc.expectedPivot), len(result.Pivot))
+			}
+			for i, row := range result.Pivot {
+				if !reflect.DeepEqual(*row, tc.expectedPivot[i]) {
+					t.Errorf("row %d: expected %+v, got %+v", i, tc.expectedPivot[i], *row)
+				}
+			}
+			if tc.groupBy != nil && tc.db.query != selectAggregateSourcesSQL+"\nWHERE affiliate_id = ?" {
+				t.Errorf("expected the search conditions in the query, got %q", tc.db.query)
+			}
+		})
+	}
+}
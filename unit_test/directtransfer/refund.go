@@ -0,0 +1,175 @@
+package directtransferimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	dt "main/pkg/affiliate/directtransfer"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRefundExceedsOriginal = errors.New("refunds exceed the original transfer amount")
+	ErrTransferNotCompleted  = errors.New("only completed transfers can be refunded")
+	ErrInvalidRefundAmount   = errors.New("refund amount must be positive")
+	ErrNotRefundable         = errors.New("refunds and reversals cannot be refunded")
+)
+
+type transferKind string
+
+const (
+	transferKindTransfer transferKind = "transfer"
+	transferKindRefund   transferKind = "refund"
+	transferKindReversal transferKind = "reversal"
+)
+
+// refundOriginal is the transfer being refunded. Refunded is the sum of the
+// refunds already booked against it; amounts are in minor units.
+type refundOriginal struct {
+	ID          int64
+	AffiliateID int64
+	Currency    string
+	Amount      int64
+	Refunded    int64
+	Status      journalStatus
+}
+
+// compensatingTransfer is the transfer created to refund or reverse an
+// original one. Amount is positive and is subtracted in the grand totals.
+type compensatingTransfer struct {
+	OriginalID  int64
+	AffiliateID int64
+	Currency    string
+	Amount      int64
+	Kind        transferKind
+	Reason      string
+	CreatedBy   int64
+	CreatedAt   time.Time
+}
+
+type transferLogEntry struct {
+	TransferID int64
+	Action     string
+	Note       string
+	CreatedBy  int64
+	CreatedAt  time.Time
+}
+
+// refundPlan is everything a refund writes: the compensating transfer and
+// a log entry on each side.
+type refundPlan struct {
+	Transfer        compensatingTransfer
+	OriginalLog     transferLogEntry
+	CompensatingLog transferLogEntry
+}
+
+// linkCreated fills in the compensating transfer ID once it has been
+// inserted and points both log entries at each other.
+func (p *refundPlan) linkCreated(compensatingID int64) {
+	p.CompensatingLog.TransferID = compensatingID
+	p.OriginalLog.Note = fmt.Sprintf("%s %d by transfer %d", p.Transfer.Kind, p.Transfer.Amount, compensatingID)
+}
+
+// planRefund validates a partial refund against the amount still
+// refundable and builds what needs to be written.
+func planRefund(original refundOriginal, amount int64, createdBy int64, reason string, now time.Time) (*refundPlan, error) {
+	return planCompensation(original, amount, transferKindRefund, createdBy, reason, now)
+}
+
+// planReversal refunds whatever is left of the original transfer.
+func planReversal(original refundOriginal, createdBy int64, reason string, now time.Time) (*refundPlan, error) {
+	remaining := original.Amount - original.Refunded
+	if remaining <= 0 {
+		return nil, fmt.Errorf("%w: transfer %d is fully refunded", ErrRefundExceedsOriginal, original.ID)
+	}
+
+	return planCompensation(original, remaining, transferKindReversal, createdBy, reason, now)
+}
+
+func planCompensation(original refundOriginal, amount int64, kind transferKind, createdBy int64, reason string, now time.Time) (*refundPlan, error) {
+	if original.Status != journalStatusCompleted {
+		return nil, fmt.Errorf("%w: transfer %d is %s", ErrTransferNotCompleted, original.ID, original.Status)
+	}
+
+	if amount <= 0 {
+		return nil, ErrInvalidRefundAmount
+	}
+
+	if original.Refunded+amount > original.Amount {
+		return nil, fmt.Errorf("%w: transfer %d amount %d, refunded %d, requested %d",
+			ErrRefundExceedsOriginal, original.ID, original.Amount, original.Refunded, amount)
+	}
+
+	return &refundPlan{
+		Transfer: compensatingTransfer{
+			OriginalID:  original.ID,
+			AffiliateID: original.AffiliateID,
+			Currency:    original.Currency,
+			Amount:      amount,
+			Kind:        kind,
+			Reason:      reason,
+			CreatedBy:   createdBy,
+			CreatedAt:   now,
+		},
+		OriginalLog: transferLogEntry{
+			TransferID: original.ID,
+			Action:     string(kind),
+			CreatedBy:  createdBy,
+			CreatedAt:  now,
+		},
+		CompensatingLog: transferLogEntry{
+			Action:    string(kind),
+			Note:      fmt.Sprintf("%s of transfer %d: %s", kind, original.ID, reason),
+			CreatedBy: createdBy,
+			CreatedAt: now,
+		},
+	}, nil
+}
+
+// transferTotals splits a search's grand total: refunds and reversals are
+// reported separately and subtracted from the net.
+type transferTotals struct {
+	Gross    int64 `db:"gross" json:"gross"`
+	Refunded int64 `db:"refunded" json:"refunded"`
+	Net      int64 `db:"net" json:"net"`
+}
+
+// transferGrandTotal is TransferGrandTotalResult with the refunds broken
+// out.
+type transferGrandTotal struct {
+	*dt.TransferGrandTotalResult
+	transferTotals
+}
+
+type grandTotalGetter interface {
+	getTransferGrandTotal(ctx context.Context, whereConditions []string, whereParams []any) (*dt.TransferGrandTotalResult, error)
+}
+
+const selectTransferTotalsSQL = `SELECT
+COALESCE(SUM(CASE WHEN kind IN ('refund', 'reversal') THEN 0 ELSE amount END), 0) AS gross,
+COALESCE(SUM(CASE WHEN kind IN ('refund', 'reversal') THEN amount ELSE 0 END), 0) AS refunded,
+COALESCE(SUM(CASE WHEN kind IN ('refund', 'reversal') THEN -amount ELSE amount END), 0) AS net
+FROM direct_transfer`
+
+// getTransferGrandTotalNet reads the grand total of a search together with
+// its refunds, over the same conditions the search passes to
+// getTransferGrandTotal.
+func getTransferGrandTotalNet(ctx context.Context, store grandTotalGetter, db dbConn, whereConditions []string, whereParams []any) (*transferGrandTotal, error) {
+	total, err := store.getTransferGrandTotal(ctx, whereConditions, whereParams)
+	if err != nil {
+		return nil, err
+	}
+
+	query := selectTransferTotalsSQL
+	if len(whereConditions) > 0 {
+		query += "\nWHERE " + strings.Join(whereConditions, " AND ")
+	}
+
+	result := &transferGrandTotal{TransferGrandTotalResult: total}
+	if err := db.GetContext(ctx, &result.transferTotals, query, whereParams...); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,237 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	dt "main/pkg/affiliate/directtransfer"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanRefund(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		original       refundOriginal
+		amount         int64
+		expectedError  error
+		expectedAmount int64
+	}{
+		{
+			name:           "plan refund success",
+			original:       refundOriginal{ID: 1, AffiliateID: 7, Currency: "USD", Amount: 10000, Status: journalStatusCompleted},
+			amount:         4000,
+			expectedError:  nil,
+			expectedAmount: 4000,
+		},
+		{
+			name:           "plan refund success - up to the original",
+			original:       refundOriginal{ID: 1, AffiliateID: 7, Currency: "USD", Amount: 10000, Refunded: 6000, Status: journalStatusCompleted},
+			amount:         4000,
+			expectedError:  nil,
+			expectedAmount: 4000,
+		},
+		{
+			name:          "plan refund error - exceeds original",
+			original:      refundOriginal{ID: 1, AffiliateID: 7, Currency: "USD", Amount: 10000, Refunded: 6000, Status: journalStatusCompleted},
+			amount:        4001,
+			expectedError: ErrRefundExceedsOriginal,
+		},
+		{
+			name:          "plan refund error - not completed",
+			original:      refundOriginal{ID: 1, Amount: 10000, Status: journalStatusPending},
+			amount:        100,
+			expectedError: ErrTransferNotCompleted,
+		},
+		{
+			name:          "plan refund error - zero amount",
+			original:      refundOriginal{ID: 1, Amount: 10000, Status: journalStatusCompleted},
+			amount:        0,
+			expectedError: ErrInvalidRefundAmount,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := planRefund(tc.original, tc.amount, 2, "chargeback", now)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if result.Transfer.Amount != tc.expectedAmount || result.Transfer.Kind != transferKindRefund {
+				t.Errorf("expected refund of %v, got %+v", tc.expectedAmount, result.Transfer)
+			}
+			if result.Transfer.OriginalID != tc.original.ID || result.Transfer.AffiliateID != tc.original.AffiliateID {
+				t.Errorf("expected link to transfer %v, got %+v", tc.original.ID, result.Transfer)
+			}
+		})
+	}
+}
+
+func TestPlanReversal(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		original       refundOriginal
+		expectedError  error
+		expectedAmount int64
+	}{
+		{
+			name:           "plan reversal success",
+			original:       refundOriginal{ID: 1, Amount: 10000, Status: journalStatusCompleted},
+			expectedError:  nil,
+			expectedAmount: 10000,
+		},
+		{
+			name:           "plan reversal success - after partial refund",
+			original:       refundOriginal{ID: 1, Amount: 10000, Refunded: 2500, Status: journalStatusCompleted},
+			expectedError:  nil,
+			expectedAmount: 7500,
+		},
+		{
+			name:          "plan reversal error - already refunded",
+			original:      refundOriginal{ID: 1, Amount: 10000, Refunded: 10000, Status: journalStatusCompleted},
+			expectedError: ErrRefundExceedsOriginal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := planReversal(tc.original, 2, "sent twice", now)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if result.Transfer.Amount != tc.expectedAmount || result.Transfer.Kind != transferKindReversal {
+				t.Errorf("expected reversal of %v, got %+v", tc.expectedAmount, result.Transfer)
+			}
+		})
+	}
+}
+
+func TestRefundPlanLogs(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	original := refundOriginal{ID: 1, AffiliateID: 7, Currency: "USD", Amount: 10000, Status: journalStatusCompleted}
+
+	result, err := planRefund(original, 4000, 2, "chargeback", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result.linkCreated(2)
+
+	if result.OriginalLog.TransferID != 1 || result.OriginalLog.Note != "refund 4000 by transfer 2" {
+		t.Errorf("unexpected original log: %+v", result.OriginalLog)
+	}
+	if result.CompensatingLog.TransferID != 2 || result.CompensatingLog.Note != "refund of transfer 1: chargeback" {
+		t.Errorf("unexpected compensating log: %+v", result.CompensatingLog)
+	}
+	if result.OriginalLog.CreatedBy != 2 || result.CompensatingLog.CreatedBy != 2 {
+		t.Errorf("expected both logs created by user 2, got %+v and %+v", result.OriginalLog, result.CompensatingLog)
+	}
+}
+
+type fakeGrandTotalStore struct {
+	result *dt.TransferGrandTotalResult
+	err    error
+}
+
+func (s fakeGrandTotalStore) getTransferGrandTotal(context.Context, []string, []any) (*dt.TransferGrandTotalResult, error) {
+	return s.result, s.err
+}
+
+// fakeTotalsDB answers the totals query with totals and records it.
+type fakeTotalsDB struct {
+	totals transferTotals
+	err    error
+	query  string
+	args   []any
+}
+
+func (db *fakeTotalsDB) ExecContext(context.Context, string, ...any) (sql.Result, error) {
+	return nil, fmt.Errorf("unexpected exec")
+}
+
+func (db *fakeTotalsDB) GetContext(_ context.Context, dest any, query string, args ...any) error {
+	db.query, db.args = query, args
+	if db.err != nil {
+		return db.err
+	}
+	*dest.(*transferTotals) = db.totals
+	return nil
+}
+
+func (db *fakeTotalsDB) SelectContext(context.Context, any, string, ...any) error {
+	return fmt.Errorf("unexpected select")
+}
+
+func TestGetTransferGrandTotalNet(t *testing.T) {
+	testCases := []struct {
+		name            string
+		whereConditions []string
+		whereParams     []any
+		storeErr        error
+		dbErr           error
+		expectedQuery   string
+		expectedError   error
+	}{
+		{
+			name:          "get transfer grand total net success",
+			expectedQuery: selectTransferTotalsSQL,
+		},
+		{
+			name:            "get transfer grand total net success - filtered",
+			whereConditions: []string{"affiliate_id = ?", "currency = ?"},
+			whereParams:     []any{int64(7), "USD"},
+			expectedQuery:   selectTransferTotalsSQL + "\nWHERE affiliate_id = ? AND currency = ?",
+		},
+		{
+			name:          "get transfer grand total net error - grand total",
+			storeErr:      fmt.Errorf("error"),
+			expectedError: fmt.Errorf("error"),
+		},
+		{
+			name:          "get transfer grand total net error - totals",
+			dbErr:         fmt.Errorf("error"),
+			expectedQuery: selectTransferTotalsSQL,
+			expectedError: fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base := &dt.TransferGrandTotalResult{}
+			db := &fakeTotalsDB{totals: transferTotals{Gross: 15000, Refunded: 9000, Net: 6000}, err: tc.dbErr}
+
+			result, err := getTransferGrandTotalNet(context.Background(), fakeGrandTotalStore{result: base, err: tc.storeErr}, db, tc.whereConditions, tc.whereParams)
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+			if db.query != tc.expectedQuery {
+				t.Fatalf("expected query %q, but got %q", tc.expectedQuery, db.query)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+
+			if result.TransferGrandTotalResult != base || result.transferTotals != db.totals {
+				t.Fatalf("expected the grand total with its refunds, but got %+v", result)
+			}
+			if len(tc.whereParams) > 0 && !reflect.DeepEqual(db.args, tc.whereParams) {
+				t.Fatalf("expected params %v, but got %v", tc.whereParams, db.args)
+			}
+		})
+	}
+}
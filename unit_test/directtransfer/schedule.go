@@ -0,0 +1,248 @@
+package directtransferimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrInvalidSchedule = errors.New("schedule needs either a run time or a rule")
+	ErrInvalidInterval = errors.New("scheduler interval must be positive")
+)
+
+type scheduleRunStatus string
+
+const (
+	scheduleRunCreated scheduleRunStatus = "created"
+	scheduleRunFailed  scheduleRunStatus = "failed"
+	scheduleRunSkipped scheduleRunStatus = "skipped"
+)
+
+// transferSchedule is a one-off transfer when RunAt is set, or a recurring
+// one when Rule is set. Amount is in minor units.
+type transferSchedule struct {
+	ID          int64
+	AffiliateID int64
+	Currency    string
+	Amount      int64
+	RunAt       time.Time
+	Rule        string
+	NextRunAt   time.Time
+	Active      bool
+}
+
+// scheduleRun is one run of a schedule. A skipped run stands for every run
+// missed from DueAt to LastDueAt.
+type scheduleRun struct {
+	ScheduleID int64
+	DueAt      time.Time
+	LastDueAt  time.Time
+	Status     scheduleRunStatus
+	TransferID int64
+	Error      string
+}
+
+type upcomingRun struct {
+	ScheduleID  int64
+	AffiliateID int64
+	Currency    string
+	Amount      int64
+	RunAt       time.Time
+}
+
+// activate validates the schedule and sets its first run.
+func (s *transferSchedule) activate(now time.Time) error {
+	switch {
+	case s.Rule != "" && !s.RunAt.IsZero(), s.Rule == "" && s.RunAt.IsZero():
+		return ErrInvalidSchedule
+	case s.Rule != "":
+		rule, err := parseCronRule(s.Rule)
+		if err != nil {
+			return err
+		}
+		s.NextRunAt = rule.next(now)
+		if s.NextRunAt.IsZero() {
+			return fmt.Errorf("%w: %q never runs", ErrInvalidCronRule, s.Rule)
+		}
+	default:
+		if !s.RunAt.After(now) {
+			return fmt.Errorf("%w: run time %s is in the past", ErrInvalidSchedule, s.RunAt)
+		}
+		s.NextRunAt = s.RunAt
+	}
+
+	s.Active = true
+
+	return nil
+}
+
+// runDueSchedules materializes a transfer for every active schedule whose
+// next run is due. When a recurring schedule missed several runs, only the
+// latest is created and the older ones are recorded as one skipped run, so
+// an outage does not pay an affiliate several times at once.
+func runDueSchedules(ctx context.Context, now time.Time, schedules []*transferSchedule, create func(context.Context, *transferSchedule, time.Time) (int64, error)) []scheduleRun {
+	runs := make([]scheduleRun, 0)
+
+	for _, s := range schedules {
+		if !s.Active || s.NextRunAt.IsZero() || s.NextRunAt.After(now) {
+			continue
+		}
+
+		dueAt := s.NextRunAt
+		var rule *cronRule
+		if s.Rule != "" {
+			var err error
+			if rule, err = parseCronRule(s.Rule); err != nil {
+				s.Active = false
+				runs = append(runs, scheduleRun{ScheduleID: s.ID, DueAt: dueAt, Status: scheduleRunFailed, Error: err.Error()})
+				continue
+			}
+
+			if latest := latestRun(rule, dueAt, now); latest.After(dueAt) {
+				runs = append(runs, scheduleRun{
+					ScheduleID: s.ID,
+					DueAt:      dueAt,
+					LastDueAt:  latestRun(rule, dueAt, latest.Add(-time.Nanosecond)),
+					Status:     scheduleRunSkipped,
+				})
+				dueAt = latest
+			}
+		}
+
+		run := scheduleRun{ScheduleID: s.ID, DueAt: dueAt, Status: scheduleRunCreated}
+		id, err := create(ctx, s, dueAt)
+		if err != nil {
+			run.Status = scheduleRunFailed
+			run.Error = err.Error()
+		}
+		run.TransferID = id
+		runs = append(runs, run)
+
+		if rule == nil {
+			s.Active = false
+			continue
+		}
+		s.NextRunAt = rule.next(dueAt)
+	}
+
+	return runs
+}
+
+// latestRun returns the last run of rule at or before until, dueAt being a
+// run at or before until. It searches back from until over growing windows
+// rather than stepping through every run since dueAt, so catching up after
+// a long outage of a frequent schedule stays cheap.
+func latestRun(rule *cronRule, dueAt, until time.Time) time.Time {
+	from := dueAt
+	for _, window := range []time.Duration{time.Hour, 24 * time.Hour, 32 * 24 * time.Hour, 367 * 24 * time.Hour} {
+		if start := until.Add(-window); start.After(dueAt) {
+			if at := rule.next(start); !at.IsZero() && !at.After(until) {
+				from = at
+				break
+			}
+		}
+	}
+
+	latest := from
+	for next := rule.next(latest); !next.IsZero() && !next.After(until); next = rule.next(next) {
+		latest = next
+	}
+
+	return latest
+}
+
+// upcomingRuns lists the next runs of the active schedules after from, in
+// time order, up to limit entries.
+func upcomingRuns(schedules []*transferSchedule, from time.Time, limit int) []upcomingRun {
+	runs := make([]upcomingRun, 0)
+
+	for _, s := range schedules {
+		if !s.Active || s.NextRunAt.IsZero() {
+			continue
+		}
+
+		var rule *cronRule
+		if s.Rule != "" {
+			var err error
+			if rule, err = parseCronRule(s.Rule); err != nil {
+				continue
+			}
+		}
+
+		at := s.NextRunAt
+		if rule == nil {
+			if !at.Before(from) {
+				runs = append(runs, upcomingRun{ScheduleID: s.ID, AffiliateID: s.AffiliateID, Currency: s.Currency, Amount: s.Amount, RunAt: at})
+			}
+			continue
+		}
+
+		if at.Before(from) {
+			at = rule.next(from.Add(-time.Nanosecond))
+		}
+		for n := 0; n < limit && !at.IsZero(); n++ {
+			runs = append(runs, upcomingRun{ScheduleID: s.ID, AffiliateID: s.AffiliateID, Currency: s.Currency, Amount: s.Amount, RunAt: at})
+			at = rule.next(at)
+		}
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].RunAt.Before(runs[j].RunAt)
+	})
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+
+	return runs
+}
+
+// transferScheduler periodically runs the due schedules.
+type transferScheduler struct {
+	interval time.Duration
+	now      func() time.Time
+	load     func(ctx context.Context, now time.Time) ([]*transferSchedule, error)
+	create   func(ctx context.Context, s *transferSchedule, dueAt time.Time) (int64, error)
+	save     func(ctx context.Context, schedules []*transferSchedule, runs []scheduleRun) error
+}
+
+func (s *transferScheduler) tick(ctx context.Context) error {
+	now := s.now()
+
+	schedules, err := s.load(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	runs := runDueSchedules(ctx, now, schedules, s.create)
+	if len(runs) == 0 {
+		return nil
+	}
+
+	return s.save(ctx, schedules, runs)
+}
+
+// Run ticks until the context is cancelled. Tick errors are returned to
+// onError and do not stop the scheduler.
+func (s *transferScheduler) Run(ctx context.Context, onError func(error)) error {
+	if s.interval <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidInterval, s.interval)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.tick(ctx); err != nil && onError != nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
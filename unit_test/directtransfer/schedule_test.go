@@ -0,0 +1,329 @@
+package directtransferimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseCronRule(t *testing.T) {
+	testCases := []struct {
+		rule          string
+		expectedError error
+	}{
+		{rule: "0 0 1 * *"},
+		{rule: "*/15 9-17 * * 1-5"},
+		{rule: "0 12 1,15 * *"},
+		{rule: "0 0 * * 7"},
+		{rule: "5/20 * * * *"},
+		{rule: "0 0 1 *", expectedError: ErrInvalidCronRule},
+		{rule: "60 0 1 * *", expectedError: ErrInvalidCronRule},
+		{rule: "0 24 * * *", expectedError: ErrInvalidCronRule},
+		{rule: "0 0 0 * *", expectedError: ErrInvalidCronRule},
+		{rule: "0 0 * 13 *", expectedError: ErrInvalidCronRule},
+		{rule: "0 0 5-1 * *", expectedError: ErrInvalidCronRule},
+		{rule: "*/0 * * * *", expectedError: ErrInvalidCronRule},
+		{rule: "a * * * *", expectedError: ErrInvalidCronRule},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.rule, func(t *testing.T) {
+			_, err := parseCronRule(tc.rule)
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("expected %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestCronRuleNext(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rule     string
+		after    time.Time
+		expected time.Time
+	}{
+		{
+			name:     "monthly",
+			rule:     "0 0 1 * *",
+			after:    time.Date(2026, 10, 19, 13, 45, 0, 0, time.UTC),
+			expected: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "monthly - strictly after a run",
+			rule:     "0 0 1 * *",
+			after:    time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "year rollover",
+			rule:     "0 0 1 * *",
+			after:    time.Date(2026, 12, 15, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekdays every 15 minutes in office hours",
+			rule:     "*/15 9-17 * * 1-5",
+			after:    time.Date(2026, 10, 16, 17, 50, 0, 0, time.UTC), // Friday
+			expected: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),   // Monday
+		},
+		{
+			name:     "sunday written as 7",
+			rule:     "30 8 * * 7",
+			after:    time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 10, 25, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of month or day of week",
+			rule:     "0 0 13 * 5",
+			after:    time.Date(2026, 10, 10, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "skips short months",
+			rule:     "0 0 31 * *",
+			after:    time.Date(2026, 10, 31, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "never matches",
+			rule:     "0 0 30 2 *",
+			after:    time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Time{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := parseCronRule(tc.rule)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			result := rule.next(tc.after)
+			if !result.Equal(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestActivateSchedule(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		schedule      *transferSchedule
+		expectedError error
+		expectedNext  time.Time
+	}{
+		{
+			name:          "activate schedule success - one off",
+			schedule:      &transferSchedule{RunAt: time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+			expectedError: nil,
+			expectedNext:  time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "activate schedule success - recurring",
+			schedule:      &transferSchedule{Rule: "0 0 1 * *"},
+			expectedError: nil,
+			expectedNext:  time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "activate schedule error - one off in the past",
+			schedule:      &transferSchedule{RunAt: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+			expectedError: ErrInvalidSchedule,
+		},
+		{
+			name:          "activate schedule error - both run time and rule",
+			schedule:      &transferSchedule{RunAt: time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC), Rule: "0 0 1 * *"},
+			expectedError: ErrInvalidSchedule,
+		},
+		{
+			name:          "activate schedule error - never runs",
+			schedule:      &transferSchedule{Rule: "0 0 30 2 *"},
+			expectedError: ErrInvalidCronRule,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.schedule.activate(now)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if err == nil && (!tc.schedule.Active || !tc.schedule.NextRunAt.Equal(tc.expectedNext)) {
+				t.Errorf("expected active with next run %v, got %+v", tc.expectedNext, tc.schedule)
+			}
+		})
+	}
+}
+
+func TestRunDueSchedules(t *testing.T) {
+	now := time.Date(2026, 11, 1, 0, 5, 0, 0, time.UTC)
+
+	monthly := &transferSchedule{ID: 1, AffiliateID: 7, Rule: "0 0 1 * *", NextRunAt: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), Active: true}
+	missed := &transferSchedule{ID: 2, AffiliateID: 8, Rule: "0 0 * * *", NextRunAt: time.Date(2026, 10, 30, 0, 0, 0, 0, time.UTC), Active: true}
+	oneOff := &transferSchedule{ID: 3, AffiliateID: 9, RunAt: time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC), NextRunAt: time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC), Active: true}
+	failing := &transferSchedule{ID: 4, AffiliateID: 10, Rule: "0 0 1 * *", NextRunAt: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), Active: true}
+	notDue := &transferSchedule{ID: 5, AffiliateID: 11, Rule: "0 0 2 * *", NextRunAt: time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC), Active: true}
+	inactive := &transferSchedule{ID: 6, AffiliateID: 12, RunAt: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), NextRunAt: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+
+	var materialized []int64
+	create := func(_ context.Context, s *transferSchedule, dueAt time.Time) (int64, error) {
+		if s.ID == 4 {
+			return 0, fmt.Errorf("affiliate suspended")
+		}
+		materialized = append(materialized, s.ID)
+		return 100 + s.ID, nil
+	}
+
+	runs := runDueSchedules(context.Background(), now, []*transferSchedule{monthly, missed, oneOff, failing, notDue, inactive}, create)
+
+	expected := []scheduleRun{
+		{ScheduleID: 1, DueAt: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), Status: scheduleRunCreated, TransferID: 101},
+		{ScheduleID: 2, DueAt: time.Date(2026, 10, 30, 0, 0, 0, 0, time.UTC), LastDueAt: time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC), Status: scheduleRunSkipped},
+		{ScheduleID: 2, DueAt: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), Status: scheduleRunCreated, TransferID: 102},
+		{ScheduleID: 3, DueAt: time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC), Status: scheduleRunCreated, TransferID: 103},
+		{ScheduleID: 4, DueAt: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), Status: scheduleRunFailed, Error: "affiliate suspended"},
+	}
+
+	if len(runs) != len(expected) {
+		t.Fatalf("expected %d runs, got %d: %+v", len(expected), len(runs), runs)
+	}
+	for i := range expected {
+		if runs[i].ScheduleID != expected[i].ScheduleID || !runs[i].DueAt.Equal(expected[i].DueAt) || !runs[i].LastDueAt.Equal(expected[i].LastDueAt) ||
+			runs[i].Status != expected[i].Status || runs[i].TransferID != expected[i].TransferID || runs[i].Error != expected[i].Error {
+			t.Errorf("run %d: expected %+v, got %+v", i, expected[i], runs[i])
+		}
+	}
+
+	if len(materialized) != 3 {
+		t.Errorf("expected 3 transfers, got %v", materialized)
+	}
+	if !monthly.NextRunAt.Equal(time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected monthly schedule to move to December, got %v", monthly.NextRunAt)
+	}
+	if !failing.NextRunAt.Equal(time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)) || !failing.Active {
+		t.Errorf("expected failed schedule to stay active and move on, got %+v", failing)
+	}
+	if oneOff.Active {
+		t.Errorf("expected one off schedule to be done")
+	}
+}
+
+func TestRunDueSchedulesLongOutage(t *testing.T) {
+	now := time.Date(2026, 11, 1, 12, 30, 20, 0, time.UTC)
+
+	testCases := []struct {
+		name              string
+		schedule          *transferSchedule
+		expectedLastDueAt time.Time
+		expectedDueAt     time.Time
+	}{
+		{
+			name:              "run due schedules success - every minute for two years",
+			schedule:          &transferSchedule{ID: 1, Rule: "* * * * *", NextRunAt: time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC), Active: true},
+			expectedLastDueAt: time.Date(2026, 11, 1, 12, 29, 0, 0, time.UTC),
+			expectedDueAt:     time.Date(2026, 11, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name:              "run due schedules success - missed one run",
+			schedule:          &transferSchedule{ID: 2, Rule: "0 12 * * *", NextRunAt: time.Date(2026, 10, 31, 12, 0, 0, 0, time.UTC), Active: true},
+			expectedLastDueAt: time.Date(2026, 10, 31, 12, 0, 0, 0, time.UTC),
+			expectedDueAt:     time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dueAt := tc.schedule.NextRunAt
+			create := func(context.Context, *transferSchedule, time.Time) (int64, error) { return 10, nil }
+
+			runs := runDueSchedules(context.Background(), now, []*transferSchedule{tc.schedule}, create)
+
+			if len(runs) != 2 {
+				t.Fatalf("expected one skipped and one created run, got %+v", runs)
+			}
+			if runs[0].Status != scheduleRunSkipped || !runs[0].DueAt.Equal(dueAt) || !runs[0].LastDueAt.Equal(tc.expectedLastDueAt) {
+				t.Errorf("expected runs from %v to %v skipped, got %+v", dueAt, tc.expectedLastDueAt, runs[0])
+			}
+			if runs[1].Status != scheduleRunCreated || !runs[1].DueAt.Equal(tc.expectedDueAt) {
+				t.Errorf("expected a run created for %v, got %+v", tc.expectedDueAt, runs[1])
+			}
+		})
+	}
+}
+
+func TestUpcomingRuns(t *testing.T) {
+	from := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	schedules := []*transferSchedule{
+		{ID: 1, Rule: "0 0 1 * *", NextRunAt: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), Active: true},
+		{ID: 2, RunAt: time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC), NextRunAt: time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC), Active: true},
+		{ID: 3, Rule: "0 0 1 * *", NextRunAt: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	runs := upcomingRuns(schedules, from, 3)
+
+	expected := []upcomingRun{
+		{ScheduleID: 1, RunAt: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{ScheduleID: 2, RunAt: time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC)},
+		{ScheduleID: 1, RunAt: time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	if len(runs) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, runs)
+	}
+	for i := range expected {
+		if runs[i].ScheduleID != expected[i].ScheduleID || !runs[i].RunAt.Equal(expected[i].RunAt) {
+			t.Errorf("expected %+v, got %+v", expected[i], runs[i])
+		}
+	}
+}
+
+func TestSchedulerTick(t *testing.T) {
+	now := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	schedule := &transferSchedule{ID: 1, Rule: "0 0 1 * *", NextRunAt: now, Active: true}
+
+	var saved []scheduleRun
+	scheduler := &transferScheduler{
+		now: func() time.Time { return now },
+		load: func(context.Context, time.Time) ([]*transferSchedule, error) {
+			return []*transferSchedule{schedule}, nil
+		},
+		create: func(context.Context, *transferSchedule, time.Time) (int64, error) {
+			return 10, nil
+		},
+		save: func(_ context.Context, _ []*transferSchedule, runs []scheduleRun) error {
+			saved = runs
+			return nil
+		},
+	}
+
+	if err := scheduler.tick(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(saved) != 1 || saved[0].TransferID != 10 || saved[0].Status != scheduleRunCreated {
+		t.Errorf("expected one created run, got %+v", saved)
+	}
+
+	saved = nil
+	if err := scheduler.tick(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved != nil {
+		t.Errorf("expected nothing due on the second tick, got %+v", saved)
+	}
+}
+
+func TestSchedulerRunInvalidInterval(t *testing.T) {
+	scheduler := &transferScheduler{}
+
+	err := scheduler.Run(context.Background(), nil)
+	if !errors.Is(err, ErrInvalidInterval) {
+		t.Fatalf("expected error %q, but got %v", ErrInvalidInterval, err)
+	}
+}
@@ -0,0 +1,227 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	dt "main/pkg/affiliate/directtransfer"
+	"sort"
+	"time"
+)
+
+// timelineLog is a status change read from the transfer log.
+type timelineLog struct {
+	Status    string    `db:"status"`
+	CreatedBy int64     `db:"created_by"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// transferTimeline is the timeline of one transfer. CreatedAt is when the
+// transfer was created, which time-to-complete is measured from.
+type transferTimeline struct {
+	TransferID int64
+	CreatedAt  time.Time
+	Entries    []*timelineEntry
+}
+
+// timelineEntry is one stay in a status. LeftAt is zero while the transfer
+// is still in the status; Duration then runs until now. Terminal statuses
+// have no duration.
+type timelineEntry struct {
+	Status    string
+	MovedBy   int64
+	EnteredAt time.Time
+	LeftAt    time.Time
+	Duration  time.Duration
+	Breached  bool
+}
+
+// statusSLA is the longest a transfer may stay in a status.
+type statusSLA struct {
+	Status      string
+	MaxDuration time.Duration
+}
+
+// slaStats holds the SLA breaches per status and the average time from
+// creation to completion.
+type slaStats struct {
+	Breaches          map[string]int64
+	BreachedTransfers int64
+	Completed         int64
+	AvgTimeToComplete time.Duration
+}
+
+func isTerminalStatus(status string) bool {
+	return status == string(journalStatusCompleted) || status == string(journalStatusRejected)
+}
+
+// buildTimeline turns a transfer's log rows into the time spent in each
+// status and who moved it there, flagging stays longer than the status SLA.
+func buildTimeline(logs []timelineLog, slas []statusSLA, now time.Time) []*timelineEntry {
+	sorted := make([]timelineLog, len(logs))
+	copy(sorted, logs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	limits := make(map[string]time.Duration, len(slas))
+	for _, sla := range slas {
+		limits[sla.Status] = sla.MaxDuration
+	}
+
+	timeline := make([]*timelineEntry, 0, len(sorted))
+	for i, log := range sorted {
+		entry := &timelineEntry{
+			Status:    log.Status,
+			MovedBy:   log.CreatedBy,
+			EnteredAt: log.CreatedAt,
+		}
+
+		switch {
+		case i+1 < len(sorted):
+			entry.LeftAt = sorted[i+1].CreatedAt
+			entry.Duration = entry.LeftAt.Sub(entry.EnteredAt)
+		case !isTerminalStatus(log.Status):
+			entry.Duration = now.Sub(entry.EnteredAt)
+		}
+
+		if max, ok := limits[log.Status]; ok && max > 0 && entry.Duration > max {
+			entry.Breached = true
+		}
+
+		timeline = append(timeline, entry)
+	}
+
+	return timeline
+}
+
+// summarizeSLA counts SLA breaches and the average time-to-complete over a
+// set of transfer timelines.
+func summarizeSLA(timelines []*transferTimeline) *slaStats {
+	stats := &slaStats{Breaches: map[string]int64{}}
+
+	var total time.Duration
+	for _, timeline := range timelines {
+		if len(timeline.Entries) == 0 {
+			continue
+		}
+
+		breached := false
+		for _, entry := range timeline.Entries {
+			if entry.Breached {
+				stats.Breaches[entry.Status]++
+				breached = true
+			}
+		}
+		if breached {
+			stats.BreachedTransfers++
+		}
+
+		last := timeline.Entries[len(timeline.Entries)-1]
+		if last.Status == string(journalStatusCompleted) {
+			stats.Completed++
+			total += last.EnteredAt.Sub(timeline.CreatedAt)
+		}
+	}
+
+	if stats.Completed > 0 {
+		stats.AvgTimeToComplete = total / time.Duration(stats.Completed)
+	}
+
+	return stats
+}
+
+// statusStatsGetter is the store's status count, which getStatusStatsSLA
+// extends.
+type statusStatsGetter interface {
+	GetStatusStats(ctx context.Context, query *dt.GetStatustatsQuery) (*dt.GetStatusStatsResult, error)
+}
+
+// statusStatsSLA is a GetStatustatsQuery result with the SLA stats of the
+// same transfers.
+type statusStatsSLA struct {
+	*dt.GetStatusStatsResult
+	slaStats
+}
+
+const (
+	selectTransferCreatedAtSQL = `SELECT created_at FROM direct_transfer WHERE id = ?`
+
+	// Refund and reversal log rows record a compensating transfer, not a
+	// status change, so they are left out of the timelines.
+	selectTransferTimelineSQL = `SELECT action AS status, created_by, created_at
+FROM direct_transfer_log
+WHERE direct_transfer_id = ? AND action NOT IN ('refund', 'reversal')
+ORDER BY created_at, id`
+
+	selectTimelineLogsSQL = `SELECT t.id AS direct_transfer_id, t.created_at AS transfer_created_at, l.action AS status, l.created_by, l.created_at
+FROM direct_transfer t
+JOIN direct_transfer_log l ON l.direct_transfer_id = t.id AND l.action NOT IN ('refund', 'reversal')
+WHERE t.kind = 'transfer'`
+)
+
+// timelineLogRow is a timelineLog read together with its transfer.
+type timelineLogRow struct {
+	TransferID        int64     `db:"direct_transfer_id"`
+	TransferCreatedAt time.Time `db:"transfer_created_at"`
+	timelineLog
+}
+
+// getTransferTimeline reads the status log of a transfer as a timeline.
+func getTransferTimeline(ctx context.Context, db dbConn, id int64, slas []statusSLA, now time.Time) (*transferTimeline, error) {
+	timeline := &transferTimeline{TransferID: id}
+	err := db.GetContext(ctx, &timeline.CreatedAt, selectTransferCreatedAtSQL, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrTransferNotFound, id)
+	} else if err != nil {
+		return nil, err
+	}
+
+	logs := make([]timelineLog, 0)
+	if err := db.SelectContext(ctx, &logs, selectTransferTimelineSQL, id); err != nil {
+		return nil, err
+	}
+	timeline.Entries = buildTimeline(logs, slas, now)
+
+	return timeline, nil
+}
+
+// getStatusStatsSLA runs the store's status stats for query and adds the SLA
+// stats of the transfers matching the same conditions, which the caller
+// builds from query the way the store does.
+func getStatusStatsSLA(ctx context.Context, store statusStatsGetter, db dbConn, query *dt.GetStatustatsQuery,
+	whereConditions []string, whereParams []any, slas []statusSLA, now time.Time) (*statusStatsSLA, error) {
+	stats, err := store.GetStatusStats(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlQuery := selectTimelineLogsSQL
+	for _, condition := range whereConditions {
+		sqlQuery += " AND " + condition
+	}
+	sqlQuery += "\nORDER BY t.id, l.created_at, l.id"
+
+	rows := make([]timelineLogRow, 0)
+	if err := db.SelectContext(ctx, &rows, sqlQuery, whereParams...); err != nil {
+		return nil, err
+	}
+
+	var timelines []*transferTimeline
+	for start := 0; start < len(rows); {
+		end := start
+		logs := make([]timelineLog, 0)
+		for ; end < len(rows) && rows[end].TransferID == rows[start].TransferID; end++ {
+			logs = append(logs, rows[end].timelineLog)
+		}
+		timelines = append(timelines, &transferTimeline{
+			TransferID: rows[start].TransferID,
+			CreatedAt:  rows[start].TransferCreatedAt,
+			Entries:    buildTimeline(logs, slas, now),
+		})
+		start = end
+	}
+
+	return &statusStatsSLA{GetStatusStatsResult: stats, slaStats: *summarizeSLA(timelines)}, nil
+}
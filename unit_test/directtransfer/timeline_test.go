@@ -0,0 +1,237 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	dt "main/pkg/affiliate/directtransfer"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	slas := []statusSLA{
+		{Status: "pending", MaxDuration: 2 * time.Hour},
+		{Status: "processing", MaxDuration: 30 * time.Minute},
+	}
+
+	testCases := []struct {
+		name           string
+		logs           []timelineLog
+		now            time.Time
+		expectedResult []timelineEntry
+	}{
+		{
+			name: "build timeline completed",
+			logs: []timelineLog{
+				{Status: "processing", CreatedBy: 7, CreatedAt: start.Add(time.Hour)},
+				{Status: "pending", CreatedBy: 3, CreatedAt: start},
+				{Status: "completed", CreatedBy: 7, CreatedAt: start.Add(2 * time.Hour)},
+			},
+			now: start.Add(5 * time.Hour),
+			expectedResult: []timelineEntry{
+				{Status: "pending", MovedBy: 3, EnteredAt: start, LeftAt: start.Add(time.Hour), Duration: time.Hour},
+				{Status: "processing", MovedBy: 7, EnteredAt: start.Add(time.Hour), LeftAt: start.Add(2 * time.Hour), Duration: time.Hour, Breached: true},
+				{Status: "completed", MovedBy: 7, EnteredAt: start.Add(2 * time.Hour)},
+			},
+		},
+		{
+			name: "build timeline still pending",
+			logs: []timelineLog{
+				{Status: "pending", CreatedBy: 3, CreatedAt: start},
+			},
+			now: start.Add(3 * time.Hour),
+			expectedResult: []timelineEntry{
+				{Status: "pending", MovedBy: 3, EnteredAt: start, Duration: 3 * time.Hour, Breached: true},
+			},
+		},
+		{
+			name: "build timeline within sla",
+			logs: []timelineLog{
+				{Status: "pending", CreatedBy: 3, CreatedAt: start},
+			},
+			now: start.Add(time.Hour),
+			expectedResult: []timelineEntry{
+				{Status: "pending", MovedBy: 3, EnteredAt: start, Duration: time.Hour},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := buildTimeline(tc.logs, slas, tc.now)
+			if len(result) != len(tc.expectedResult) {
+				t.Fatalf("expected %d entries, got %d", len(tc.expectedResult), len(result))
+			}
+			for i, entry := range result {
+				if *entry != tc.expectedResult[i] {
+					t.Errorf("expected %+v, got %+v", tc.expectedResult[i], *entry)
+				}
+			}
+		})
+	}
+}
+
+func TestSummarizeSLA(t *testing.T) {
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	now := start.Add(10 * time.Hour)
+	slas := []statusSLA{{Status: "pending", MaxDuration: 2 * time.Hour}}
+	timeline := func(createdAt time.Time, logs ...timelineLog) *transferTimeline {
+		return &transferTimeline{CreatedAt: createdAt, Entries: buildTimeline(logs, slas, now)}
+	}
+
+	timelines := []*transferTimeline{
+		timeline(start,
+			timelineLog{Status: "pending", CreatedAt: start},
+			timelineLog{Status: "completed", CreatedAt: start.Add(time.Hour)},
+		),
+		// Logged an hour after creation; the hour still counts.
+		timeline(start.Add(-time.Hour),
+			timelineLog{Status: "pending", CreatedAt: start},
+			timelineLog{Status: "completed", CreatedAt: start.Add(3 * time.Hour)},
+		),
+		timeline(start, timelineLog{Status: "pending", CreatedAt: start}),
+		timeline(start,
+			timelineLog{Status: "pending", CreatedAt: start},
+			timelineLog{Status: "rejected", CreatedAt: start.Add(30 * time.Minute)},
+		),
+	}
+
+	result := summarizeSLA(timelines)
+
+	if result.Breaches["pending"] != 2 || result.BreachedTransfers != 2 {
+		t.Errorf("expected 2 pending breaches, got %+v", result)
+	}
+	if result.Completed != 2 || result.AvgTimeToComplete != 150*time.Minute {
+		t.Errorf("expected 2 completed in %v on average, got %d in %v", 150*time.Minute, result.Completed, result.AvgTimeToComplete)
+	}
+}
+
+type fakeStatusStatsStore struct {
+	err error
+}
+
+func (s fakeStatusStatsStore) GetStatusStats(context.Context, *dt.GetStatustatsQuery) (*dt.GetStatusStatsResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &dt.GetStatusStatsResult{}, nil
+}
+
+// fakeTimelineDB answers the timeline queries from rows, recording the
+// query and params it was given.
+type fakeTimelineDB struct {
+	dbConn
+	createdAt time.Time
+	rows      []timelineLogRow
+	query     string
+	params    []any
+	err       error
+}
+
+func (db *fakeTimelineDB) GetContext(_ context.Context, dest any, _ string, _ ...any) error {
+	if db.createdAt.IsZero() {
+		return sql.ErrNoRows
+	}
+	*dest.(*time.Time) = db.createdAt
+	return nil
+}
+
+func (db *fakeTimelineDB) SelectContext(_ context.Context, dest any, query string, args ...any) error {
+	db.query, db.params = query, args
+	if db.err != nil {
+		return db.err
+	}
+
+	switch dest := dest.(type) {
+	case *[]timelineLogRow:
+		*dest = append(*dest, db.rows...)
+	case *[]timelineLog:
+		for _, row := range db.rows {
+			*dest = append(*dest, row.timelineLog)
+		}
+	}
+
+	return nil
+}
+
+func TestGetStatusStatsSLA(t *testing.T) {
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	slas := []statusSLA{{Status: "pending", MaxDuration: time.Hour}}
+	row := func(id int64, status string, at time.Time) timelineLogRow {
+		return timelineLogRow{TransferID: id, TransferCreatedAt: start, timelineLog: timelineLog{Status: status, CreatedAt: at}}
+	}
+
+	testCases := []struct {
+		name          string
+		store         fakeStatusStatsStore
+		db            *fakeTimelineDB
+		expectedError error
+	}{
+		{
+			name: "get status stats sla success",
+			db: &fakeTimelineDB{rows: []timelineLogRow{
+				row(1, "pending", start), row(1, "completed", start.Add(2*time.Hour)),
+				row(2, "pending", start), row(2, "completed", start.Add(30*time.Minute)),
+			}},
+		},
+		{
+			name:          "get status stats sla error - store",
+			store:         fakeStatusStatsStore{err: fmt.Errorf("error")},
+			db:            &fakeTimelineDB{},
+			expectedError: fmt.Errorf("error"),
+		},
+		{
+			name:          "get status stats sla error - logs",
+			db:            &fakeTimelineDB{err: fmt.Errorf("error")},
+			expectedError: fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := getStatusStatsSLA(context.Background(), tc.store, tc.db, &dt.GetStatustatsQuery{},
+				[]string{"t.affiliate_id = ?"}, []any{int64(7)}, slas, start.Add(5*time.Hour))
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+
+			if result.GetStatusStatsResult == nil {
+				t.Fatalf("expected the status stats to be kept")
+			}
+			if result.Completed != 2 || result.AvgTimeToComplete != 75*time.Minute || result.Breaches["pending"] != 1 {
+				t.Fatalf("expected 2 completed in %v with 1 pending breach, but got %+v", 75*time.Minute, result.slaStats)
+			}
+			if !strings.Contains(tc.db.query, "AND t.affiliate_id = ?") || len(tc.db.params) != 1 {
+				t.Fatalf("expected the stats conditions in the query, but got %q %v", tc.db.query, tc.db.params)
+			}
+		})
+	}
+}
+
+func TestGetTransferTimeline(t *testing.T) {
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+	if _, err := getTransferTimeline(context.Background(), &fakeTimelineDB{}, 1, nil, start); !errors.Is(err, ErrTransferNotFound) {
+		t.Fatalf("expected error %q, but got %v", ErrTransferNotFound, err)
+	}
+
+	db := &fakeTimelineDB{createdAt: start, rows: []timelineLogRow{
+		{timelineLog: timelineLog{Status: "pending", CreatedBy: 3, CreatedAt: start}},
+	}}
+	result, err := getTransferTimeline(context.Background(), db, 1, nil, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	if !result.CreatedAt.Equal(start) || len(result.Entries) != 1 || result.Entries[0].MovedBy != 3 || result.Entries[0].Duration != time.Hour {
+		t.Fatalf("expected one pending hour moved by 3, but got %+v", result.Entries)
+	}
+}
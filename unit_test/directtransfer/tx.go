@@ -0,0 +1,50 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// dbConn is the part of *sqlx.DB and *sqlx.Tx the transfer writes use. It
+// has the same methods as ledgerimpl.DB, so a transaction can post ledger
+// entries directly.
+type dbConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+type sqlTx interface {
+	dbConn
+	Commit() error
+	Rollback() error
+}
+
+// txBeginner is satisfied by *sqlx.DB.
+type txBeginner[T sqlTx] interface {
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (T, error)
+}
+
+type beginFunc func(ctx context.Context) (sqlTx, error)
+
+func newBeginFunc[T sqlTx](db txBeginner[T]) beginFunc {
+	return func(ctx context.Context) (sqlTx, error) {
+		return db.BeginTxx(ctx, nil)
+	}
+}
+
+// inTx runs fn in a transaction, committing when it returns nil and rolling
+// back otherwise.
+func (begin beginFunc) inTx(ctx context.Context, fn func(tx dbConn) error) error {
+	tx, err := begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
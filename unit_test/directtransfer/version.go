@@ -0,0 +1,62 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrConcurrentModification = errors.New("transfer was modified since it was read")
+
+// versionConn is the part of dbConn updateStatusCAS uses.
+type versionConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+type transferStatusUpdate struct {
+	ID        int64
+	Version   int64
+	Status    journalStatus
+	UpdatedBy int64
+	UpdatedAt time.Time
+}
+
+const (
+	updateTransferStatusSQL = `UPDATE direct_transfer
+SET status = ?, version = version + 1, updated_by = ?, updated_at = ?
+WHERE id = ? AND version = ?`
+
+	selectTransferVersionSQL = `SELECT version FROM direct_transfer WHERE id = ?`
+)
+
+// updateStatusCAS writes the status only when the row still has the version
+// the caller read, so of two operators approving and rejecting at the same
+// time exactly one wins. It returns the new version, ErrTransferNotFound
+// when there is no such transfer and ErrConcurrentModification when it has
+// moved past u.Version.
+func updateStatusCAS(ctx context.Context, db versionConn, u transferStatusUpdate) (int64, error) {
+	result, err := db.ExecContext(ctx, updateTransferStatusSQL, u.Status, u.UpdatedBy, u.UpdatedAt, u.ID, u.Version)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		var current int64
+		err := db.GetContext(ctx, &current, selectTransferVersionSQL, u.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: %d", ErrTransferNotFound, u.ID)
+		} else if err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: transfer %d at version %d, now %d", ErrConcurrentModification, u.ID, u.Version, current)
+	}
+
+	return u.Version + 1, nil
+}
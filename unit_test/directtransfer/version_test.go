@@ -0,0 +1,101 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fakeResult struct {
+	lastInsertID int64
+	rowsAffected int64
+	err          error
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.rowsAffected, r.err }
+
+// fakeExecer returns result for the update and reads version back when it
+// affected no rows; a zero version is a missing transfer.
+type fakeExecer struct {
+	result  sql.Result
+	err     error
+	version int64
+	args    []any
+}
+
+func (f *fakeExecer) ExecContext(_ context.Context, _ string, args ...any) (sql.Result, error) {
+	f.args = args
+	return f.result, f.err
+}
+
+func (f *fakeExecer) GetContext(_ context.Context, dest any, _ string, _ ...any) error {
+	if f.version == 0 {
+		return sql.ErrNoRows
+	}
+	*dest.(*int64) = f.version
+	return nil
+}
+
+func TestUpdateStatusCAS(t *testing.T) {
+	testCases := []struct {
+		name            string
+		db              *fakeExecer
+		expectedError   error
+		expectedVersion int64
+	}{
+		{
+			name:            "update status success",
+			db:              &fakeExecer{result: fakeResult{rowsAffected: 1}},
+			expectedVersion: 5,
+		},
+		{
+			name:          "update status error - concurrent modification",
+			db:            &fakeExecer{result: fakeResult{rowsAffected: 0}, version: 5},
+			expectedError: ErrConcurrentModification,
+		},
+		{
+			name:          "update status error - not found",
+			db:            &fakeExecer{result: fakeResult{rowsAffected: 0}},
+			expectedError: ErrTransferNotFound,
+		},
+		{
+			name:          "update status error",
+			db:            &fakeExecer{err: fmt.Errorf("error")},
+			expectedError: fmt.Errorf("error"),
+		},
+		{
+			name:          "update status error - rows affected",
+			db:            &fakeExecer{result: fakeResult{err: fmt.Errorf("error")}},
+			expectedError: fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			update := transferStatusUpdate{ID: 9, Version: 4, Status: "completed", UpdatedBy: 2, UpdatedAt: time.Now()}
+
+			version, err := updateStatusCAS(context.Background(), tc.db, update)
+			if err != nil && tc.expectedError == nil {
+				t.Errorf("expected %v, got %v", tc.expectedError, err)
+			} else if err == nil && tc.expectedError != nil {
+				t.Errorf("expected %v, got %v", tc.expectedError, err)
+			}
+
+			for _, target := range []error{ErrConcurrentModification, ErrTransferNotFound} {
+				if errors.Is(tc.expectedError, target) != errors.Is(err, target) {
+					t.Errorf("expected %v, got %v", tc.expectedError, err)
+				}
+			}
+			if version != tc.expectedVersion {
+				t.Errorf("expected version %v, got %v", tc.expectedVersion, version)
+			}
+			if tc.db.args[3] != update.ID || tc.db.args[4] != update.Version {
+				t.Errorf("expected id %v at version %v, got %v", update.ID, update.Version, tc.db.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"main/pkg/affiliate/ledger/ledgerimpl"
+	"time"
+)
+
+var ErrTransferNotFound = errors.New("transfer not found")
+
+// transferCreate is a new transfer. Amount is in minor units.
+type transferCreate struct {
+	AffiliateID   int64
+	Currency      string
+	Amount        int64
+	TransactionID string
+	CreatedBy     int64
+}
+
+// transferRow is the part of a direct_transfer row the writes read back.
+// OriginalID is 0 unless Kind is a refund or reversal.
+type transferRow struct {
+	ID          int64         `db:"id"`
+	AffiliateID int64         `db:"affiliate_id"`
+	Currency    string        `db:"currency"`
+	Amount      int64         `db:"amount"`
+	Kind        transferKind  `db:"kind"`
+	OriginalID  int64         `db:"original_id"`
+	Status      journalStatus `db:"status"`
+	Version     int64         `db:"version"`
+}
+
+func (r transferRow) journal() journalTransfer {
+	return journalTransfer{ID: r.ID, AffiliateID: r.AffiliateID, Currency: r.Currency, Amount: r.Amount}
+}
+
+const (
+	insertTransferSQL = `INSERT INTO direct_transfer
+(affiliate_id, currency, amount, kind, original_id, status, version, transaction_id, created_by, created_at)
+VALUES (?, ?, ?, ?, NULLIF(?, 0), ?, 1, ?, ?, ?)`
+
+	selectTransferForUpdateSQL = `SELECT id, affiliate_id, currency, amount, kind, COALESCE(original_id, 0) AS original_id, status, version
+FROM direct_transfer
+WHERE id = ?
+FOR UPDATE`
+
+	selectRefundedSQL = `SELECT COALESCE(SUM(amount), 0) FROM direct_transfer WHERE original_id = ? AND kind IN ('refund', 'reversal')`
+
+	insertTransferLogSQL = `INSERT INTO direct_transfer_log (direct_transfer_id, action, note, created_by, created_at) VALUES (?, ?, ?, ?, ?)`
+)
+
+// transferWriter creates transfers and changes their status in the same
+// transaction as the ledger entries that book them, so the ledger never
+// disagrees with direct_transfer.
+type transferWriter struct {
+	begin beginFunc
+	post  func(ctx context.Context, db ledgerimpl.DB, e *ledgerimpl.JournalEntry) error
+	now   func() time.Time
+}
+
+func newTransferWriter[T sqlTx](db txBeginner[T]) *transferWriter {
+	return &transferWriter{begin: newBeginFunc(db), post: ledgerimpl.PostEntry, now: time.Now}
+}
+
+// create checks the transfer limits, then inserts a pending transfer,
+// books it on the ledger and logs it. The limit rows stay locked until
+// commit, so two creates cannot both pass a limit only one of them fits.
+func (w *transferWriter) create(ctx context.Context, c transferCreate) (int64, error) {
+	var id int64
+	err := w.begin.inTx(ctx, func(tx dbConn) error {
+		now := w.now()
+
+		if err := checkTransferLimits(ctx, sqlLimitSource{tx: tx}, c.AffiliateID, c.Currency, c.Amount, now); err != nil {
+			return err
+		}
+
+		row := transferRow{
+			AffiliateID: c.AffiliateID, Currency: c.Currency, Amount: c.Amount,
+			Kind: transferKindTransfer, Status: journalStatusPending,
+		}
+		var err error
+		if id, err = insertTransfer(ctx, tx, &row, c.TransactionID, c.CreatedBy, now); err != nil {
+			return err
+		}
+
+		entry, err := createJournalEntry(row.journal(), now)
+		if err != nil {
+			return err
+		}
+		if err := w.post(ctx, tx, entry); err != nil {
+			return err
+		}
+
+		return insertTransferLog(ctx, tx, transferLogEntry{
+			TransferID: id, Action: string(journalStatusPending), CreatedBy: c.CreatedBy, CreatedAt: now,
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// updateStatus moves a pending transfer to u.Status, books the move on the
+// ledger and logs it. The status is written with updateStatusCAS, so the
+// move fails with ErrConcurrentModification when the transfer changed
+// after the caller read u.Version.
+func (w *transferWriter) updateStatus(ctx context.Context, u transferStatusUpdate) error {
+	return w.begin.inTx(ctx, func(tx dbConn) error {
+		row, err := lockTransfer(ctx, tx, u.ID)
+		if err != nil {
+			return err
+		}
+
+		entry, err := statusJournalEntry(row.journal(), row.Status, u.Status, u.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		if _, err := updateStatusCAS(ctx, tx, u); err != nil {
+			return err
+		}
+		if err := w.post(ctx, tx, entry); err != nil {
+			return err
+		}
+
+		return insertTransferLog(ctx, tx, transferLogEntry{
+			TransferID: u.ID, Action: string(u.Status), CreatedBy: u.UpdatedBy, CreatedAt: u.UpdatedAt,
+		})
+	})
+}
+
+// transferRefund refunds part of a completed transfer, or reverses what is
+// left of it when Kind is transferKindReversal; Amount is then ignored.
+type transferRefund struct {
+	OriginalID int64
+	Amount     int64
+	Kind       transferKind
+	Reason     string
+	CreatedBy  int64
+}
+
+// refund creates the compensating transfer of r, books it on the ledger and
+// logs it on both transfers. The original row stays locked from reading
+// what was already refunded until commit, so concurrent refunds cannot
+// together exceed the original amount.
+func (w *transferWriter) refund(ctx context.Context, r transferRefund) (int64, error) {
+	var id int64
+	err := w.begin.inTx(ctx, func(tx dbConn) error {
+		now := w.now()
+
+		row, err := lockTransfer(ctx, tx, r.OriginalID)
+		if err != nil {
+			return err
+		}
+		if row.Kind != transferKindTransfer {
+			return fmt.Errorf("%w: transfer %d is a %s", ErrNotRefundable, row.ID, row.Kind)
+		}
+
+		original := refundOriginal{ID: row.ID, AffiliateID: row.AffiliateID, Currency: row.Currency, Amount: row.Amount, Status: row.Status}
+		if err := tx.GetContext(ctx, &original.Refunded, selectRefundedSQL, row.ID); err != nil {
+			return err
+		}
+
+		var plan *refundPlan
+		if r.Kind == transferKindReversal {
+			plan, err = planReversal(original, r.CreatedBy, r.Reason, now)
+		} else {
+			plan, err = planRefund(original, r.Amount, r.CreatedBy, r.Reason, now)
+		}
+		if err != nil {
+			return err
+		}
+
+		compensating := transferRow{
+			AffiliateID: plan.Transfer.AffiliateID, Currency: plan.Transfer.Currency, Amount: plan.Transfer.Amount,
+			Kind: plan.Transfer.Kind, OriginalID: plan.Transfer.OriginalID, Status: journalStatusCompleted,
+		}
+		if id, err = insertTransfer(ctx, tx, &compensating, "", r.CreatedBy, now); err != nil {
+			return err
+		}
+		plan.linkCreated(id)
+
+		entry, err := refundJournalEntry(compensating.journal(), compensating.Kind, now)
+		if err != nil {
+			return err
+		}
+		if err := w.post(ctx, tx, entry); err != nil {
+			return err
+		}
+
+		if err := insertTransferLog(ctx, tx, plan.OriginalLog); err != nil {
+			return err
+		}
+		return insertTransferLog(ctx, tx, plan.CompensatingLog)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// insertTransfer inserts row and sets its ID.
+func insertTransfer(ctx context.Context, tx dbConn, row *transferRow, transactionID string, createdBy int64, now time.Time) (int64, error) {
+	result, err := tx.ExecContext(ctx, insertTransferSQL,
+		row.AffiliateID, row.Currency, row.Amount, row.Kind, row.OriginalID, row.Status, transactionID, createdBy, now)
+	if err != nil {
+		return 0, err
+	}
+	if row.ID, err = result.LastInsertId(); err != nil {
+		return 0, err
+	}
+
+	return row.ID, nil
+}
+
+func insertTransferLog(ctx context.Context, tx dbConn, l transferLogEntry) error {
+	_, err := tx.ExecContext(ctx, insertTransferLogSQL, l.TransferID, l.Action, l.Note, l.CreatedBy, l.CreatedAt)
+	return err
+}
+
+// lockTransfer reads a transfer with FOR UPDATE, so the caller's checks
+// hold until its transaction ends.
+func lockTransfer(ctx context.Context, tx dbConn, id int64) (*transferRow, error) {
+	var row transferRow
+	err := tx.GetContext(ctx, &row, selectTransferForUpdateSQL, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrTransferNotFound, id)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &row, nil
+}
@@ -0,0 +1,472 @@
+package directtransferimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"main/pkg/affiliate/ledger/ledgerimpl"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeTransferLog struct {
+	TransferID int64
+	Action     string
+	Note       string
+}
+
+// fakeTransferState is what a fakeTransferTx works on; it is copied into
+// the fakeTransferDB only on commit. entries are the ledger entries posted
+// with the transaction.
+type fakeTransferState struct {
+	transfers map[int64]transferRow
+	logs      []fakeTransferLog
+	entries   []*ledgerimpl.JournalEntry
+}
+
+func (s fakeTransferState) clone() fakeTransferState {
+	return fakeTransferState{
+		transfers: maps.Clone(s.transfers),
+		logs:      slices.Clone(s.logs),
+		entries:   slices.Clone(s.entries),
+	}
+}
+
+// fakeTransferDB runs the transfer write queries against in-memory rows.
+// failOn makes the query starting with that text fail; "post" fails the
+// ledger post.
+type fakeTransferDB struct {
+	fakeTransferState
+	limits []transferLimit
+	failOn string
+}
+
+func newFakeTransferDB(transfers ...transferRow) *fakeTransferDB {
+	db := &fakeTransferDB{fakeTransferState: fakeTransferState{transfers: map[int64]transferRow{}}}
+	for _, t := range transfers {
+		db.transfers[t.ID] = t
+	}
+	return db
+}
+
+func (db *fakeTransferDB) BeginTxx(context.Context, *sql.TxOptions) (*fakeTransferTx, error) {
+	return &fakeTransferTx{db: db, fakeTransferState: db.clone()}, nil
+}
+
+// writer returns a transferWriter on db whose ledger posts are recorded in
+// the transaction.
+func (db *fakeTransferDB) writer(now time.Time) *transferWriter {
+	w := newTransferWriter(db)
+	w.now = func() time.Time { return now }
+	w.post = func(_ context.Context, conn ledgerimpl.DB, e *ledgerimpl.JournalEntry) error {
+		if db.failOn == "post" {
+			return fmt.Errorf("error")
+		}
+		tx := conn.(*fakeTransferTx)
+		tx.entries = append(tx.entries, e)
+		return nil
+	}
+	return w
+}
+
+type fakeTransferTx struct {
+	fakeTransferState
+	db *fakeTransferDB
+}
+
+func (tx *fakeTransferTx) Commit() error {
+	tx.db.fakeTransferState = tx.fakeTransferState
+	return nil
+}
+
+func (tx *fakeTransferTx) Rollback() error { return nil }
+
+func (tx *fakeTransferTx) fail(query string) error {
+	if tx.db.failOn != "" && strings.HasPrefix(query, tx.db.failOn) {
+		return fmt.Errorf("error")
+	}
+	return nil
+}
+
+func (tx *fakeTransferTx) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	if err := tx.fail(query); err != nil {
+		return nil, err
+	}
+
+	switch query {
+	case insertTransferSQL:
+		id := int64(1)
+		for existing := range tx.transfers {
+			id = max(id, existing+1)
+		}
+		tx.transfers[id] = transferRow{
+			ID: id, AffiliateID: args[0].(int64), Currency: args[1].(string), Amount: args[2].(int64),
+			Kind: args[3].(transferKind), OriginalID: args[4].(int64), Status: args[5].(journalStatus), Version: 1,
+		}
+		return fakeResult{lastInsertID: id, rowsAffected: 1}, nil
+	case updateTransferStatusSQL:
+		row, ok := tx.transfers[args[3].(int64)]
+		if !ok || row.Version != args[4].(int64) {
+			return fakeResult{}, nil
+		}
+		row.Status = args[0].(journalStatus)
+		row.Version++
+		tx.transfers[row.ID] = row
+	case insertTransferLogSQL:
+		tx.logs = append(tx.logs, fakeTransferLog{TransferID: args[0].(int64), Action: args[1].(string), Note: args[2].(string)})
+	default:
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+
+	return fakeResult{rowsAffected: 1}, nil
+}
+
+func (tx *fakeTransferTx) GetContext(_ context.Context, dest any, query string, args ...any) error {
+	if err := tx.fail(query); err != nil {
+		return err
+	}
+
+	switch query {
+	case selectTransferForUpdateSQL:
+		row, ok := tx.transfers[args[0].(int64)]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		*dest.(*transferRow) = row
+	case selectTransferVersionSQL:
+		row, ok := tx.transfers[args[0].(int64)]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		*dest.(*int64) = row.Version
+	case selectTransferUsageSQL:
+		// Every stored transfer counts as created within the hour.
+		var usage transferUsage
+		for _, row := range tx.transfers {
+			if (args[3].(int64) == 0 || row.AffiliateID == args[3].(int64)) && row.Currency == args[5].(string) &&
+				row.Kind == transferKindTransfer && row.Status != journalStatusRejected {
+				usage.HourlyCount++
+				usage.DailyTotal += row.Amount
+				usage.MonthlyTotal += row.Amount
+			}
+		}
+		*dest.(*transferUsage) = usage
+	case selectRefundedSQL:
+		var refunded int64
+		for _, row := range tx.transfers {
+			if row.OriginalID == args[0].(int64) {
+				refunded += row.Amount
+			}
+		}
+		*dest.(*int64) = refunded
+	default:
+		return fmt.Errorf("unexpected query %q", query)
+	}
+
+	return nil
+}
+
+func (tx *fakeTransferTx) SelectContext(_ context.Context, dest any, query string, args ...any) error {
+	if err := tx.fail(query); err != nil {
+		return err
+	}
+
+	switch query {
+	case selectTransferLimitsSQL:
+		for _, limit := range tx.db.limits {
+			if limit.AffiliateID == 0 || limit.AffiliateID == args[0].(int64) {
+				*dest.(*[]transferLimit) = append(*dest.(*[]transferLimit), limit)
+			}
+		}
+	default:
+		return fmt.Errorf("unexpected query %q", query)
+	}
+
+	return nil
+}
+
+func TestTransferWriterCreate(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		failOn        string
+		limits        []transferLimit
+		expectedError error
+		expectedID    int64
+	}{
+		{
+			name:       "create transfer success",
+			expectedID: 1,
+		},
+		{
+			name:          "create transfer error - ledger post rolls back",
+			failOn:        "post",
+			expectedError: fmt.Errorf("error"),
+		},
+		{
+			name:          "create transfer error - log rolls back",
+			failOn:        "INSERT INTO direct_transfer_log",
+			expectedError: fmt.Errorf("error"),
+		},
+		{
+			name:          "create transfer error - limit exceeded",
+			limits:        []transferLimit{{AffiliateID: 7, SingleTransferMax: 5000}},
+			expectedError: &LimitExceededError{Rule: LimitRuleSingleTransferMax, AffiliateID: 7, Currency: "USD", Limit: 5000, Actual: 10000},
+		},
+		{
+			name:          "create transfer error - limits cannot be read",
+			failOn:        "SELECT affiliate_id, currency, single_transfer_max",
+			expectedError: fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeTransferDB()
+			db.failOn, db.limits = tc.failOn, tc.limits
+
+			id, err := db.writer(now).create(context.Background(), transferCreate{AffiliateID: 7, Currency: "USD", Amount: 10000, CreatedBy: 2})
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+			if id != tc.expectedID {
+				t.Fatalf("expected id %d, but got %d", tc.expectedID, id)
+			}
+
+			var exceeded *LimitExceededError
+			if want, ok := tc.expectedError.(*LimitExceededError); ok && (!errors.As(err, &exceeded) || *exceeded != *want) {
+				t.Fatalf("expected error %q, but got %q", tc.expectedError, err)
+			}
+			if tc.expectedError != nil {
+				if len(db.transfers) != 0 || len(db.entries) != 0 || len(db.logs) != 0 {
+					t.Fatalf("expected nothing to be written, but got %+v", db.fakeTransferState)
+				}
+				return
+			}
+
+			if db.transfers[1].Status != journalStatusPending {
+				t.Fatalf("expected a pending transfer, but got %+v", db.transfers[1])
+			}
+			pending := ledgerimpl.AccountKey{AffiliateID: 7, Currency: "USD", Type: ledgerimpl.AccountAffiliatePending}
+			if len(db.entries) != 1 || db.entries[0].Reference != "transfer:1:pending" || ledgerimpl.Balance(db.entries, pending) != -10000 {
+				t.Fatalf("expected the transfer to be booked, but got %+v", db.entries)
+			}
+			if want := []fakeTransferLog{{TransferID: 1, Action: "pending"}}; !reflect.DeepEqual(db.logs, want) {
+				t.Fatalf("expected logs %v, but got %v", want, db.logs)
+			}
+		})
+	}
+}
+
+func TestTransferWriterCreateDailyLimit(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	db := newFakeTransferDB(
+		transferRow{ID: 1, AffiliateID: 7, Currency: "USD", Amount: 6000, Kind: transferKindTransfer, Status: journalStatusCompleted},
+		transferRow{ID: 2, AffiliateID: 7, Currency: "USD", Amount: 9000, Kind: transferKindTransfer, Status: journalStatusRejected},
+	)
+	db.limits = []transferLimit{{DailyTotal: 10000}}
+	w := db.writer(now)
+
+	if _, err := w.create(context.Background(), transferCreate{AffiliateID: 7, Currency: "USD", Amount: 4000}); err != nil {
+		t.Fatalf("expected the rejected transfer not to count, but got %q", err)
+	}
+
+	_, err := w.create(context.Background(), transferCreate{AffiliateID: 8, Currency: "USD", Amount: 1})
+	var exceeded *LimitExceededError
+	if !errors.As(err, &exceeded) || exceeded.Rule != LimitRuleDailyTotal || exceeded.Actual != 10001 {
+		t.Fatalf("expected the global daily total to be exceeded, but got %v", err)
+	}
+	if len(db.transfers) != 3 {
+		t.Fatalf("expected %d transfers, but got %d", 3, len(db.transfers))
+	}
+}
+
+func TestTransferWriterUpdateStatus(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	pending := transferRow{ID: 1, AffiliateID: 7, Currency: "USD", Amount: 10000, Status: journalStatusPending, Version: 1}
+
+	testCases := []struct {
+		name           string
+		update         transferStatusUpdate
+		failOn         string
+		expectedError  error
+		expectedStatus journalStatus
+	}{
+		{
+			name:           "update status success - completed",
+			update:         transferStatusUpdate{ID: 1, Version: 1, Status: "completed"},
+			expectedStatus: journalStatusCompleted,
+		},
+		{
+			name:           "update status success - rejected",
+			update:         transferStatusUpdate{ID: 1, Version: 1, Status: "rejected"},
+			expectedStatus: journalStatusRejected,
+		},
+		{
+			name:           "update status error - not found",
+			update:         transferStatusUpdate{ID: 2, Version: 1, Status: "completed"},
+			expectedError:  ErrTransferNotFound,
+			expectedStatus: journalStatusPending,
+		},
+		{
+			name:           "update status error - invalid transition",
+			update:         transferStatusUpdate{ID: 1, Version: 1, Status: "pending"},
+			expectedError:  ErrInvalidStatusTransition,
+			expectedStatus: journalStatusPending,
+		},
+		{
+			name:           "update status error - concurrent modification",
+			update:         transferStatusUpdate{ID: 1, Version: 0, Status: "rejected"},
+			expectedError:  ErrConcurrentModification,
+			expectedStatus: journalStatusPending,
+		},
+		{
+			name:           "update status error - ledger post rolls back",
+			update:         transferStatusUpdate{ID: 1, Version: 1, Status: "completed"},
+			failOn:         "post",
+			expectedError:  fmt.Errorf("error"),
+			expectedStatus: journalStatusPending,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeTransferDB(pending)
+			db.failOn = tc.failOn
+			tc.update.UpdatedBy, tc.update.UpdatedAt = 2, now
+
+			err := db.writer(now).updateStatus(context.Background(), tc.update)
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+			if errors.Is(tc.expectedError, ErrTransferNotFound) || errors.Is(tc.expectedError, ErrInvalidStatusTransition) ||
+				errors.Is(tc.expectedError, ErrConcurrentModification) {
+				if !errors.Is(err, tc.expectedError) {
+					t.Fatalf("expected error %q, but got %q", tc.expectedError, err)
+				}
+			}
+
+			if got := db.transfers[1].Status; got != tc.expectedStatus {
+				t.Fatalf("expected status %s, but got %s", tc.expectedStatus, got)
+			}
+			if tc.expectedError != nil {
+				if len(db.entries) != 0 || len(db.logs) != 0 {
+					t.Fatalf("expected nothing to be written, but got %+v", db.fakeTransferState)
+				}
+				return
+			}
+			if len(db.entries) != 1 || db.entries[0].Reference != fmt.Sprintf("transfer:1:%s", tc.expectedStatus) {
+				t.Fatalf("expected the status change to be booked, but got %+v", db.entries)
+			}
+			if want := []fakeTransferLog{{TransferID: 1, Action: string(tc.expectedStatus)}}; !reflect.DeepEqual(db.logs, want) {
+				t.Fatalf("expected logs %v, but got %v", want, db.logs)
+			}
+		})
+	}
+}
+
+func TestTransferWriterRefund(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	completed := transferRow{ID: 1, AffiliateID: 7, Currency: "USD", Amount: 10000, Kind: transferKindTransfer, Status: journalStatusCompleted, Version: 2}
+	refunded := transferRow{ID: 2, AffiliateID: 7, Currency: "USD", Amount: 4000, Kind: transferKindRefund, OriginalID: 1, Status: journalStatusCompleted, Version: 1}
+
+	testCases := []struct {
+		name           string
+		transfers      []transferRow
+		refund         transferRefund
+		failOn         string
+		expectedError  error
+		expectedAmount int64
+	}{
+		{
+			name:           "refund transfer success",
+			transfers:      []transferRow{completed},
+			refund:         transferRefund{OriginalID: 1, Amount: 4000, Kind: transferKindRefund},
+			expectedAmount: 4000,
+		},
+		{
+			name:           "refund transfer success - reversal of the rest",
+			transfers:      []transferRow{completed, refunded},
+			refund:         transferRefund{OriginalID: 1, Kind: transferKindReversal},
+			expectedAmount: 6000,
+		},
+		{
+			name:          "refund transfer error - exceeds what is left",
+			transfers:     []transferRow{completed, refunded},
+			refund:        transferRefund{OriginalID: 1, Amount: 6001, Kind: transferKindRefund},
+			expectedError: ErrRefundExceedsOriginal,
+		},
+		{
+			name:          "refund transfer error - refund of a refund",
+			transfers:     []transferRow{completed, refunded},
+			refund:        transferRefund{OriginalID: 2, Amount: 100, Kind: transferKindRefund},
+			expectedError: ErrNotRefundable,
+		},
+		{
+			name:          "refund transfer error - not found",
+			transfers:     []transferRow{completed},
+			refund:        transferRefund{OriginalID: 3, Amount: 100, Kind: transferKindRefund},
+			expectedError: ErrTransferNotFound,
+		},
+		{
+			name:          "refund transfer error - ledger post rolls back",
+			transfers:     []transferRow{completed},
+			refund:        transferRefund{OriginalID: 1, Amount: 4000, Kind: transferKindRefund},
+			failOn:        "post",
+			expectedError: fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeTransferDB(tc.transfers...)
+			db.failOn = tc.failOn
+			tc.refund.Reason, tc.refund.CreatedBy = "chargeback", 2
+
+			id, err := db.writer(now).refund(context.Background(), tc.refund)
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+			if tc.expectedError != nil {
+				if !errors.Is(err, tc.expectedError) && tc.failOn == "" {
+					t.Fatalf("expected error %q, but got %q", tc.expectedError, err)
+				}
+				if len(db.transfers) != len(tc.transfers) || len(db.entries) != 0 || len(db.logs) != 0 {
+					t.Fatalf("expected nothing to be written, but got %+v", db.fakeTransferState)
+				}
+				return
+			}
+
+			row := db.transfers[id]
+			if row.Amount != tc.expectedAmount || row.Kind != tc.refund.Kind || row.OriginalID != 1 || row.Status != journalStatusCompleted {
+				t.Fatalf("expected a completed %s of %d linked to transfer 1, but got %+v", tc.refund.Kind, tc.expectedAmount, row)
+			}
+
+			payable := ledgerimpl.AccountKey{AffiliateID: 7, Currency: "USD", Type: ledgerimpl.AccountAffiliatePayable}
+			if len(db.entries) != 1 || db.entries[0].Reference != fmt.Sprintf("transfer:%d:%s", id, tc.refund.Kind) ||
+				ledgerimpl.Balance(db.entries, payable) != tc.expectedAmount {
+				t.Fatalf("expected the %s to be booked against the payable account, but got %+v", tc.refund.Kind, db.entries)
+			}
+
+			want := []fakeTransferLog{
+				{TransferID: 1, Action: string(tc.refund.Kind), Note: fmt.Sprintf("%s %d by transfer %d", tc.refund.Kind, tc.expectedAmount, id)},
+				{TransferID: id, Action: string(tc.refund.Kind), Note: fmt.Sprintf("%s of transfer 1: chargeback", tc.refund.Kind)},
+			}
+			if !reflect.DeepEqual(db.logs, want) {
+				t.Fatalf("expected logs %v, but got %v", want, db.logs)
+			}
+		})
+	}
+}
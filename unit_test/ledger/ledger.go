@@ -0,0 +1,161 @@
+package ledgerimpl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrEmptyEntry      = errors.New("journal entry needs at least two postings")
+	ErrInvalidPosting  = errors.New("posting needs exactly one positive debit or credit")
+	ErrUnbalancedEntry = errors.New("journal entry debits and credits do not balance")
+)
+
+type AccountType string
+
+const (
+	AccountOperatorClearing AccountType = "operator_clearing"
+	AccountAffiliatePending AccountType = "affiliate_pending"
+	AccountAffiliatePayable AccountType = "affiliate_payable"
+)
+
+// AccountKey identifies a ledger account. Affiliate accounts are kept per
+// affiliate and currency; operator accounts use AffiliateID 0.
+type AccountKey struct {
+	AffiliateID int64       `db:"affiliate_id" json:"affiliateId"`
+	Currency    string      `db:"currency" json:"currency"`
+	Type        AccountType `db:"type" json:"type"`
+}
+
+// Posting moves an amount, in minor units, on one account.
+type Posting struct {
+	Account AccountKey `json:"account"`
+	Debit   int64      `db:"debit" json:"debit"`
+	Credit  int64      `db:"credit" json:"credit"`
+}
+
+type JournalEntry struct {
+	ID        int64     `db:"id" json:"id"`
+	Reference string    `db:"reference" json:"reference"`
+	Postings  []Posting `json:"postings"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+// Imbalance reports a currency whose debits and credits differ. EntryID is
+// 0 when the imbalance is across the whole ledger rather than one entry.
+type Imbalance struct {
+	EntryID  int64  `db:"entry_id" json:"entryId"`
+	Currency string `db:"currency" json:"currency"`
+	Debit    int64  `db:"debit" json:"debit"`
+	Credit   int64  `db:"credit" json:"credit"`
+}
+
+// Validate checks that every posting is one-sided and that debits equal
+// credits for each currency in the entry.
+func (e *JournalEntry) Validate() error {
+	if len(e.Postings) < 2 {
+		return ErrEmptyEntry
+	}
+
+	for _, p := range e.Postings {
+		if p.Debit < 0 || p.Credit < 0 || (p.Debit > 0) == (p.Credit > 0) {
+			return fmt.Errorf("%w: %+v", ErrInvalidPosting, p)
+		}
+	}
+
+	if imbalances := entryImbalances(e); len(imbalances) > 0 {
+		i := imbalances[0]
+		return fmt.Errorf("%w: %s debit %d credit %d", ErrUnbalancedEntry, i.Currency, i.Debit, i.Credit)
+	}
+
+	return nil
+}
+
+// Balances returns debits minus credits for every account touched by the
+// entries.
+func Balances(entries []*JournalEntry) map[AccountKey]int64 {
+	balances := make(map[AccountKey]int64)
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			balances[p.Account] += p.Debit - p.Credit
+		}
+	}
+
+	return balances
+}
+
+// Balance returns debits minus credits for a single account.
+func Balance(entries []*JournalEntry, account AccountKey) int64 {
+	var balance int64
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			if p.Account == account {
+				balance += p.Debit - p.Credit
+			}
+		}
+	}
+
+	return balance
+}
+
+// CheckInvariants reports every entry that does not balance per currency,
+// followed by any currency whose ledger-wide totals do not balance.
+func CheckInvariants(entries []*JournalEntry) []Imbalance {
+	imbalances := make([]Imbalance, 0)
+	totals := make(map[string]*Imbalance)
+
+	for _, e := range entries {
+		imbalances = append(imbalances, entryImbalances(e)...)
+
+		for _, p := range e.Postings {
+			total, ok := totals[p.Account.Currency]
+			if !ok {
+				total = &Imbalance{Currency: p.Account.Currency}
+				totals[p.Account.Currency] = total
+			}
+			total.Debit += p.Debit
+			total.Credit += p.Credit
+		}
+	}
+
+	currencies := make([]string, 0, len(totals))
+	for currency := range totals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	for _, currency := range currencies {
+		if total := totals[currency]; total.Debit != total.Credit {
+			imbalances = append(imbalances, *total)
+		}
+	}
+
+	return imbalances
+}
+
+func entryImbalances(e *JournalEntry) []Imbalance {
+	sums := make(map[string]*Imbalance)
+	currencies := make([]string, 0)
+
+	for _, p := range e.Postings {
+		sum, ok := sums[p.Account.Currency]
+		if !ok {
+			sum = &Imbalance{EntryID: e.ID, Currency: p.Account.Currency}
+			sums[p.Account.Currency] = sum
+			currencies = append(currencies, p.Account.Currency)
+		}
+		sum.Debit += p.Debit
+		sum.Credit += p.Credit
+	}
+
+	imbalances := make([]Imbalance, 0)
+	for _, currency := range currencies {
+		if sum := sums[currency]; sum.Debit != sum.Credit {
+			imbalances = append(imbalances, *sum)
+		}
+	}
+
+	return imbalances
+}
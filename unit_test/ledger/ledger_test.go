@@ -0,0 +1,143 @@
+package ledgerimpl
+
+import (
+	"errors"
+	"testing"
+)
+
+var (
+	operatorUSD = AccountKey{Currency: "USD", Type: AccountOperatorClearing}
+	pendingUSD  = AccountKey{AffiliateID: 1, Currency: "USD", Type: AccountAffiliatePending}
+	pendingEUR  = AccountKey{AffiliateID: 1, Currency: "EUR", Type: AccountAffiliatePending}
+	operatorEUR = AccountKey{Currency: "EUR", Type: AccountOperatorClearing}
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		entry         *JournalEntry
+		expectedError error
+	}{
+		{
+			name: "validate success",
+			entry: &JournalEntry{Postings: []Posting{
+				{Account: operatorUSD, Debit: 100},
+				{Account: pendingUSD, Credit: 100},
+			}},
+			expectedError: nil,
+		},
+		{
+			name: "validate success - two currencies",
+			entry: &JournalEntry{Postings: []Posting{
+				{Account: operatorUSD, Debit: 100},
+				{Account: pendingUSD, Credit: 100},
+				{Account: operatorEUR, Debit: 90},
+				{Account: pendingEUR, Credit: 90},
+			}},
+			expectedError: nil,
+		},
+		{
+			name: "validate error - unbalanced",
+			entry: &JournalEntry{Postings: []Posting{
+				{Account: operatorUSD, Debit: 100},
+				{Account: pendingUSD, Credit: 90},
+			}},
+			expectedError: ErrUnbalancedEntry,
+		},
+		{
+			name: "validate error - balanced across currencies only",
+			entry: &JournalEntry{Postings: []Posting{
+				{Account: operatorUSD, Debit: 100},
+				{Account: pendingEUR, Credit: 100},
+			}},
+			expectedError: ErrUnbalancedEntry,
+		},
+		{
+			name: "validate error - single posting",
+			entry: &JournalEntry{Postings: []Posting{
+				{Account: operatorUSD, Debit: 100},
+			}},
+			expectedError: ErrEmptyEntry,
+		},
+		{
+			name: "validate error - two sided posting",
+			entry: &JournalEntry{Postings: []Posting{
+				{Account: operatorUSD, Debit: 100, Credit: 100},
+				{Account: pendingUSD, Credit: 100},
+			}},
+			expectedError: ErrInvalidPosting,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.entry.Validate()
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("expected %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestBalances(t *testing.T) {
+	entries := []*JournalEntry{
+		{ID: 1, Postings: []Posting{{Account: operatorUSD, Debit: 100}, {Account: pendingUSD, Credit: 100}}},
+		{ID: 2, Postings: []Posting{{Account: operatorUSD, Debit: 50}, {Account: pendingUSD, Credit: 50}}},
+		{ID: 3, Postings: []Posting{{Account: pendingUSD, Debit: 30}, {Account: operatorUSD, Credit: 30}}},
+	}
+
+	balances := Balances(entries)
+	if balances[pendingUSD] != -120 {
+		t.Errorf("expected %v, got %v", -120, balances[pendingUSD])
+	}
+	if balances[operatorUSD] != 120 {
+		t.Errorf("expected %v, got %v", 120, balances[operatorUSD])
+	}
+	if got := Balance(entries, pendingUSD); got != -120 {
+		t.Errorf("expected %v, got %v", -120, got)
+	}
+	if got := Balance(entries, pendingEUR); got != 0 {
+		t.Errorf("expected %v, got %v", 0, got)
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	testCases := []struct {
+		name           string
+		entries        []*JournalEntry
+		expectedResult []Imbalance
+	}{
+		{
+			name: "check invariants success",
+			entries: []*JournalEntry{
+				{ID: 1, Postings: []Posting{{Account: operatorUSD, Debit: 100}, {Account: pendingUSD, Credit: 100}}},
+			},
+			expectedResult: []Imbalance{},
+		},
+		{
+			name: "check invariants error - unbalanced entry",
+			entries: []*JournalEntry{
+				{ID: 1, Postings: []Posting{{Account: operatorUSD, Debit: 100}, {Account: pendingUSD, Credit: 100}}},
+				{ID: 2, Postings: []Posting{{Account: operatorUSD, Debit: 100}, {Account: pendingUSD, Credit: 90}}},
+			},
+			expectedResult: []Imbalance{
+				{EntryID: 2, Currency: "USD", Debit: 100, Credit: 90},
+				{Currency: "USD", Debit: 200, Credit: 190},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := CheckInvariants(tc.entries)
+			if len(result) != len(tc.expectedResult) {
+				t.Fatalf("expected %v, got %v", tc.expectedResult, result)
+			}
+			for i := range result {
+				if result[i] != tc.expectedResult[i] {
+					t.Errorf("expected %v, got %v", tc.expectedResult[i], result[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package ledgerimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// DB is the part of *sqlx.DB and *sqlx.Tx the ledger uses. Entries are
+// usually posted with the *sqlx.Tx that writes the business change they
+// book.
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+// Schema creates the ledger tables. Accounts carry their running balance,
+// which PostEntry keeps in step with the postings.
+const Schema = `CREATE TABLE IF NOT EXISTS ledger_account (
+	id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	affiliate_id BIGINT NOT NULL,
+	currency CHAR(3) NOT NULL,
+	type VARCHAR(32) NOT NULL,
+	balance BIGINT NOT NULL DEFAULT 0,
+	UNIQUE KEY uq_ledger_account (affiliate_id, currency, type)
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entry (
+	id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	reference VARCHAR(128) NOT NULL,
+	created_at DATETIME NOT NULL,
+	UNIQUE KEY uq_ledger_entry_reference (reference)
+);
+
+CREATE TABLE IF NOT EXISTS ledger_posting (
+	id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	entry_id BIGINT NOT NULL,
+	account_id BIGINT NOT NULL,
+	debit BIGINT NOT NULL DEFAULT 0,
+	credit BIGINT NOT NULL DEFAULT 0,
+	KEY idx_ledger_posting_entry (entry_id),
+	KEY idx_ledger_posting_account (account_id),
+	FOREIGN KEY (entry_id) REFERENCES ledger_entry (id),
+	FOREIGN KEY (account_id) REFERENCES ledger_account (id)
+);`
+
+const (
+	insertEntrySQL = `INSERT INTO ledger_entry (reference, created_at) VALUES (?, ?)`
+
+	// LAST_INSERT_ID(id) makes an existing account report its own ID.
+	upsertAccountSQL = `INSERT INTO ledger_account (affiliate_id, currency, type, balance) VALUES (?, ?, ?, 0)
+ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`
+
+	insertPostingSQL = `INSERT INTO ledger_posting (entry_id, account_id, debit, credit) VALUES (?, ?, ?, ?)`
+
+	updateAccountBalanceSQL = `UPDATE ledger_account SET balance = balance + ? WHERE id = ?`
+
+	selectAccountBalanceSQL = `SELECT balance FROM ledger_account WHERE affiliate_id = ? AND currency = ? AND type = ?`
+
+	selectEntryImbalancesSQL = `SELECT p.entry_id, a.currency, SUM(p.debit) AS debit, SUM(p.credit) AS credit
+FROM ledger_posting p
+JOIN ledger_account a ON a.id = p.account_id
+GROUP BY p.entry_id, a.currency
+HAVING SUM(p.debit) <> SUM(p.credit)
+ORDER BY p.entry_id, a.currency`
+
+	selectCurrencyImbalancesSQL = `SELECT 0 AS entry_id, a.currency, SUM(p.debit) AS debit, SUM(p.credit) AS credit
+FROM ledger_posting p
+JOIN ledger_account a ON a.id = p.account_id
+GROUP BY a.currency
+HAVING SUM(p.debit) <> SUM(p.credit)
+ORDER BY a.currency`
+
+	selectAccountDriftSQL = `SELECT a.affiliate_id, a.currency, a.type, a.balance, COALESCE(SUM(p.debit - p.credit), 0) AS posted
+FROM ledger_account a
+LEFT JOIN ledger_posting p ON p.account_id = a.id
+GROUP BY a.id, a.affiliate_id, a.currency, a.type, a.balance
+HAVING a.balance <> posted
+ORDER BY a.affiliate_id, a.currency, a.type`
+)
+
+// AccountDrift reports an account whose stored balance differs from the sum
+// of its postings.
+type AccountDrift struct {
+	AccountKey
+	Balance int64 `db:"balance" json:"balance"`
+	Posted  int64 `db:"posted" json:"posted"`
+}
+
+// PostEntry validates e and stores it with its postings, creating accounts
+// on first use and updating their balances. e.ID is set on success. The
+// reference is unique, so a retried post fails instead of booking twice.
+func PostEntry(ctx context.Context, db DB, e *JournalEntry) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, insertEntrySQL, e.Reference, e.CreatedAt)
+	if err != nil {
+		return err
+	}
+	entryID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range e.Postings {
+		result, err := db.ExecContext(ctx, upsertAccountSQL, p.Account.AffiliateID, p.Account.Currency, p.Account.Type)
+		if err != nil {
+			return err
+		}
+		accountID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		if _, err := db.ExecContext(ctx, insertPostingSQL, entryID, accountID, p.Debit, p.Credit); err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, updateAccountBalanceSQL, p.Debit-p.Credit, accountID); err != nil {
+			return err
+		}
+	}
+
+	e.ID = entryID
+
+	return nil
+}
+
+// AccountBalance returns the stored debits minus credits of an account, 0
+// for an account that has no postings yet.
+func AccountBalance(ctx context.Context, db DB, account AccountKey) (int64, error) {
+	var balance int64
+	err := db.GetContext(ctx, &balance, selectAccountBalanceSQL, account.AffiliateID, account.Currency, account.Type)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	return balance, err
+}
+
+// CheckStoredInvariants is CheckInvariants over the stored ledger: every
+// entry that does not balance per currency, followed by any currency whose
+// ledger-wide totals do not balance.
+func CheckStoredInvariants(ctx context.Context, db DB) ([]Imbalance, error) {
+	imbalances := make([]Imbalance, 0)
+	if err := db.SelectContext(ctx, &imbalances, selectEntryImbalancesSQL); err != nil {
+		return nil, err
+	}
+
+	totals := make([]Imbalance, 0)
+	if err := db.SelectContext(ctx, &totals, selectCurrencyImbalancesSQL); err != nil {
+		return nil, err
+	}
+
+	return append(imbalances, totals...), nil
+}
+
+// CheckAccountBalances reports accounts whose stored balance no longer
+// matches their postings.
+func CheckAccountBalances(ctx context.Context, db DB) ([]AccountDrift, error) {
+	drift := make([]AccountDrift, 0)
+	if err := db.SelectContext(ctx, &drift, selectAccountDriftSQL); err != nil {
+		return nil, err
+	}
+
+	return drift, nil
+}
@@ -0,0 +1,247 @@
+package ledgerimpl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeResult struct {
+	lastInsertID int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return 1, nil }
+
+type fakeAccount struct {
+	key     AccountKey
+	balance int64
+}
+
+type fakePosting struct {
+	entryID, accountID int64
+	debit, credit      int64
+}
+
+// fakeLedgerDB keeps the ledger tables in memory and answers the ledger
+// queries from them. failOn makes that query fail.
+type fakeLedgerDB struct {
+	accounts   []*fakeAccount
+	references []string
+	postings   []fakePosting
+	failOn     string
+}
+
+func (db *fakeLedgerDB) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	if query == db.failOn {
+		return nil, fmt.Errorf("error")
+	}
+
+	switch query {
+	case insertEntrySQL:
+		db.references = append(db.references, args[0].(string))
+		return fakeResult{lastInsertID: int64(len(db.references))}, nil
+	case upsertAccountSQL:
+		key := AccountKey{AffiliateID: args[0].(int64), Currency: args[1].(string), Type: args[2].(AccountType)}
+		for i, a := range db.accounts {
+			if a.key == key {
+				return fakeResult{lastInsertID: int64(i + 1)}, nil
+			}
+		}
+		db.accounts = append(db.accounts, &fakeAccount{key: key})
+		return fakeResult{lastInsertID: int64(len(db.accounts))}, nil
+	case insertPostingSQL:
+		db.postings = append(db.postings, fakePosting{args[0].(int64), args[1].(int64), args[2].(int64), args[3].(int64)})
+	case updateAccountBalanceSQL:
+		db.accounts[args[1].(int64)-1].balance += args[0].(int64)
+	default:
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+
+	return fakeResult{}, nil
+}
+
+func (db *fakeLedgerDB) GetContext(_ context.Context, dest any, query string, args ...any) error {
+	if query == db.failOn {
+		return fmt.Errorf("error")
+	}
+
+	key := AccountKey{AffiliateID: args[0].(int64), Currency: args[1].(string), Type: args[2].(AccountType)}
+	for _, a := range db.accounts {
+		if a.key == key {
+			*dest.(*int64) = a.balance
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// entries rebuilds the stored journal so the invariant queries can be
+// answered with CheckInvariants.
+func (db *fakeLedgerDB) entries() []*JournalEntry {
+	entries := make([]*JournalEntry, len(db.references))
+	for i := range entries {
+		entries[i] = &JournalEntry{ID: int64(i + 1)}
+	}
+	for _, p := range db.postings {
+		e := entries[p.entryID-1]
+		e.Postings = append(e.Postings, Posting{Account: db.accounts[p.accountID-1].key, Debit: p.debit, Credit: p.credit})
+	}
+
+	return entries
+}
+
+func (db *fakeLedgerDB) SelectContext(_ context.Context, dest any, query string, _ ...any) error {
+	if query == db.failOn {
+		return fmt.Errorf("error")
+	}
+
+	switch query {
+	case selectEntryImbalancesSQL, selectCurrencyImbalancesSQL:
+		for _, i := range CheckInvariants(db.entries()) {
+			if (i.EntryID != 0) == (query == selectEntryImbalancesSQL) {
+				*dest.(*[]Imbalance) = append(*dest.(*[]Imbalance), i)
+			}
+		}
+	case selectAccountDriftSQL:
+		balances := Balances(db.entries())
+		for _, a := range db.accounts {
+			if a.balance != balances[a.key] {
+				*dest.(*[]AccountDrift) = append(*dest.(*[]AccountDrift), AccountDrift{AccountKey: a.key, Balance: a.balance, Posted: balances[a.key]})
+			}
+		}
+	default:
+		return fmt.Errorf("unexpected query %q", query)
+	}
+
+	return nil
+}
+
+func TestPostEntry(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(reference string, amount int64) *JournalEntry {
+		return &JournalEntry{
+			Reference: reference,
+			Postings:  []Posting{{Account: operatorUSD, Debit: amount}, {Account: pendingUSD, Credit: amount}},
+			CreatedAt: now,
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		entries         []*JournalEntry
+		failOn          string
+		expectedError   error
+		expectedPending int64
+	}{
+		{
+			name:            "post entry success",
+			entries:         []*JournalEntry{entry("transfer:1:pending", 100), entry("transfer:2:pending", 50)},
+			expectedPending: -150,
+		},
+		{
+			name:          "post entry error - unbalanced",
+			entries:       []*JournalEntry{{Reference: "x", Postings: []Posting{{Account: operatorUSD, Debit: 100}, {Account: pendingUSD, Credit: 90}}}},
+			expectedError: ErrUnbalancedEntry,
+		},
+		{
+			name:          "post entry error",
+			entries:       []*JournalEntry{entry("transfer:1:pending", 100)},
+			failOn:        insertPostingSQL,
+			expectedError: fmt.Errorf("error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &fakeLedgerDB{failOn: tc.failOn}
+
+			var err error
+			for _, e := range tc.entries {
+				if err = PostEntry(context.Background(), db, e); err != nil {
+					break
+				}
+			}
+			if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected error %q, but got none", tc.expectedError)
+			} else if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected no error, but got %q", err)
+			}
+			if errors.Is(tc.expectedError, ErrUnbalancedEntry) && len(db.references) != 0 {
+				t.Fatalf("expected an invalid entry not to be stored, but got %v", db.references)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+
+			if len(db.accounts) != 2 {
+				t.Fatalf("expected accounts to be reused, but got %d", len(db.accounts))
+			}
+			if tc.entries[1].ID != 2 {
+				t.Fatalf("expected entry ID %d, but got %d", 2, tc.entries[1].ID)
+			}
+			pending, err := AccountBalance(context.Background(), db, pendingUSD)
+			if err != nil || pending != tc.expectedPending {
+				t.Fatalf("expected balance %d, but got %d (%v)", tc.expectedPending, pending, err)
+			}
+			payable, err := AccountBalance(context.Background(), db, AccountKey{AffiliateID: 1, Currency: "USD", Type: AccountAffiliatePayable})
+			if err != nil || payable != 0 {
+				t.Fatalf("expected an unused account to have balance 0, but got %d (%v)", payable, err)
+			}
+		})
+	}
+}
+
+func TestCheckStoredInvariants(t *testing.T) {
+	db := &fakeLedgerDB{}
+	err := PostEntry(context.Background(), db, &JournalEntry{
+		Reference: "transfer:1:pending",
+		Postings:  []Posting{{Account: operatorUSD, Debit: 100}, {Account: pendingUSD, Credit: 100}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+
+	result, err := CheckStoredInvariants(context.Background(), db)
+	if err != nil || len(result) != 0 {
+		t.Fatalf("expected a balanced ledger, but got %v (%v)", result, err)
+	}
+	drift, err := CheckAccountBalances(context.Background(), db)
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("expected no drift, but got %v (%v)", drift, err)
+	}
+
+	// A posting written around PostEntry unbalances entry 1 and leaves the
+	// pending account's stored balance behind.
+	db.postings = append(db.postings, fakePosting{entryID: 1, accountID: 2, credit: 10})
+
+	result, err = CheckStoredInvariants(context.Background(), db)
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	expected := []Imbalance{
+		{EntryID: 1, Currency: "USD", Debit: 100, Credit: 110},
+		{Currency: "USD", Debit: 100, Credit: 110},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %v, but got %v", expected, result)
+	}
+
+	drift, err = CheckAccountBalances(context.Background(), db)
+	if err != nil {
+		t.Fatalf("expected no error, but got %q", err)
+	}
+	if want := []AccountDrift{{AccountKey: pendingUSD, Balance: -100, Posted: -110}}; !reflect.DeepEqual(drift, want) {
+		t.Fatalf("expected %v, but got %v", want, drift)
+	}
+
+	db.failOn = selectCurrencyImbalancesSQL
+	if _, err := CheckStoredInvariants(context.Background(), db); err == nil {
+		t.Fatalf("expected error %q, but got none", "error")
+	}
+}
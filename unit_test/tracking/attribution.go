@@ -0,0 +1,156 @@
+package trackingimpl
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+type attributionModel string
+
+const (
+	attributionLastClick  attributionModel = "last_click"
+	attributionFirstClick attributionModel = "first_click"
+	attributionLinear     attributionModel = "linear"
+	attributionTimeDecay  attributionModel = "time_decay"
+)
+
+// fullCredit is the whole conversion in basis points.
+const fullCredit = 10000
+
+type attributionConfig struct {
+	Model attributionModel
+	// HalfLife is how much older a touchpoint is when it gets half the
+	// weight under time decay.
+	HalfLife time.Duration
+	// DefaultWindow is the cookie window for affiliates not in Windows.
+	DefaultWindow time.Duration
+	Windows       map[int64]time.Duration
+}
+
+// touchpoint is a click by the converting player.
+type touchpoint struct {
+	ClickID     string
+	TrackingID  int64
+	AffiliateID int64
+	At          time.Time
+}
+
+// attributionCredit is an affiliate's share of one conversion in basis
+// points. ClickID and TrackingID are the affiliate's latest credited click.
+type attributionCredit struct {
+	AffiliateID int64
+	TrackingID  int64
+	ClickID     string
+	BasisPoints int64
+}
+
+func (c attributionConfig) window(affiliateID int64) time.Duration {
+	if w, ok := c.Windows[affiliateID]; ok {
+		return w
+	}
+
+	return c.DefaultWindow
+}
+
+// attribute splits a conversion between the affiliates whose clicks are
+// inside their cookie window. Shares add up to fullCredit; no credits are
+// returned when no click qualifies.
+func attribute(cfg attributionConfig, convertedAt time.Time, touchpoints []touchpoint) ([]attributionCredit, error) {
+	eligible := make([]touchpoint, 0, len(touchpoints))
+	for _, tp := range touchpoints {
+		if tp.At.After(convertedAt) || convertedAt.Sub(tp.At) > cfg.window(tp.AffiliateID) {
+			continue
+		}
+		eligible = append(eligible, tp)
+	}
+	if len(eligible) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(eligible, func(i, j int) bool {
+		return eligible[i].At.Before(eligible[j].At)
+	})
+
+	weights := make([]float64, len(eligible))
+	switch cfg.Model {
+	case attributionLastClick:
+		weights[len(weights)-1] = 1
+	case attributionFirstClick:
+		weights[0] = 1
+	case attributionLinear:
+		for i := range weights {
+			weights[i] = 1
+		}
+	case attributionTimeDecay:
+		if cfg.HalfLife <= 0 {
+			return nil, fmt.Errorf("time decay attribution needs a positive half life")
+		}
+		for i, tp := range eligible {
+			age := convertedAt.Sub(tp.At)
+			weights[i] = math.Exp2(-float64(age) / float64(cfg.HalfLife))
+		}
+	default:
+		return nil, fmt.Errorf("unknown attribution model %q", cfg.Model)
+	}
+
+	points := splitBasisPoints(weights)
+
+	credits := make([]attributionCredit, 0)
+	index := map[int64]int{}
+	for i, tp := range eligible {
+		if points[i] == 0 {
+			continue
+		}
+		n, ok := index[tp.AffiliateID]
+		if !ok {
+			n = len(credits)
+			index[tp.AffiliateID] = n
+			credits = append(credits, attributionCredit{AffiliateID: tp.AffiliateID})
+		}
+		credits[n].BasisPoints += points[i]
+		credits[n].TrackingID = tp.TrackingID
+		credits[n].ClickID = tp.ClickID
+	}
+
+	return credits, nil
+}
+
+// splitBasisPoints turns weights into whole basis points that add up to
+// fullCredit, giving the remainder to the largest fractions and, on ties,
+// to the later touchpoint.
+func splitBasisPoints(weights []float64) []int64 {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+
+	points := make([]int64, len(weights))
+	fractions := make([]float64, len(weights))
+	var assigned int64
+	for i, w := range weights {
+		exact := w / total * fullCredit
+		points[i] = int64(math.Floor(exact))
+		fractions[i] = exact - float64(points[i])
+		assigned += points[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if fractions[order[a]] != fractions[order[b]] {
+			return fractions[order[a]] > fractions[order[b]]
+		}
+		return order[a] > order[b]
+	})
+
+	for i := 0; assigned < fullCredit; i++ {
+		points[order[i%len(order)]]++
+		assigned++
+	}
+
+	return points
+}
@@ -0,0 +1,138 @@
+package trackingimpl
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAttribute(t *testing.T) {
+	day := 24 * time.Hour
+	converted := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	touchpoints := []touchpoint{
+		{ClickID: "late", TrackingID: 12, AffiliateID: 1, At: converted.Add(-1 * day)},
+		{ClickID: "early", TrackingID: 11, AffiliateID: 1, At: converted.Add(-10 * day)},
+		{ClickID: "middle", TrackingID: 21, AffiliateID: 2, At: converted.Add(-2 * day)},
+		{ClickID: "expired", TrackingID: 31, AffiliateID: 3, At: converted.Add(-20 * day)},
+		{ClickID: "after", TrackingID: 41, AffiliateID: 4, At: converted.Add(time.Minute)},
+	}
+	cfg := attributionConfig{
+		HalfLife:      day,
+		DefaultWindow: 7 * day,
+		Windows:       map[int64]time.Duration{1: 30 * day},
+	}
+
+	testCases := []struct {
+		name           string
+		model          attributionModel
+		touchpoints    []touchpoint
+		expectedResult []attributionCredit
+	}{
+		{
+			name:        "attribute last click",
+			model:       attributionLastClick,
+			touchpoints: touchpoints,
+			expectedResult: []attributionCredit{
+				{AffiliateID: 1, TrackingID: 12, ClickID: "late", BasisPoints: 10000},
+			},
+		},
+		{
+			name:        "attribute first click",
+			model:       attributionFirstClick,
+			touchpoints: touchpoints,
+			expectedResult: []attributionCredit{
+				{AffiliateID: 1, TrackingID: 11, ClickID: "early", BasisPoints: 10000},
+			},
+		},
+		{
+			name:        "attribute linear",
+			model:       attributionLinear,
+			touchpoints: touchpoints,
+			expectedResult: []attributionCredit{
+				{AffiliateID: 1, TrackingID: 12, ClickID: "late", BasisPoints: 6667},
+				{AffiliateID: 2, TrackingID: 21, ClickID: "middle", BasisPoints: 3333},
+			},
+		},
+		{
+			name:        "attribute time decay",
+			model:       attributionTimeDecay,
+			touchpoints: touchpoints,
+			expectedResult: []attributionCredit{
+				{AffiliateID: 1, TrackingID: 12, ClickID: "late", BasisPoints: 6671},
+				{AffiliateID: 2, TrackingID: 21, ClickID: "middle", BasisPoints: 3329},
+			},
+		},
+		{
+			name:  "attribute cookie window cut-off",
+			model: attributionLastClick,
+			touchpoints: []touchpoint{
+				{ClickID: "edge", TrackingID: 21, AffiliateID: 2, At: converted.Add(-7 * day)},
+				{ClickID: "expired", TrackingID: 31, AffiliateID: 3, At: converted.Add(-7*day - time.Second)},
+			},
+			expectedResult: []attributionCredit{
+				{AffiliateID: 2, TrackingID: 21, ClickID: "edge", BasisPoints: 10000},
+			},
+		},
+		{
+			name:  "attribute no eligible click",
+			model: attributionLinear,
+			touchpoints: []touchpoint{
+				{ClickID: "expired", TrackingID: 31, AffiliateID: 3, At: converted.Add(-20 * day)},
+				{ClickID: "after", TrackingID: 41, AffiliateID: 4, At: converted.Add(time.Minute)},
+			},
+			expectedResult: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.Model = tc.model
+
+			result, err := attribute(cfg, converted, tc.touchpoints)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tc.expectedResult) {
+				t.Errorf("expected %+v, got %+v", tc.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestAttributeInvalidConfig(t *testing.T) {
+	converted := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	touchpoints := []touchpoint{{AffiliateID: 1, At: converted.Add(-time.Hour)}}
+
+	testCases := []struct {
+		name string
+		cfg  attributionConfig
+	}{
+		{name: "attribute error - unknown model", cfg: attributionConfig{Model: "u_shaped", DefaultWindow: 24 * time.Hour}},
+		{name: "attribute error - no half life", cfg: attributionConfig{Model: attributionTimeDecay, DefaultWindow: 24 * time.Hour}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := attribute(tc.cfg, converted, touchpoints); err == nil {
+				t.Errorf("expected error, got none")
+			}
+		})
+	}
+}
+
+func TestSplitBasisPoints(t *testing.T) {
+	result := splitBasisPoints([]float64{1, 1, 1, 1, 1, 1, 1})
+
+	var total int64
+	for _, p := range result {
+		total += p
+	}
+	if total != fullCredit {
+		t.Errorf("expected %d basis points, got %d in %v", fullCredit, total, result)
+	}
+	if result[0] != 1428 || result[6] != 1429 {
+		t.Errorf("expected remainder on the latest touchpoints, got %v", result)
+	}
+}
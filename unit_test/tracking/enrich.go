@@ -0,0 +1,122 @@
+package trackingimpl
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+)
+
+const (
+	deviceDesktop = "desktop"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceBot     = "bot"
+	uaOther       = "Other"
+)
+
+type deviceInfo struct {
+	DeviceType string
+	OS         string
+	Browser    string
+}
+
+type uaRule struct {
+	match []string
+	name  string
+}
+
+// osRules and browserRules are checked in order; the first rule with any
+// matching substring wins, so more specific tokens come first.
+var osRules = []uaRule{
+	{match: []string{"Windows NT", "Windows Phone"}, name: "Windows"},
+	{match: []string{"iPhone", "iPad", "iPod"}, name: "iOS"},
+	{match: []string{"Android"}, name: "Android"},
+	{match: []string{"CrOS"}, name: "ChromeOS"},
+	{match: []string{"Macintosh", "Mac OS X"}, name: "macOS"},
+	{match: []string{"Linux"}, name: "Linux"},
+}
+
+var browserRules = []uaRule{
+	{match: []string{"Edg/", "EdgA/", "EdgiOS/"}, name: "Edge"},
+	{match: []string{"OPR/", "Opera"}, name: "Opera"},
+	{match: []string{"SamsungBrowser/"}, name: "Samsung Internet"},
+	{match: []string{"Firefox/", "FxiOS/"}, name: "Firefox"},
+	{match: []string{"CriOS/", "Chrome/"}, name: "Chrome"},
+	{match: []string{"Version/"}, name: "Safari"},
+}
+
+func matchUARule(ua string, rules []uaRule) string {
+	for _, rule := range rules {
+		for _, m := range rule.match {
+			if strings.Contains(ua, m) {
+				return rule.name
+			}
+		}
+	}
+
+	return uaOther
+}
+
+// parseUserAgent sorts a user agent into device type, OS and browser.
+func parseUserAgent(ua string) deviceInfo {
+	info := deviceInfo{
+		OS:      matchUARule(ua, osRules),
+		Browser: matchUARule(ua, browserRules),
+	}
+	if info.Browser == "Safari" && !strings.Contains(ua, "Safari/") {
+		info.Browser = uaOther
+	}
+
+	switch {
+	case isBotUserAgent(ua, defaultBotUserAgents):
+		info.DeviceType = deviceBot
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile")):
+		info.DeviceType = deviceTablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		info.DeviceType = deviceMobile
+	default:
+		info.DeviceType = deviceDesktop
+	}
+
+	return info
+}
+
+type geoLocator interface {
+	location(ip netip.Addr) (geoLocation, error)
+}
+
+// clickEnricher fills in the location and device fields of a click before
+// it is written to the tracking log. A click whose IP is not in the geo
+// database keeps an empty country.
+type clickEnricher struct {
+	geo geoLocator
+}
+
+func (en *clickEnricher) enrich(e *clickEvent) {
+	if en.geo != nil {
+		if ip, err := netip.ParseAddr(e.IP); err == nil {
+			if loc, err := en.geo.location(ip); err == nil {
+				e.Country = loc.Country
+				e.Region = loc.Region
+			}
+		}
+	}
+
+	info := parseUserAgent(e.UserAgent)
+	e.DeviceType = info.DeviceType
+	e.OS = info.OS
+	e.Browser = info.Browser
+}
+
+// wrap enriches clicks in the recorder's background writer so the lookups
+// stay off the redirect path.
+func (en *clickEnricher) wrap(save func(context.Context, []*clickEvent) error) func(context.Context, []*clickEvent) error {
+	return func(ctx context.Context, events []*clickEvent) error {
+		for _, e := range events {
+			en.enrich(e)
+		}
+
+		return save(ctx, events)
+	}
+}
@@ -0,0 +1,162 @@
+package trackingimpl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	testCases := []struct {
+		name           string
+		userAgent      string
+		expectedResult deviceInfo
+	}{
+		{
+			name:           "parse user agent - windows chrome",
+			userAgent:      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+			expectedResult: deviceInfo{DeviceType: deviceDesktop, OS: "Windows", Browser: "Chrome"},
+		},
+		{
+			name:           "parse user agent - windows edge",
+			userAgent:      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.2792.79",
+			expectedResult: deviceInfo{DeviceType: deviceDesktop, OS: "Windows", Browser: "Edge"},
+		},
+		{
+			name:           "parse user agent - iphone safari",
+			userAgent:      "Mozilla/5.0 (iPhone; CPU iPhone OS 18_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.0 Mobile/15E148 Safari/604.1",
+			expectedResult: deviceInfo{DeviceType: deviceMobile, OS: "iOS", Browser: "Safari"},
+		},
+		{
+			name:           "parse user agent - ipad chrome",
+			userAgent:      "Mozilla/5.0 (iPad; CPU OS 17_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/129.0.6668.69 Mobile/15E148 Safari/604.1",
+			expectedResult: deviceInfo{DeviceType: deviceTablet, OS: "iOS", Browser: "Chrome"},
+		},
+		{
+			name:           "parse user agent - android samsung",
+			userAgent:      "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/26.0 Chrome/122.0.0.0 Mobile Safari/537.36",
+			expectedResult: deviceInfo{DeviceType: deviceMobile, OS: "Android", Browser: "Samsung Internet"},
+		},
+		{
+			name:           "parse user agent - android tablet",
+			userAgent:      "Mozilla/5.0 (Linux; Android 13; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+			expectedResult: deviceInfo{DeviceType: deviceTablet, OS: "Android", Browser: "Chrome"},
+		},
+		{
+			name:           "parse user agent - mac firefox",
+			userAgent:      "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.6; rv:131.0) Gecko/20100101 Firefox/131.0",
+			expectedResult: deviceInfo{DeviceType: deviceDesktop, OS: "macOS", Browser: "Firefox"},
+		},
+		{
+			name:           "parse user agent - linux opera",
+			userAgent:      "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Safari/537.36 OPR/114.0.0.0",
+			expectedResult: deviceInfo{DeviceType: deviceDesktop, OS: "Linux", Browser: "Opera"},
+		},
+		{
+			name:           "parse user agent - bot",
+			userAgent:      "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			expectedResult: deviceInfo{DeviceType: deviceBot, OS: uaOther, Browser: uaOther},
+		},
+		{
+			name:           "parse user agent - empty",
+			userAgent:      "",
+			expectedResult: deviceInfo{DeviceType: deviceBot, OS: uaOther, Browser: uaOther},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := parseUserAgent(tc.userAgent)
+			if result != tc.expectedResult {
+				t.Errorf("expected %+v, got %+v", tc.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestClickEnricher(t *testing.T) {
+	db, err := parseGeoDB(geoFixture(t, 24, 6))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var saved []*clickEvent
+	recorder := newClickRecorder(10, 10, time.Hour, &clickEnricher{geo: db}, func(_ context.Context, events []*clickEvent) error {
+		saved = append(saved, events...)
+		return nil
+	}, nil)
+
+	events := []*clickEvent{
+		{IP: "81.2.69.142", UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 18_0 like Mac OS X) Version/18.0 Mobile/15E148 Safari/604.1"},
+		{IP: "10.0.0.1", UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/129.0.0.0 Safari/537.36"},
+		{IP: "not-an-ip", UserAgent: "curl/8.4.0"},
+	}
+	for _, e := range events {
+		recorder.record(e)
+	}
+	recorder.Close()
+
+	type enriched struct {
+		Country, Region string
+		deviceInfo
+	}
+	expected := []enriched{
+		{Country: "GB", Region: "ENG", deviceInfo: deviceInfo{DeviceType: deviceMobile, OS: "iOS", Browser: "Safari"}},
+		{deviceInfo: deviceInfo{DeviceType: deviceDesktop, OS: "Windows", Browser: "Chrome"}},
+		{deviceInfo: deviceInfo{DeviceType: deviceBot, OS: uaOther, Browser: uaOther}},
+	}
+	if len(saved) != len(expected) {
+		t.Fatalf("expected %d clicks saved, got %d", len(expected), len(saved))
+	}
+	for i, e := range saved {
+		got := enriched{Country: e.Country, Region: e.Region, deviceInfo: deviceInfo{DeviceType: e.DeviceType, OS: e.OS, Browser: e.Browser}}
+		if got != expected[i] {
+			t.Errorf("click %d: expected %+v, got %+v", i, expected[i], got)
+		}
+	}
+}
+
+func TestSummarizeFromRollupsGroupBy(t *testing.T) {
+	store := newFakeRollupStore(rollupFixture())
+	if _, err := (&rollupJob{store: store, batch: 100}).run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	query := rollupSummaryQuery{
+		From:    time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+		To:      time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC),
+		GroupBy: []string{"country", "device_type"},
+	}
+
+	result, err := summarizeFromRollups(context.Background(), store, query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	converted := funnel{Clicks: 3, Signups: 1, FTDs: 1, FTDAmount: 5000}
+	converted.setRates()
+	expected := []*rollupSummaryRow{
+		{TrackingID: 7, AffiliateID: 3, Country: "JP", DeviceType: "desktop", funnel: funnel{Clicks: 1}},
+		{TrackingID: 7, AffiliateID: 3, Country: "PH", DeviceType: "mobile", funnel: converted},
+		{TrackingID: 8, AffiliateID: 4, Country: "PH", DeviceType: "desktop", funnel: funnel{Clicks: 1}},
+		{TrackingID: 8, AffiliateID: 4, Country: "PH", DeviceType: "mobile"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+
+	query.GroupBy = []string{"browser"}
+	if _, err := summarizeFromRollups(context.Background(), store, query); err == nil {
+		t.Errorf("expected error for unknown group by, got none")
+	}
+}
+
+func TestClickEnricherWithoutGeo(t *testing.T) {
+	e := &clickEvent{IP: "81.2.69.142", UserAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/131.0"}
+	(&clickEnricher{}).enrich(e)
+
+	if e.Country != "" || e.DeviceType != deviceDesktop || e.OS != "Linux" || e.Browser != "Firefox" {
+		t.Errorf("unexpected enrichment %+v", e)
+	}
+}
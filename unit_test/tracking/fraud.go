@@ -0,0 +1,206 @@
+package trackingimpl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fraudReason string
+
+const (
+	fraudBotUserAgent fraudReason = "bot_user_agent"
+	fraudDatacenterIP fraudReason = "datacenter_ip"
+	fraudIPRateLimit  fraudReason = "ip_rate_limit"
+	fraudDuplicate    fraudReason = "duplicate_click"
+)
+
+// defaultBotUserAgents are lower case substrings of crawler and tool user
+// agents. An empty user agent is treated as a bot as well.
+var defaultBotUserAgents = []string{
+	"bot", "crawler", "spider", "slurp", "headlesschrome", "phantomjs",
+	"curl/", "wget/", "python-requests", "go-http-client", "okhttp", "java/",
+	"facebookexternalhit", "preview",
+}
+
+type fraudConfig struct {
+	BotUserAgents    []string
+	DatacenterRanges []netip.Prefix
+	// MaxClicksPerIP clicks are allowed per IP within RateWindow; 0 disables
+	// the limit.
+	MaxClicksPerIP  int
+	RateWindow      time.Duration
+	DuplicateWindow time.Duration
+}
+
+// loadIPRanges reads a local list of CIDR ranges, one per line. Blank lines
+// and # comments are ignored.
+func loadIPRanges(r io.Reader) ([]netip.Prefix, error) {
+	ranges := make([]netip.Prefix, 0)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ranges = append(ranges, prefix.Masked())
+	}
+
+	return ranges, scanner.Err()
+}
+
+// clickFilter flags suspicious clicks. Rate and duplicate windows are kept
+// in memory per instance and pruned by check once per window.
+type clickFilter struct {
+	cfg fraudConfig
+
+	mu        sync.Mutex
+	byIP      map[string][]time.Time
+	lastSeen  map[string]time.Time
+	lastPrune time.Time
+}
+
+func newClickFilter(cfg fraudConfig) *clickFilter {
+	if cfg.BotUserAgents == nil {
+		cfg.BotUserAgents = defaultBotUserAgents
+	}
+
+	return &clickFilter{
+		cfg:      cfg,
+		byIP:     map[string][]time.Time{},
+		lastSeen: map[string]time.Time{},
+	}
+}
+
+// check returns why the click is flagged, or an empty reason. Every click
+// counts towards the rate limit and duplicate window, flagged or not.
+func (f *clickFilter) check(e *clickEvent) fraudReason {
+	reason := f.staticReason(e)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if e.CreatedAt.Sub(f.lastPrune) >= max(f.cfg.RateWindow, f.cfg.DuplicateWindow) {
+		f.pruneLocked(e.CreatedAt)
+		f.lastPrune = e.CreatedAt
+	}
+
+	if f.cfg.MaxClicksPerIP > 0 {
+		recent := f.byIP[e.IP][:0]
+		for _, at := range f.byIP[e.IP] {
+			if e.CreatedAt.Sub(at) < f.cfg.RateWindow {
+				recent = append(recent, at)
+			}
+		}
+		f.byIP[e.IP] = append(recent, e.CreatedAt)
+
+		if reason == "" && len(recent) >= f.cfg.MaxClicksPerIP {
+			reason = fraudIPRateLimit
+		}
+	}
+
+	if f.cfg.DuplicateWindow > 0 {
+		key := fmt.Sprintf("%d|%s|%s", e.TrackingID, e.IP, e.UserAgent)
+		last, ok := f.lastSeen[key]
+		f.lastSeen[key] = e.CreatedAt
+
+		if reason == "" && ok && e.CreatedAt.Sub(last) < f.cfg.DuplicateWindow {
+			reason = fraudDuplicate
+		}
+	}
+
+	return reason
+}
+
+func isBotUserAgent(ua string, bots []string) bool {
+	ua = strings.ToLower(ua)
+	if strings.TrimSpace(ua) == "" {
+		return true
+	}
+	for _, bot := range bots {
+		if strings.Contains(ua, bot) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *clickFilter) staticReason(e *clickEvent) fraudReason {
+	if isBotUserAgent(e.UserAgent, f.cfg.BotUserAgents) {
+		return fraudBotUserAgent
+	}
+
+	if addr, err := netip.ParseAddr(e.IP); err == nil {
+		addr = addr.Unmap()
+		for _, prefix := range f.cfg.DatacenterRanges {
+			if prefix.Contains(addr) {
+				return fraudDatacenterIP
+			}
+		}
+	}
+
+	return ""
+}
+
+// prune forgets rate and duplicate history older than both windows.
+func (f *clickFilter) prune(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pruneLocked(now)
+}
+
+func (f *clickFilter) pruneLocked(now time.Time) {
+	for ip, times := range f.byIP {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= f.cfg.RateWindow {
+			delete(f.byIP, ip)
+		}
+	}
+	for key, at := range f.lastSeen {
+		if now.Sub(at) >= f.cfg.DuplicateWindow {
+			delete(f.lastSeen, key)
+		}
+	}
+}
+
+type clickSummary struct {
+	Clicks    int64
+	UniqueIPs int64
+	Flagged   int64
+}
+
+// summarizeClicks leaves flagged clicks out unless includeFlagged is set.
+// Flagged is always the number of flagged clicks seen.
+func summarizeClicks(events []*clickEvent, includeFlagged bool) clickSummary {
+	var summary clickSummary
+	ips := map[string]bool{}
+
+	for _, e := range events {
+		if e.FlagReason != "" {
+			summary.Flagged++
+			if !includeFlagged {
+				continue
+			}
+		}
+
+		summary.Clicks++
+		if !ips[e.IP] {
+			ips[e.IP] = true
+			summary.UniqueIPs++
+		}
+	}
+
+	return summary
+}
@@ -0,0 +1,199 @@
+package trackingimpl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+const browserUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/129.0 Safari/537.36"
+
+func TestLoadIPRanges(t *testing.T) {
+	ranges, err := loadIPRanges(strings.NewReader("# cloud provider ranges\n198.51.100.0/24\n\n2001:db8::/32 # v6\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ranges) != 2 || ranges[0].String() != "198.51.100.0/24" || ranges[1].String() != "2001:db8::/32" {
+		t.Errorf("unexpected ranges %v", ranges)
+	}
+
+	if _, err := loadIPRanges(strings.NewReader("198.51.100.0/24\nnot-a-range\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
+
+func TestClickFilterStatic(t *testing.T) {
+	ranges, _ := loadIPRanges(strings.NewReader("198.51.100.0/24\n2001:db8::/32\n"))
+	filter := newClickFilter(fraudConfig{DatacenterRanges: ranges})
+
+	testCases := []struct {
+		name           string
+		ip             string
+		userAgent      string
+		expectedReason fraudReason
+	}{
+		{name: "check click success", ip: "203.0.113.9", userAgent: browserUA},
+		{name: "check click flagged - googlebot", ip: "203.0.113.9", userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1)", expectedReason: fraudBotUserAgent},
+		{name: "check click flagged - curl", ip: "203.0.113.9", userAgent: "curl/8.4.0", expectedReason: fraudBotUserAgent},
+		{name: "check click flagged - headless chrome", ip: "203.0.113.9", userAgent: "Mozilla/5.0 HeadlessChrome/129.0", expectedReason: fraudBotUserAgent},
+		{name: "check click flagged - empty user agent", ip: "203.0.113.9", userAgent: "", expectedReason: fraudBotUserAgent},
+		{name: "check click flagged - datacenter ip", ip: "198.51.100.77", userAgent: browserUA, expectedReason: fraudDatacenterIP},
+		{name: "check click flagged - mapped datacenter ip", ip: "::ffff:198.51.100.77", userAgent: browserUA, expectedReason: fraudDatacenterIP},
+		{name: "check click flagged - datacenter ipv6", ip: "2001:db8::1", userAgent: browserUA, expectedReason: fraudDatacenterIP},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := filter.check(&clickEvent{IP: tc.ip, UserAgent: tc.userAgent, CreatedAt: time.Now()})
+			if reason != tc.expectedReason {
+				t.Errorf("expected %q, got %q", tc.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestClickFilterWindows(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	click := func(trackingID int64, ip string, after time.Duration) *clickEvent {
+		return &clickEvent{TrackingID: trackingID, IP: ip, UserAgent: browserUA, CreatedAt: start.Add(after)}
+	}
+
+	t.Run("check click flagged - duplicate window", func(t *testing.T) {
+		filter := newClickFilter(fraudConfig{DuplicateWindow: 30 * time.Second})
+
+		reasons := []fraudReason{
+			filter.check(click(1, "203.0.113.9", 0)),
+			filter.check(click(1, "203.0.113.9", 10*time.Second)),
+			filter.check(click(2, "203.0.113.9", 15*time.Second)),
+			filter.check(click(1, "203.0.113.9", 50*time.Second)),
+		}
+		expected := []fraudReason{"", fraudDuplicate, "", ""}
+		for i := range expected {
+			if reasons[i] != expected[i] {
+				t.Errorf("click %d: expected %q, got %q", i, expected[i], reasons[i])
+			}
+		}
+	})
+
+	t.Run("check click flagged - ip rate limit", func(t *testing.T) {
+		filter := newClickFilter(fraudConfig{MaxClicksPerIP: 3, RateWindow: time.Minute})
+
+		reasons := make([]fraudReason, 0)
+		for i, after := range []time.Duration{0, 10 * time.Second, 20 * time.Second, 30 * time.Second, 75 * time.Second} {
+			reasons = append(reasons, filter.check(click(int64(i), "203.0.113.9", after)))
+		}
+		reasons = append(reasons, filter.check(click(9, "203.0.113.10", 30*time.Second)))
+
+		expected := []fraudReason{"", "", "", fraudIPRateLimit, "", ""}
+		for i := range expected {
+			if reasons[i] != expected[i] {
+				t.Errorf("click %d: expected %q, got %q", i, expected[i], reasons[i])
+			}
+		}
+	})
+
+	t.Run("prune history", func(t *testing.T) {
+		filter := newClickFilter(fraudConfig{MaxClicksPerIP: 3, RateWindow: time.Minute, DuplicateWindow: time.Minute})
+		filter.check(click(1, "203.0.113.9", 0))
+		filter.prune(start.Add(2 * time.Minute))
+
+		if len(filter.byIP) != 0 || len(filter.lastSeen) != 0 {
+			t.Errorf("expected empty history, got %v and %v", filter.byIP, filter.lastSeen)
+		}
+	})
+
+	t.Run("check prunes history", func(t *testing.T) {
+		filter := newClickFilter(fraudConfig{MaxClicksPerIP: 3, RateWindow: time.Minute, DuplicateWindow: time.Minute})
+		filter.check(click(1, "203.0.113.9", 0))
+		filter.check(click(2, "203.0.113.10", 2*time.Minute))
+
+		if _, ok := filter.byIP["203.0.113.9"]; ok || len(filter.lastSeen) != 1 {
+			t.Errorf("expected only the latest click kept, got %v and %v", filter.byIP, filter.lastSeen)
+		}
+	})
+}
+
+func TestRedirectHandlerFlagsClicks(t *testing.T) {
+	resolver := &fakeResolver{targets: map[string]*redirectTarget{
+		"abc123": {TrackingID: 7, Destination: "https://brand.example/"},
+	}}
+	sink := &fakeSink{}
+	handler, _ := newRedirectHandler(redirectConfig{PathPrefix: "/t/", Fraud: &fraudConfig{}}, resolver, sink)
+
+	req := httptest.NewRequest(http.MethodGet, "/t/abc123", nil)
+	req.Header.Set("User-Agent", "python-requests/2.31")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Errorf("expected status %v, got %v", http.StatusFound, rec.Code)
+	}
+	if len(sink.events) != 1 || sink.events[0].FlagReason != fraudBotUserAgent {
+		t.Errorf("expected click flagged %q, got %+v", fraudBotUserAgent, sink.events)
+	}
+}
+
+func TestRedirectHandlerRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	resolver := &fakeResolver{targets: map[string]*redirectTarget{
+		"abc123": {TrackingID: 7, Destination: "https://brand.example/"},
+	}}
+	sink := &fakeSink{}
+	cfg := redirectConfig{
+		PathPrefix:     "/t/",
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		Fraud:          &fraudConfig{MaxClicksPerIP: 2, RateWindow: time.Minute},
+	}
+	handler, _ := newRedirectHandler(cfg, resolver, sink)
+
+	for i := range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/t/abc123", nil)
+		req.RemoteAddr = "203.0.113.9:4000"
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(sink.events) != 3 || sink.events[2].IP != "203.0.113.9" || sink.events[2].FlagReason != fraudIPRateLimit {
+		t.Errorf("expected the third click from 203.0.113.9 flagged %q, got %+v", fraudIPRateLimit, sink.events)
+	}
+}
+
+func TestSummarizeClicks(t *testing.T) {
+	events := []*clickEvent{
+		{IP: "203.0.113.1"},
+		{IP: "203.0.113.1"},
+		{IP: "203.0.113.2"},
+		{IP: "198.51.100.7", FlagReason: fraudDatacenterIP},
+		{IP: "203.0.113.3", FlagReason: fraudBotUserAgent},
+	}
+
+	testCases := []struct {
+		name           string
+		includeFlagged bool
+		expectedResult clickSummary
+	}{
+		{
+			name:           "summarize clicks - flagged excluded",
+			includeFlagged: false,
+			expectedResult: clickSummary{Clicks: 3, UniqueIPs: 2, Flagged: 2},
+		},
+		{
+			name:           "summarize clicks - include flagged",
+			includeFlagged: true,
+			expectedResult: clickSummary{Clicks: 5, UniqueIPs: 4, Flagged: 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := summarizeClicks(events, tc.includeFlagged)
+			if result != tc.expectedResult {
+				t.Errorf("expected %+v, got %+v", tc.expectedResult, result)
+			}
+		})
+	}
+}
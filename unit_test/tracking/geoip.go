@@ -0,0 +1,400 @@
+package trackingimpl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+var ErrInvalidGeoDB = errors.New("invalid geo database")
+
+// geoMetadataMarker precedes the metadata map at the end of a MaxMind DB.
+var geoMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// geoDataSeparator is the run of zero bytes between the search tree and the
+// data section.
+const geoDataSeparator = 16
+
+// geoDB reads a MaxMind DB (.mmdb) file held in memory. Only the parts
+// needed for lookups are supported: record sizes of 24, 28 and 32 bits and
+// the standard data types.
+type geoDB struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint
+	ipv4Start  uint
+	metadata   map[string]any
+}
+
+type geoLocation struct {
+	Country string
+	Region  string
+}
+
+func openGeoDB(path string) (*geoDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGeoDB(buf)
+}
+
+func parseGeoDB(buf []byte) (*geoDB, error) {
+	at := bytes.LastIndex(buf, geoMetadataMarker)
+	if at < 0 {
+		return nil, fmt.Errorf("%w: metadata marker not found", ErrInvalidGeoDB)
+	}
+
+	metaStart := uint(at + len(geoMetadataMarker))
+	meta := &geoDecoder{buf: buf[metaStart:]}
+	value, _, err := meta.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidGeoDB, err)
+	}
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidGeoDB)
+	}
+
+	db := &geoDB{buf: buf, metadata: metadata}
+	db.nodeCount, ok = metadataUint(metadata, "node_count")
+	if !ok {
+		return nil, fmt.Errorf("%w: node_count missing", ErrInvalidGeoDB)
+	}
+	if db.recordSize, ok = metadataUint(metadata, "record_size"); !ok {
+		return nil, fmt.Errorf("%w: record_size missing", ErrInvalidGeoDB)
+	}
+	if db.ipVersion, ok = metadataUint(metadata, "ip_version"); !ok {
+		return nil, fmt.Errorf("%w: ip_version missing", ErrInvalidGeoDB)
+	}
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: record size %d", ErrInvalidGeoDB, db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("%w: ip version %d", ErrInvalidGeoDB, db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	db.dataStart = treeSize + geoDataSeparator
+	if db.dataStart > uint(at) {
+		return nil, fmt.Errorf("%w: search tree larger than file", ErrInvalidGeoDB)
+	}
+
+	// IPv4 addresses live under ::/96 of an IPv6 tree.
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+func metadataUint(m map[string]any, key string) (uint, bool) {
+	v, ok := m[key].(uint64)
+	return uint(v), ok
+}
+
+// record returns the left (0) or right (1) record of a search tree node.
+func (db *geoDB) record(node uint, bit uint) uint {
+	size := db.recordSize / 4
+	b := db.buf[node*size : node*size+size]
+
+	switch db.recordSize {
+	case 24:
+		if bit == 0 {
+			return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3])<<16 | uint(b[4])<<8 | uint(b[5])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		if bit == 0 {
+			return uint(binary.BigEndian.Uint32(b[0:4]))
+		}
+		return uint(binary.BigEndian.Uint32(b[4:8]))
+	}
+}
+
+// lookup returns the data record for ip and whether one was found.
+func (db *geoDB) lookup(ip netip.Addr) (any, bool, error) {
+	ip = ip.Unmap()
+
+	var node uint
+	var bits []byte
+	switch {
+	case ip.Is4() && db.ipVersion == 6:
+		a := ip.As4()
+		node, bits = db.ipv4Start, a[:]
+	case ip.Is4():
+		a := ip.As4()
+		bits = a[:]
+	case db.ipVersion == 4:
+		return nil, false, fmt.Errorf("ipv6 address %s in an ipv4 database", ip)
+	default:
+		a := ip.As16()
+		bits = a[:]
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+
+	switch {
+	case node == db.nodeCount:
+		return nil, false, nil
+	case node < db.nodeCount:
+		return nil, false, fmt.Errorf("%w: search tree deeper than the address", ErrInvalidGeoDB)
+	}
+
+	offset := node - db.nodeCount - geoDataSeparator
+	data := &geoDecoder{buf: db.buf[db.dataStart:]}
+	value, _, err := data.decode(offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidGeoDB, err)
+	}
+
+	return value, true, nil
+}
+
+// location reads the country and first subdivision ISO codes of the
+// GeoIP2/GeoLite2 City and Country layouts.
+func (db *geoDB) location(ip netip.Addr) (geoLocation, error) {
+	value, found, err := db.lookup(ip)
+	if err != nil || !found {
+		return geoLocation{}, err
+	}
+
+	record, _ := value.(map[string]any)
+	var loc geoLocation
+	if country, ok := record["country"].(map[string]any); ok {
+		loc.Country, _ = country["iso_code"].(string)
+	}
+	if subdivisions, ok := record["subdivisions"].([]any); ok && len(subdivisions) > 0 {
+		if first, ok := subdivisions[0].(map[string]any); ok {
+			loc.Region, _ = first["iso_code"].(string)
+		}
+	}
+
+	return loc, nil
+}
+
+const (
+	geoTypeExtended = iota
+	geoTypePointer
+	geoTypeString
+	geoTypeDouble
+	geoTypeBytes
+	geoTypeUint16
+	geoTypeUint32
+	geoTypeMap
+	geoTypeInt32
+	geoTypeUint64
+	geoTypeUint128
+	geoTypeArray
+	geoTypeContainer
+	geoTypeEndMarker
+	geoTypeBool
+	geoTypeFloat
+)
+
+// geoDecoder decodes the MaxMind DB data section format. Pointers are
+// offsets from the start of buf.
+type geoDecoder struct {
+	buf []byte
+}
+
+func (d *geoDecoder) byteAt(offset uint) (byte, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, errors.New("unexpected end of data")
+	}
+
+	return d.buf[offset], nil
+}
+
+func (d *geoDecoder) slice(offset, size uint) ([]byte, error) {
+	if offset+size > uint(len(d.buf)) || offset+size < offset {
+		return nil, errors.New("unexpected end of data")
+	}
+
+	return d.buf[offset : offset+size], nil
+}
+
+// decode returns the value at offset and the offset after it.
+func (d *geoDecoder) decode(offset uint) (any, uint, error) {
+	ctrl, err := d.byteAt(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+
+	typ := uint(ctrl >> 5)
+	if typ == geoTypePointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// A pointer may not point at another pointer, which also rules out
+		// loops.
+		if b, err := d.byteAt(target); err != nil || b>>5 == geoTypePointer {
+			return nil, 0, errors.New("bad pointer target")
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+
+	if typ == geoTypeExtended {
+		ext, err := d.byteAt(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(ext)
+		offset++
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.slice(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+
+		var extra uint
+		for _, c := range b {
+			extra = extra<<8 | uint(c)
+		}
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch typ {
+	case geoTypeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case geoTypeArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case geoTypeBool:
+		return size != 0, offset, nil
+	case geoTypeContainer, geoTypeEndMarker:
+		return nil, offset, nil
+	}
+
+	b, err := d.slice(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+
+	switch typ {
+	case geoTypeString:
+		return string(b), offset, nil
+	case geoTypeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case geoTypeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case geoTypeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case geoTypeUint16, geoTypeUint32, geoTypeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("unsigned integer of %d bytes", size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case geoTypeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("int32 of %d bytes", size)
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), offset, nil
+	case geoTypeUint128:
+		return append([]byte(nil), b...), offset, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+func (d *geoDecoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	b, err := d.slice(offset, n)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var v uint
+	if n < 4 {
+		v = uint(ctrl & 0x7)
+	}
+	for _, c := range b {
+		v = v<<8 | uint(c)
+	}
+
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+
+	return v, offset + n, nil
+}
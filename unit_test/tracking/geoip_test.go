@@ -0,0 +1,421 @@
+package trackingimpl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The helpers below write MaxMind DB files for the tests, following the
+// published format so the reader is checked against independent output.
+
+type mmdbPointer uint
+
+type mmdbUint16 uint16
+
+type mmdbUint32 uint32
+
+type mmdbNetwork struct {
+	prefix string
+	data   any
+}
+
+type mmdbNode struct {
+	child [2]any // *mmdbNode, mmdbDataRef or nil
+}
+
+type mmdbDataRef uint
+
+func mmdbControl(buf *bytes.Buffer, typ int, size int) {
+	first := byte(0)
+	if typ <= 7 {
+		first = byte(typ << 5)
+	}
+
+	var extra []byte
+	switch {
+	case size < 29:
+		first |= byte(size)
+	case size < 285:
+		first |= 29
+		extra = []byte{byte(size - 29)}
+	case size < 65821:
+		first |= 30
+		n := size - 285
+		extra = []byte{byte(n >> 8), byte(n)}
+	default:
+		first |= 31
+		n := size - 65821
+		extra = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+
+	buf.WriteByte(first)
+	if typ > 7 {
+		buf.WriteByte(byte(typ - 7))
+	}
+	buf.Write(extra)
+}
+
+func mmdbUintBytes(v uint64) []byte {
+	b := make([]byte, 0, 8)
+	for v > 0 {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+	}
+	return b
+}
+
+func mmdbEncode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case mmdbPointer:
+		switch {
+		case v < 2048:
+			buf.Write([]byte{0x20 | byte(v>>8), byte(v)})
+		case v < 526336:
+			p := v - 2048
+			buf.Write([]byte{0x28 | byte(p>>16), byte(p >> 8), byte(p)})
+		default:
+			panic("pointer too large for the test writer")
+		}
+	case string:
+		mmdbControl(buf, geoTypeString, len(v))
+		buf.WriteString(v)
+	case float64:
+		mmdbControl(buf, geoTypeDouble, 8)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case mmdbUint16:
+		b := mmdbUintBytes(uint64(v))
+		mmdbControl(buf, geoTypeUint16, len(b))
+		buf.Write(b)
+	case mmdbUint32:
+		b := mmdbUintBytes(uint64(v))
+		mmdbControl(buf, geoTypeUint32, len(b))
+		buf.Write(b)
+	case uint64:
+		b := mmdbUintBytes(v)
+		mmdbControl(buf, geoTypeUint64, len(b))
+		buf.Write(b)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		mmdbControl(buf, geoTypeBool, size)
+	case []any:
+		mmdbControl(buf, geoTypeArray, len(v))
+		for _, item := range v {
+			mmdbEncode(buf, item)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		mmdbControl(buf, geoTypeMap, len(v))
+		for _, k := range keys {
+			mmdbEncode(buf, k)
+			mmdbEncode(buf, v[k])
+		}
+	default:
+		panic("unsupported test value")
+	}
+}
+
+func mmdbRecordValue(child any, nodeIndex map[*mmdbNode]int, nodeCount int) uint {
+	switch c := child.(type) {
+	case *mmdbNode:
+		return uint(nodeIndex[c])
+	case mmdbDataRef:
+		return uint(nodeCount) + geoDataSeparator + uint(c)
+	}
+	return uint(nodeCount)
+}
+
+// writeMMDB builds a database with the given record size and IP version.
+// shared is written at the start of the data section so records can point
+// at it with mmdbPointer(0).
+func writeMMDB(t *testing.T, recordSize, ipVersion int, shared any, networks []mmdbNetwork) []byte {
+	t.Helper()
+
+	data := &bytes.Buffer{}
+	if shared != nil {
+		mmdbEncode(data, shared)
+	}
+
+	root := &mmdbNode{}
+	for _, n := range networks {
+		prefix := netip.MustParsePrefix(n.prefix)
+		var key []byte
+		bits := prefix.Bits()
+		switch {
+		case prefix.Addr().Is4() && ipVersion == 6:
+			a := prefix.Addr().As4()
+			key = append(make([]byte, 12), a[:]...)
+			bits += 96
+		case prefix.Addr().Is4():
+			a := prefix.Addr().As4()
+			key = a[:]
+		default:
+			a := prefix.Addr().As16()
+			key = a[:]
+		}
+
+		ref := mmdbDataRef(data.Len())
+		mmdbEncode(data, n.data)
+
+		node := root
+		for i := 0; i < bits; i++ {
+			bit := key[i/8] >> (7 - uint(i%8)) & 1
+			if i == bits-1 {
+				node.child[bit] = ref
+				break
+			}
+			next, ok := node.child[bit].(*mmdbNode)
+			if !ok {
+				next = &mmdbNode{}
+				node.child[bit] = next
+			}
+			node = next
+		}
+	}
+
+	nodes := []*mmdbNode{root}
+	nodeIndex := map[*mmdbNode]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, child := range nodes[i].child {
+			if c, ok := child.(*mmdbNode); ok {
+				nodeIndex[c] = len(nodes)
+				nodes = append(nodes, c)
+			}
+		}
+	}
+
+	file := &bytes.Buffer{}
+	for _, node := range nodes {
+		left := mmdbRecordValue(node.child[0], nodeIndex, len(nodes))
+		right := mmdbRecordValue(node.child[1], nodeIndex, len(nodes))
+		switch recordSize {
+		case 24:
+			file.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			file.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(left>>24)<<4 | byte(right>>24)&0x0F, byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			_ = binary.Write(file, binary.BigEndian, uint32(left))
+			_ = binary.Write(file, binary.BigEndian, uint32(right))
+		}
+	}
+	file.Write(make([]byte, geoDataSeparator))
+	file.Write(data.Bytes())
+	file.Write(geoMetadataMarker)
+	mmdbEncode(file, map[string]any{
+		"node_count":                  mmdbUint32(len(nodes)),
+		"record_size":                 mmdbUint16(recordSize),
+		"ip_version":                  mmdbUint16(ipVersion),
+		"database_type":               "GeoLite2-City",
+		"languages":                   []any{"en"},
+		"binary_format_major_version": mmdbUint16(2),
+		"binary_format_minor_version": mmdbUint16(0),
+		"build_epoch":                 uint64(1760000000),
+		"description":                 map[string]any{"en": "test database"},
+	})
+
+	return file.Bytes()
+}
+
+func geoFixture(t *testing.T, recordSize, ipVersion int) []byte {
+	networks := []mmdbNetwork{
+		{prefix: "81.2.69.0/24", data: map[string]any{
+			"country":      map[string]any{"iso_code": "GB", "names": mmdbPointer(0)},
+			"subdivisions": []any{map[string]any{"iso_code": "ENG"}, map[string]any{"iso_code": "LND"}},
+			"location":     map[string]any{"latitude": 51.5142, "longitude": -0.0931},
+		}},
+		{prefix: "175.176.0.0/16", data: map[string]any{
+			"country": map[string]any{"iso_code": "PH"},
+		}},
+	}
+	if ipVersion == 6 {
+		networks = append(networks, mmdbNetwork{prefix: "2001:db8::/32", data: map[string]any{
+			"country": map[string]any{"iso_code": "JP"},
+		}})
+	}
+
+	return writeMMDB(t, recordSize, ipVersion, map[string]any{"en": "United Kingdom"}, networks)
+}
+
+func TestGeoDBLocation(t *testing.T) {
+	testCases := []struct {
+		ip             string
+		expectedResult geoLocation
+	}{
+		{ip: "81.2.69.142", expectedResult: geoLocation{Country: "GB", Region: "ENG"}},
+		{ip: "::ffff:81.2.69.1", expectedResult: geoLocation{Country: "GB", Region: "ENG"}},
+		{ip: "175.176.12.34", expectedResult: geoLocation{Country: "PH"}},
+		{ip: "81.2.70.1", expectedResult: geoLocation{}},
+		{ip: "10.0.0.1", expectedResult: geoLocation{}},
+	}
+
+	for _, recordSize := range []int{24, 28, 32} {
+		for _, ipVersion := range []int{4, 6} {
+			db, err := parseGeoDB(geoFixture(t, recordSize, ipVersion))
+			if err != nil {
+				t.Fatalf("record size %d, ip version %d: unexpected error: %v", recordSize, ipVersion, err)
+			}
+
+			for _, tc := range testCases {
+				result, err := db.location(netip.MustParseAddr(tc.ip))
+				if err != nil {
+					t.Fatalf("record size %d, ip version %d, %s: unexpected error: %v", recordSize, ipVersion, tc.ip, err)
+				}
+				if result != tc.expectedResult {
+					t.Errorf("record size %d, ip version %d, %s: expected %+v, got %+v", recordSize, ipVersion, tc.ip, tc.expectedResult, result)
+				}
+			}
+		}
+	}
+}
+
+func TestGeoDBLookup(t *testing.T) {
+	db, err := parseGeoDB(geoFixture(t, 28, 6))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	value, found, err := db.lookup(netip.MustParseAddr("2001:db8::1"))
+	if err != nil || !found {
+		t.Fatalf("expected a record, got %v, %v", found, err)
+	}
+	if value.(map[string]any)["country"].(map[string]any)["iso_code"] != "JP" {
+		t.Errorf("unexpected record %v", value)
+	}
+
+	value, _, _ = db.lookup(netip.MustParseAddr("81.2.69.1"))
+	record := value.(map[string]any)
+	if names := record["country"].(map[string]any)["names"].(map[string]any); names["en"] != "United Kingdom" {
+		t.Errorf("expected pointer to shared names, got %v", names)
+	}
+	if lat := record["location"].(map[string]any)["latitude"]; lat != 51.5142 {
+		t.Errorf("expected latitude 51.5142, got %v", lat)
+	}
+	if db.metadata["database_type"] != "GeoLite2-City" || db.metadata["build_epoch"] != uint64(1760000000) {
+		t.Errorf("unexpected metadata %v", db.metadata)
+	}
+
+	v4, _ := parseGeoDB(geoFixture(t, 24, 4))
+	if _, _, err := v4.lookup(netip.MustParseAddr("2001:db8::1")); err == nil {
+		t.Errorf("expected error for ipv6 lookup in ipv4 database, got none")
+	}
+}
+
+func TestOpenGeoDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	if err := os.WriteFile(path, geoFixture(t, 24, 6), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	db, err := openGeoDB(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loc, _ := db.location(netip.MustParseAddr("175.176.1.1")); loc.Country != "PH" {
+		t.Errorf("expected PH, got %+v", loc)
+	}
+
+	if _, err := openGeoDB(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Errorf("expected error for missing file, got none")
+	}
+}
+
+func TestParseGeoDBInvalid(t *testing.T) {
+	valid := geoFixture(t, 24, 6)
+	marker := bytes.LastIndex(valid, geoMetadataMarker)
+
+	testCases := []struct {
+		name string
+		buf  []byte
+	}{
+		{name: "parse geo db error - no marker", buf: valid[:marker]},
+		{name: "parse geo db error - truncated metadata", buf: valid[:marker+len(geoMetadataMarker)+3]},
+		{name: "parse geo db error - bad record size", buf: writeMetadataOnly(map[string]any{"node_count": mmdbUint32(0), "record_size": mmdbUint16(20), "ip_version": mmdbUint16(6)})},
+		{name: "parse geo db error - tree larger than file", buf: writeMetadataOnly(map[string]any{"node_count": mmdbUint32(1000), "record_size": mmdbUint16(24), "ip_version": mmdbUint16(6)})},
+		{name: "parse geo db error - missing node count", buf: writeMetadataOnly(map[string]any{"record_size": mmdbUint16(24), "ip_version": mmdbUint16(6)})},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseGeoDB(tc.buf); !errors.Is(err, ErrInvalidGeoDB) {
+				t.Errorf("expected %v, got %v", ErrInvalidGeoDB, err)
+			}
+		})
+	}
+}
+
+func writeMetadataOnly(metadata map[string]any) []byte {
+	buf := bytes.NewBuffer(make([]byte, geoDataSeparator))
+	buf.Write(geoMetadataMarker)
+	mmdbEncode(buf, metadata)
+	return buf.Bytes()
+}
+
+func TestGeoDBRecord28(t *testing.T) {
+	db := &geoDB{buf: []byte{0x12, 0x34, 0x56, 0xAB, 0x78, 0x9A, 0xBC}, recordSize: 28}
+
+	if left := db.record(0, 0); left != 0xA123456 {
+		t.Errorf("expected left %#x, got %#x", 0xA123456, left)
+	}
+	if right := db.record(0, 1); right != 0xB789ABC {
+		t.Errorf("expected right %#x, got %#x", 0xB789ABC, right)
+	}
+}
+
+func TestGeoDecoder(t *testing.T) {
+	long := strings.Repeat("x", 300)
+
+	far := &bytes.Buffer{}
+	far.Write([]byte{0x28, 0x00, 0x34})
+	far.Write(make([]byte, 2100-far.Len()))
+	mmdbEncode(far, "far")
+
+	testCases := []struct {
+		name           string
+		buf            []byte
+		expectedResult any
+	}{
+		{name: "decode long string", buf: encodeValue(long), expectedResult: long},
+		{name: "decode uint64", buf: encodeValue(uint64(1) << 40), expectedResult: uint64(1) << 40},
+		{name: "decode bool", buf: encodeValue(true), expectedResult: true},
+		{name: "decode int32", buf: []byte{0x04, 0x01, 0xFF, 0xFF, 0xFF, 0xFE}, expectedResult: int64(-2)},
+		{name: "decode far pointer", buf: far.Bytes(), expectedResult: "far"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, _, err := (&geoDecoder{buf: tc.buf}).decode(0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != tc.expectedResult {
+				t.Errorf("expected %v, got %v", tc.expectedResult, result)
+			}
+		})
+	}
+
+	for _, buf := range [][]byte{{0x20, 0x00}, {0x43, 'a'}, {0xE1}} {
+		if _, _, err := (&geoDecoder{buf: buf}).decode(0); err == nil {
+			t.Errorf("expected error decoding %x, got none", buf)
+		}
+	}
+}
+
+func encodeValue(v any) []byte {
+	buf := &bytes.Buffer{}
+	mmdbEncode(buf, v)
+	return buf.Bytes()
+}
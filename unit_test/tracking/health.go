@@ -0,0 +1,330 @@
+package trackingimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInvalidHealthInterval = errors.New("health check interval must be positive")
+	ErrBlockedAddress        = errors.New("destination address is not public")
+)
+
+const (
+	healthMaxRedirects = 10
+	healthWorkers      = 8
+	healthUserAgent    = "TrackingHealthCheck/1.0"
+)
+
+// healthTarget is an active tracking link. Every URL it can send visitors
+// to is checked: the destination, or the rotation variants that replace
+// it, and the fallback URL.
+type healthTarget struct {
+	TrackingID  int64
+	Destination string
+	Variants    []destinationVariant
+	FallbackURL string
+}
+
+// healthURL is one URL of a healthTarget. Variant is the rotation variant
+// name, empty for the destination and the fallback.
+type healthURL struct {
+	URL      string
+	Variant  string
+	Fallback bool
+}
+
+func (t *healthTarget) urls() []healthURL {
+	urls := make([]healthURL, 0, len(t.Variants)+2)
+	if len(t.Variants) > 0 {
+		for _, v := range t.Variants {
+			urls = append(urls, healthURL{URL: v.URL, Variant: v.Variant})
+		}
+	} else {
+		urls = append(urls, healthURL{URL: t.Destination})
+	}
+	if t.FallbackURL != "" {
+		urls = append(urls, healthURL{URL: t.FallbackURL, Fallback: true})
+	}
+
+	return urls
+}
+
+// healthResult is one check of a URL of a tracking link. Chain lists every
+// URL requested, the checked one first; StatusCode is that of the last.
+type healthResult struct {
+	TrackingID int64
+	Variant    string
+	Fallback   bool
+	StatusCode int
+	Latency    time.Duration
+	Chain      []string
+	Broken     bool
+	Reason     string
+	CheckedAt  time.Time
+}
+
+type healthStore interface {
+	getActiveTrackings(ctx context.Context) ([]*healthTarget, error)
+	saveHealthCheck(ctx context.Context, r *healthResult) error
+}
+
+// healthChecker requests destinations through client, following redirects
+// itself so each hop is recorded. Connections are only made to public
+// addresses, so a destination or redirect cannot reach internal services;
+// allow lists the ranges that may be reached anyway, which tests use for
+// the loopback address of a local server.
+type healthChecker struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+func newHealthChecker(client *http.Client, allow ...netip.Prefix) *healthChecker {
+	c := *client
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	transport, ok := c.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	// The check is made at connect time, after DNS, so a proxy would hide
+	// the address being reached.
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			return checkPublicAddress(address, allow)
+		},
+	}).DialContext
+	c.Transport = transport
+
+	return &healthChecker{client: &c, now: time.Now}
+}
+
+// checkPublicAddress rejects loopback, private, link-local and other
+// non-public addresses that are not in allow.
+func checkPublicAddress(address string, allow []netip.Prefix) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+
+	for _, p := range allow {
+		if p.Contains(addr) {
+			return nil
+		}
+	}
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, addr)
+	}
+
+	return nil
+}
+
+// check checks every URL of target. The results share one CheckedAt.
+func (c *healthChecker) check(ctx context.Context, target *healthTarget) []*healthResult {
+	checkedAt := c.now()
+
+	urls := target.urls()
+	results := make([]*healthResult, 0, len(urls))
+	for _, u := range urls {
+		results = append(results, c.checkURL(ctx, target.TrackingID, u, checkedAt))
+	}
+
+	return results
+}
+
+// checkURL requests u and follows up to healthMaxRedirects redirects. The
+// URL is broken when a request fails, the final status is 400 or above, or
+// the redirects loop or run too long.
+func (c *healthChecker) checkURL(ctx context.Context, trackingID int64, u healthURL, checkedAt time.Time) *healthResult {
+	result := &healthResult{TrackingID: trackingID, Variant: u.Variant, Fallback: u.Fallback, CheckedAt: checkedAt}
+	start := time.Now()
+	defer func() { result.Latency = time.Since(start) }()
+
+	seen := map[string]bool{}
+	next := u.URL
+	for {
+		if seen[next] {
+			result.Broken, result.Reason = true, "redirect loop"
+			return result
+		}
+		if len(result.Chain) > healthMaxRedirects {
+			result.Broken, result.Reason = true, "too many redirects"
+			return result
+		}
+		seen[next] = true
+		result.Chain = append(result.Chain, next)
+
+		location, status, err := c.request(ctx, next)
+		if err != nil {
+			result.Broken, result.Reason = true, err.Error()
+			return result
+		}
+		result.StatusCode = status
+
+		if location == "" {
+			if status >= http.StatusBadRequest {
+				result.Broken, result.Reason = true, http.StatusText(status)
+			}
+			return result
+		}
+		next = location
+	}
+}
+
+// request makes one GET and returns the resolved Location of a redirect.
+func (c *healthChecker) request(ctx context.Context, raw string) (string, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("User-Agent", healthUserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	// Only the status matters; the body is not read.
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 || resp.StatusCode == http.StatusNotModified {
+		return "", resp.StatusCode, nil
+	}
+
+	location, err := resp.Location()
+	if errors.Is(err, http.ErrNoLocation) {
+		return "", resp.StatusCode, fmt.Errorf("redirect %d without location", resp.StatusCode)
+	} else if err != nil {
+		return "", resp.StatusCode, err
+	}
+
+	return location.String(), resp.StatusCode, nil
+}
+
+// healthJob checks every active tracking link and saves the results.
+type healthJob struct {
+	store   healthStore
+	checker *healthChecker
+}
+
+// run checks the links with healthWorkers links in flight and returns how
+// many had a broken URL.
+func (j *healthJob) run(ctx context.Context) (int, error) {
+	targets, err := j.store.getActiveTrackings(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	results := make([][]*healthResult, len(targets))
+	sem := make(chan struct{}, healthWorkers)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = j.checker.check(ctx, target)
+		}()
+	}
+	wg.Wait()
+
+	broken := 0
+	for _, checks := range results {
+		linkBroken := false
+		for _, r := range checks {
+			if err := j.store.saveHealthCheck(ctx, r); err != nil {
+				return broken, err
+			}
+			linkBroken = linkBroken || r.Broken
+		}
+		if linkBroken {
+			broken++
+		}
+	}
+
+	return broken, nil
+}
+
+// start runs the job every interval until ctx is done, passing errors to
+// onError. It returns ErrInvalidHealthInterval for an interval that is not
+// positive and nil once ctx is done.
+func (j *healthJob) start(ctx context.Context, interval time.Duration, onError func(error)) error {
+	if interval <= 0 {
+		return ErrInvalidHealthInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := j.run(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// trackingHealth is the health column of a tracking search row.
+type trackingHealth struct {
+	Broken     bool
+	StatusCode int
+	Reason     string
+	CheckedAt  time.Time
+}
+
+// latestHealth keeps the newest check of each tracking link. Of the URLs
+// checked together, a broken one wins, so a link is broken when any of
+// its URLs is.
+func latestHealth(results []*healthResult) map[int64]trackingHealth {
+	latest := map[int64]trackingHealth{}
+	for _, r := range results {
+		if prev, ok := latest[r.TrackingID]; ok && (r.CheckedAt.Before(prev.CheckedAt) ||
+			(r.CheckedAt.Equal(prev.CheckedAt) && (prev.Broken || !r.Broken))) {
+			continue
+		}
+		latest[r.TrackingID] = trackingHealth{Broken: r.Broken, StatusCode: r.StatusCode, Reason: r.Reason, CheckedAt: r.CheckedAt}
+	}
+
+	return latest
+}
+
+type healthSearchRow struct {
+	TrackingID int64
+	// Health is nil for links that have not been checked yet.
+	Health *trackingHealth
+}
+
+// annotateSearch attaches the latest health to tracking search results,
+// keeping only broken links when brokenOnly is set.
+func annotateSearch(trackingIDs []int64, latest map[int64]trackingHealth, brokenOnly bool) []*healthSearchRow {
+	rows := make([]*healthSearchRow, 0, len(trackingIDs))
+	for _, id := range trackingIDs {
+		row := &healthSearchRow{TrackingID: id}
+		if h, ok := latest[id]; ok {
+			row.Health = &h
+		}
+		if brokenOnly && (row.Health == nil || !row.Health.Broken) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
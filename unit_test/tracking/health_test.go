@@ -0,0 +1,315 @@
+package trackingimpl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeHealthStore struct {
+	mu      sync.Mutex
+	targets []*healthTarget
+	saved   []*healthResult
+	err     error
+}
+
+func (f *fakeHealthStore) getActiveTrackings(context.Context) ([]*healthTarget, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.targets, nil
+}
+
+func (f *fakeHealthStore) saveHealthCheck(_ context.Context, r *healthResult) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.saved = append(f.saved, r)
+	return nil
+}
+
+// loopback lets the checker reach the local test server.
+var loopback = netip.MustParsePrefix("127.0.0.0/8")
+
+// newHealthServer serves the destinations the checker is pointed at.
+func newHealthServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/campaign", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/campaign", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/moved-gone", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/missing", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/no-location", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestHealthCheckerCheck(t *testing.T) {
+	srv := newHealthServer(t)
+
+	testCases := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedChain  []string
+		expectedBroken bool
+	}{
+		{name: "check success - 200", path: "/ok", expectedStatus: http.StatusOK, expectedChain: []string{"/ok"}},
+		{name: "check success - redirect chain", path: "/moved", expectedStatus: http.StatusOK, expectedChain: []string{"/moved", "/campaign", "/ok"}},
+		{name: "check broken - 404", path: "/missing", expectedStatus: http.StatusNotFound, expectedChain: []string{"/missing"}, expectedBroken: true},
+		{name: "check broken - redirect to 404", path: "/moved-gone", expectedStatus: http.StatusNotFound, expectedChain: []string{"/moved-gone", "/missing"}, expectedBroken: true},
+		{name: "check broken - redirect loop", path: "/loop", expectedStatus: http.StatusFound, expectedChain: []string{"/loop"}, expectedBroken: true},
+		{name: "check broken - redirect without location", path: "/no-location", expectedChain: []string{"/no-location"}, expectedBroken: true},
+	}
+
+	checker := newHealthChecker(srv.Client(), loopback)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := checker.checkURL(context.Background(), 7, healthURL{URL: srv.URL + tc.path}, time.Now())
+
+			expectedChain := make([]string, len(tc.expectedChain))
+			for i, p := range tc.expectedChain {
+				expectedChain[i] = srv.URL + p
+			}
+
+			if result.TrackingID != 7 || result.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, result.StatusCode)
+			}
+			if !reflect.DeepEqual(result.Chain, expectedChain) {
+				t.Errorf("expected chain %v, got %v", expectedChain, result.Chain)
+			}
+			if result.Broken != tc.expectedBroken || (result.Broken && result.Reason == "") {
+				t.Errorf("expected broken %v, got %v %q", tc.expectedBroken, result.Broken, result.Reason)
+			}
+			if result.Latency <= 0 {
+				t.Errorf("expected latency to be recorded, got %v", result.Latency)
+			}
+		})
+	}
+}
+
+func TestHealthCheckerLatencyAndTimeout(t *testing.T) {
+	srv := newHealthServer(t)
+
+	result := newHealthChecker(srv.Client(), loopback).checkURL(context.Background(), 0, healthURL{URL: srv.URL + "/slow"}, time.Now())
+	if result.Broken || result.Latency < 20*time.Millisecond {
+		t.Errorf("expected a healthy check of at least 20ms, got %+v", result)
+	}
+
+	client := srv.Client()
+	client.Timeout = 5 * time.Millisecond
+	result = newHealthChecker(client, loopback).checkURL(context.Background(), 0, healthURL{URL: srv.URL + "/slow"}, time.Now())
+	if !result.Broken || result.StatusCode != 0 {
+		t.Errorf("expected a timed out check to be broken, got %+v", result)
+	}
+	if client.CheckRedirect != nil || client.Transport != srv.Client().Transport {
+		t.Errorf("expected the caller's client to be left alone")
+	}
+}
+
+func TestHealthCheckerCheckTargetURLs(t *testing.T) {
+	srv := newHealthServer(t)
+
+	target := &healthTarget{
+		TrackingID:  7,
+		Destination: srv.URL + "/missing",
+		Variants: []destinationVariant{
+			{Variant: "a", URL: srv.URL + "/ok", Weight: 1},
+			{Variant: "b", URL: srv.URL + "/moved-gone", Weight: 1},
+		},
+		FallbackURL: srv.URL + "/moved",
+	}
+	results := newHealthChecker(srv.Client(), loopback).check(context.Background(), target)
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	expected := []struct {
+		variant  string
+		fallback bool
+		broken   bool
+	}{{"a", false, false}, {"b", false, true}, {"", true, false}}
+	for i, e := range expected {
+		r := results[i]
+		if r.TrackingID != 7 || r.Variant != e.variant || r.Fallback != e.fallback || r.Broken != e.broken {
+			t.Errorf("expected variant %q fallback %v broken %v, got %+v", e.variant, e.fallback, e.broken, r)
+		}
+		if !r.CheckedAt.Equal(results[0].CheckedAt) {
+			t.Errorf("expected one check time, got %v and %v", results[0].CheckedAt, r.CheckedAt)
+		}
+	}
+}
+
+func TestHealthCheckerBlocksPrivateAddresses(t *testing.T) {
+	srv := newHealthServer(t)
+
+	result := newHealthChecker(srv.Client()).checkURL(context.Background(), 7, healthURL{URL: srv.URL + "/ok"}, time.Now())
+	if !result.Broken || result.StatusCode != 0 {
+		t.Errorf("expected a loopback destination to be blocked, got %+v", result)
+	}
+
+	testCases := []struct {
+		name          string
+		address       string
+		expectedError bool
+	}{
+		{name: "public address success - ipv4", address: "93.184.216.34:443"},
+		{name: "public address success - ipv6", address: "[2606:2800:220:1::1]:443"},
+		{name: "public address error - loopback", address: "127.0.0.1:80", expectedError: true},
+		{name: "public address error - private", address: "10.1.2.3:80", expectedError: true},
+		{name: "public address error - link local", address: "169.254.169.254:80", expectedError: true},
+		{name: "public address error - unspecified", address: "0.0.0.0:80", expectedError: true},
+		{name: "public address error - ipv6 unique local", address: "[fd00::1]:80", expectedError: true},
+		{name: "public address error - mapped loopback", address: "[::ffff:127.0.0.1]:80", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkPublicAddress(tc.address, nil)
+			if tc.expectedError != errors.Is(err, ErrBlockedAddress) {
+				t.Errorf("expected blocked %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestHealthJobRun(t *testing.T) {
+	srv := newHealthServer(t)
+	store := &fakeHealthStore{targets: []*healthTarget{
+		{TrackingID: 1, Destination: srv.URL + "/ok"},
+		{TrackingID: 2, Destination: srv.URL + "/missing"},
+		{TrackingID: 3, Destination: srv.URL + "/moved"},
+		{TrackingID: 4, Destination: "://bad"},
+	}}
+
+	job := &healthJob{store: store, checker: newHealthChecker(srv.Client(), loopback)}
+	broken, err := job.run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if broken != 2 {
+		t.Errorf("expected 2 broken, got %d", broken)
+	}
+	if len(store.saved) != 4 {
+		t.Fatalf("expected 4 results saved, got %d", len(store.saved))
+	}
+	for i, r := range store.saved {
+		if r.TrackingID != int64(i+1) {
+			t.Errorf("expected results in target order, got tracking %d at %d", r.TrackingID, i)
+		}
+	}
+
+	store.err = errors.New("test error")
+	if _, err := job.run(context.Background()); err == nil {
+		t.Errorf("expected %v, got none", store.err)
+	}
+}
+
+func TestHealthJobRunBrokenVariant(t *testing.T) {
+	srv := newHealthServer(t)
+	store := &fakeHealthStore{targets: []*healthTarget{
+		{TrackingID: 1, Variants: []destinationVariant{
+			{Variant: "a", URL: srv.URL + "/ok", Weight: 1},
+			{Variant: "b", URL: srv.URL + "/missing", Weight: 1},
+		}},
+		{TrackingID: 2, Destination: srv.URL + "/ok", FallbackURL: srv.URL + "/missing"},
+		{TrackingID: 3, Destination: srv.URL + "/ok", FallbackURL: srv.URL + "/ok"},
+	}}
+
+	job := &healthJob{store: store, checker: newHealthChecker(srv.Client(), loopback)}
+	broken, err := job.run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if broken != 2 || len(store.saved) != 6 {
+		t.Errorf("expected 2 broken links of 6 results, got %d of %d", broken, len(store.saved))
+	}
+
+	latest := latestHealth(store.saved)
+	if !latest[1].Broken || !latest[2].Broken || latest[3].Broken {
+		t.Errorf("expected links 1 and 2 broken, got %+v", latest)
+	}
+}
+
+func TestHealthJobStartInterval(t *testing.T) {
+	job := &healthJob{store: &fakeHealthStore{}, checker: newHealthChecker(http.DefaultClient)}
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := job.start(context.Background(), interval, nil); !errors.Is(err, ErrInvalidHealthInterval) {
+			t.Errorf("expected %v for %v, got %v", ErrInvalidHealthInterval, interval, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := job.start(ctx, time.Hour, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAnnotateSearch(t *testing.T) {
+	day := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	latest := latestHealth([]*healthResult{
+		{TrackingID: 1, StatusCode: 404, Broken: true, Reason: "Not Found", CheckedAt: day},
+		{TrackingID: 1, StatusCode: 200, CheckedAt: day.Add(time.Hour)},
+		{TrackingID: 2, StatusCode: 200, CheckedAt: day.Add(time.Hour)},
+		{TrackingID: 2, StatusCode: 404, Broken: true, Reason: "Not Found", CheckedAt: day.Add(2 * time.Hour)},
+	})
+
+	testCases := []struct {
+		name           string
+		brokenOnly     bool
+		expectedResult []*healthSearchRow
+	}{
+		{
+			name: "annotate search - all",
+			expectedResult: []*healthSearchRow{
+				{TrackingID: 1, Health: &trackingHealth{StatusCode: 200, CheckedAt: day.Add(time.Hour)}},
+				{TrackingID: 2, Health: &trackingHealth{Broken: true, StatusCode: 404, Reason: "Not Found", CheckedAt: day.Add(2 * time.Hour)}},
+				{TrackingID: 3},
+			},
+		},
+		{
+			name:       "annotate search - broken only",
+			brokenOnly: true,
+			expectedResult: []*healthSearchRow{
+				{TrackingID: 2, Health: &trackingHealth{Broken: true, StatusCode: 404, Reason: "Not Found", CheckedAt: day.Add(2 * time.Hour)}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := annotateSearch([]int64{1, 2, 3}, latest, tc.brokenOnly)
+			if !reflect.DeepEqual(result, tc.expectedResult) {
+				t.Errorf("expected %+v, got %+v", tc.expectedResult, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package trackingimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"time"
+)
+
+var ErrInvalidLifecycle = errors.New("invalid tracking link lifecycle")
+
+type lifecycleState string
+
+const (
+	lifecycleScheduled lifecycleState = "scheduled"
+	lifecycleActive    lifecycleState = "active"
+	lifecyclePaused    lifecycleState = "paused"
+	lifecycleExpired   lifecycleState = "expired"
+)
+
+// trackingLifecycle is when a tracking link redirects to its destination.
+// A zero StartAt or EndAt leaves that side of the window open.
+type trackingLifecycle struct {
+	StartAt time.Time
+	EndAt   time.Time
+	Paused  bool
+	// FallbackURL receives the traffic while the link is not active.
+	FallbackURL string
+}
+
+func (l trackingLifecycle) validate() error {
+	if !l.StartAt.IsZero() && !l.EndAt.IsZero() && !l.EndAt.After(l.StartAt) {
+		return fmt.Errorf("%w: end must be after start", ErrInvalidLifecycle)
+	}
+	if l.FallbackURL != "" {
+		u, err := url.Parse(l.FallbackURL)
+		if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("%w: bad fallback url %q", ErrInvalidLifecycle, l.FallbackURL)
+		}
+	}
+
+	return nil
+}
+
+// state is the link's state at now. Pausing wins over the window, and the
+// window is [StartAt, EndAt).
+func (l trackingLifecycle) state(now time.Time) lifecycleState {
+	switch {
+	case l.Paused:
+		return lifecyclePaused
+	case !l.StartAt.IsZero() && now.Before(l.StartAt):
+		return lifecycleScheduled
+	case !l.EndAt.IsZero() && !now.Before(l.EndAt):
+		return lifecycleExpired
+	default:
+		return lifecycleActive
+	}
+}
+
+// lifecycleLog is the tracking log entry of a state change. ChangedBy is
+// empty when the change came from the window rather than an operator.
+type lifecycleLog struct {
+	TrackingID int64
+	From       lifecycleState
+	To         lifecycleState
+	ChangedBy  string
+	CreatedAt  time.Time
+}
+
+type lifecycleStore interface {
+	getLifecycle(ctx context.Context, trackingID int64) (*trackingLifecycle, error)
+	updateLifecycle(ctx context.Context, trackingID int64, l *trackingLifecycle) error
+	createLog(ctx context.Context, log *lifecycleLog) error
+}
+
+// updateTrackingLifecycle saves the new window, pause flag and fallback and
+// logs the state change when the update causes one.
+func updateTrackingLifecycle(ctx context.Context, store lifecycleStore, trackingID int64, next *trackingLifecycle, changedBy string, now time.Time) error {
+	if err := next.validate(); err != nil {
+		return err
+	}
+
+	prev, err := store.getLifecycle(ctx, trackingID)
+	if err != nil {
+		return err
+	}
+
+	if err := store.updateLifecycle(ctx, trackingID, next); err != nil {
+		return err
+	}
+
+	from, to := prev.state(now), next.state(now)
+	if from == to {
+		return nil
+	}
+
+	return store.createLog(ctx, &lifecycleLog{
+		TrackingID: trackingID,
+		From:       from,
+		To:         to,
+		ChangedBy:  changedBy,
+		CreatedAt:  now,
+	})
+}
+
+// logWindowTransitions logs the state changes caused by a start or end time
+// passing in (since, now]. It is run periodically with since set to the
+// previous run, so each transition is logged once.
+func logWindowTransitions(ctx context.Context, store lifecycleStore, links map[int64]*trackingLifecycle, since, now time.Time) (int, error) {
+	logged := 0
+	for _, trackingID := range slices.Sorted(maps.Keys(links)) {
+		l := links[trackingID]
+		if l.Paused {
+			continue
+		}
+
+		for _, at := range []time.Time{l.StartAt, l.EndAt} {
+			if at.IsZero() || !at.After(since) || at.After(now) {
+				continue
+			}
+
+			from, to := l.state(at.Add(-time.Nanosecond)), l.state(at)
+			if from == to {
+				continue
+			}
+			if err := store.createLog(ctx, &lifecycleLog{TrackingID: trackingID, From: from, To: to, CreatedAt: at}); err != nil {
+				return logged, err
+			}
+			logged++
+		}
+	}
+
+	return logged, nil
+}
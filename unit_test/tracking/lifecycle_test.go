@@ -0,0 +1,228 @@
+package trackingimpl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeLifecycleStore struct {
+	links     map[int64]*trackingLifecycle
+	logs      []*lifecycleLog
+	err       error
+	updateErr error
+}
+
+func (f *fakeLifecycleStore) getLifecycle(_ context.Context, trackingID int64) (*trackingLifecycle, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	l, ok := f.links[trackingID]
+	if !ok {
+		return nil, ErrTrackingNotFound
+	}
+	return l, nil
+}
+
+func (f *fakeLifecycleStore) updateLifecycle(_ context.Context, trackingID int64, l *trackingLifecycle) error {
+	if f.updateErr != nil {
+		return f.updateErr
+	}
+	f.links[trackingID] = l
+	return nil
+}
+
+func (f *fakeLifecycleStore) createLog(_ context.Context, log *lifecycleLog) error {
+	f.logs = append(f.logs, log)
+	return nil
+}
+
+func TestTrackingLifecycleState(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		lifecycle     trackingLifecycle
+		now           time.Time
+		expectedState lifecycleState
+	}{
+		{name: "state - open window", now: start, expectedState: lifecycleActive},
+		{name: "state - before start", lifecycle: trackingLifecycle{StartAt: start, EndAt: end}, now: start.Add(-time.Second), expectedState: lifecycleScheduled},
+		{name: "state - at start", lifecycle: trackingLifecycle{StartAt: start, EndAt: end}, now: start, expectedState: lifecycleActive},
+		{name: "state - just before end", lifecycle: trackingLifecycle{StartAt: start, EndAt: end}, now: end.Add(-time.Second), expectedState: lifecycleActive},
+		{name: "state - at end", lifecycle: trackingLifecycle{StartAt: start, EndAt: end}, now: end, expectedState: lifecycleExpired},
+		{name: "state - paused inside window", lifecycle: trackingLifecycle{StartAt: start, EndAt: end, Paused: true}, now: start.Add(time.Hour), expectedState: lifecyclePaused},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if state := tc.lifecycle.state(tc.now); state != tc.expectedState {
+				t.Errorf("expected %v, got %v", tc.expectedState, state)
+			}
+		})
+	}
+}
+
+func TestUpdateTrackingLifecycle(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		next          *trackingLifecycle
+		updateErr     error
+		expectedError error
+		expectedLogs  []*lifecycleLog
+	}{
+		{
+			name:         "update lifecycle success - paused",
+			next:         &trackingLifecycle{Paused: true, FallbackURL: "https://brand.example/"},
+			expectedLogs: []*lifecycleLog{{TrackingID: 7, From: lifecycleActive, To: lifecyclePaused, ChangedBy: "ops@example.com", CreatedAt: now}},
+		},
+		{
+			name:         "update lifecycle success - ended",
+			next:         &trackingLifecycle{EndAt: now.Add(-time.Hour)},
+			expectedLogs: []*lifecycleLog{{TrackingID: 7, From: lifecycleActive, To: lifecycleExpired, ChangedBy: "ops@example.com", CreatedAt: now}},
+		},
+		{
+			name: "update lifecycle success - no state change",
+			next: &trackingLifecycle{EndAt: now.Add(time.Hour), FallbackURL: "https://brand.example/"},
+		},
+		{
+			name:          "update lifecycle error - end before start",
+			next:          &trackingLifecycle{StartAt: now, EndAt: now.Add(-time.Hour)},
+			expectedError: ErrInvalidLifecycle,
+		},
+		{
+			name:          "update lifecycle error - bad fallback",
+			next:          &trackingLifecycle{FallbackURL: "ftp://brand.example/"},
+			expectedError: ErrInvalidLifecycle,
+		},
+		{
+			name:          "update lifecycle error",
+			next:          &trackingLifecycle{Paused: true},
+			updateErr:     errors.New("test error"),
+			expectedError: errors.New("test error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &fakeLifecycleStore{links: map[int64]*trackingLifecycle{7: {}}, updateErr: tc.updateErr}
+
+			err := updateTrackingLifecycle(context.Background(), store, 7, tc.next, "ops@example.com", now)
+			if err != nil && tc.expectedError == nil {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			} else if err == nil && tc.expectedError != nil {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if errors.Is(tc.expectedError, ErrInvalidLifecycle) && !errors.Is(err, ErrInvalidLifecycle) {
+				t.Errorf("expected %v, got %v", tc.expectedError, err)
+			}
+			if !reflect.DeepEqual(store.logs, tc.expectedLogs) {
+				t.Errorf("expected logs %+v, got %+v", tc.expectedLogs, store.logs)
+			}
+		})
+	}
+}
+
+func TestLogWindowTransitions(t *testing.T) {
+	since := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	now := since.Add(time.Hour)
+	store := &fakeLifecycleStore{}
+
+	links := map[int64]*trackingLifecycle{
+		1: {StartAt: since.Add(10 * time.Minute)},
+		2: {StartAt: since.Add(-time.Hour), EndAt: since.Add(30 * time.Minute)},
+		3: {EndAt: since},
+		4: {EndAt: now.Add(time.Minute)},
+		5: {StartAt: since.Add(5 * time.Minute), Paused: true},
+	}
+
+	logged, err := logWindowTransitions(context.Background(), store, links, since, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*lifecycleLog{
+		{TrackingID: 1, From: lifecycleScheduled, To: lifecycleActive, CreatedAt: since.Add(10 * time.Minute)},
+		{TrackingID: 2, From: lifecycleActive, To: lifecycleExpired, CreatedAt: since.Add(30 * time.Minute)},
+	}
+	if logged != 2 || !reflect.DeepEqual(store.logs, expected) {
+		t.Errorf("expected %+v, got %d %+v", expected, logged, store.logs)
+	}
+}
+
+func TestRedirectHandlerLifecycle(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name             string
+		lifecycle        trackingLifecycle
+		expectedStatus   int
+		expectedLocation string
+		expectedClicks   int
+	}{
+		{
+			name:             "redirect lifecycle - active",
+			lifecycle:        trackingLifecycle{StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), FallbackURL: "https://brand.example/fallback"},
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://brand.example/?click_id=c1",
+			expectedClicks:   1,
+		},
+		{
+			name:             "redirect lifecycle - paused with fallback",
+			lifecycle:        trackingLifecycle{Paused: true, FallbackURL: "https://brand.example/fallback"},
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://brand.example/fallback",
+		},
+		{
+			name:             "redirect lifecycle - expired with fallback",
+			lifecycle:        trackingLifecycle{EndAt: now, FallbackURL: "https://brand.example/fallback"},
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://brand.example/fallback",
+		},
+		{
+			name:           "redirect lifecycle - expired without fallback",
+			lifecycle:      trackingLifecycle{EndAt: now},
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:           "redirect lifecycle - scheduled without fallback",
+			lifecycle:      trackingLifecycle{StartAt: now.Add(time.Hour)},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resolver := &fakeResolver{targets: map[string]*redirectTarget{
+				"abc123": {TrackingID: 7, Destination: "https://brand.example/", Lifecycle: tc.lifecycle},
+			}}
+			sink := &fakeSink{}
+			handler, err := newRedirectHandler(redirectConfig{PathPrefix: "/t/"}, resolver, sink)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			handler.now = func() time.Time { return now }
+			handler.clickID = func() string { return "c1" }
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/t/abc123", nil))
+
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("expected status %v, got %v", tc.expectedStatus, rec.Code)
+			}
+			if location := rec.Header().Get("Location"); location != tc.expectedLocation {
+				t.Errorf("expected location %q, got %q", tc.expectedLocation, location)
+			}
+			if len(sink.events) != tc.expectedClicks {
+				t.Errorf("expected %d clicks, got %d", tc.expectedClicks, len(sink.events))
+			}
+		})
+	}
+}
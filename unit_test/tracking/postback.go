@@ -0,0 +1,215 @@
+package trackingimpl
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownClick        = errors.New("unknown click id")
+	ErrDuplicateConversion = errors.New("conversion already recorded")
+)
+
+type conversionType string
+
+const (
+	conversionRegistration conversionType = "registration"
+	conversionFirstDeposit conversionType = "ftd"
+)
+
+// clickRef is the tracking log row a click ID points at.
+type clickRef struct {
+	TrackingLogID int64
+	TrackingID    int64
+	AffiliateID   int64
+	Params        map[string]string
+	BannerID      int64
+	Variant       string
+	FlagReason    fraudReason
+}
+
+// conversion links an operator event to the click it came from. Amount is
+// in minor units and only set for deposits.
+type conversion struct {
+	DedupeKey     string
+	ClickID       string
+	Type          conversionType
+	EventID       string
+	Amount        int64
+	Currency      string
+	TrackingLogID int64
+	TrackingID    int64
+	AffiliateID   int64
+	// Params are the click's sub-ID and UTM parameters, copied so
+	// conversions can be reported by them without joining the click.
+	Params map[string]string
+	// BannerID is the banner the click came from, so banner stats can
+	// count conversions.
+	BannerID int64
+	Variant  string
+	// FlagReason is the fraud flag of the click, so conversions of flagged
+	// clicks are left out of reports with them.
+	FlagReason fraudReason
+	CreatedAt  time.Time
+}
+
+type conversionStore interface {
+	getClick(ctx context.Context, clickID string) (*clickRef, error)
+	// saveConversion returns ErrDuplicateConversion when a conversion with
+	// the same DedupeKey exists; the key is unique in the table.
+	saveConversion(ctx context.Context, c *conversion) error
+}
+
+// conversionDedupeKey allows one conversion of each type per click, so a
+// retried or replayed postback is counted once whatever its event ID.
+func conversionDedupeKey(clickID string, t conversionType) string {
+	return clickID + ":" + string(t)
+}
+
+// postbackHandler receives conversion postbacks from operator brands. The
+// shared secret is passed as the secret parameter or X-Postback-Secret
+// header.
+type postbackHandler struct {
+	secret []byte
+	store  conversionStore
+	now    func() time.Time
+}
+
+func newPostbackHandler(secret string, store conversionStore) *postbackHandler {
+	return &postbackHandler{secret: []byte(secret), store: store, now: time.Now}
+}
+
+func (h *postbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := r.Header.Get("X-Postback-Secret")
+	if secret == "" {
+		secret = r.FormValue("secret")
+	}
+	if len(h.secret) == 0 || subtle.ConstantTimeCompare([]byte(secret), h.secret) != 1 {
+		http.Error(w, "invalid secret", http.StatusForbidden)
+		return
+	}
+
+	c, err := h.parse(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	click, err := h.store.getClick(r.Context(), c.ClickID)
+	if errors.Is(err, ErrUnknownClick) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	c.TrackingLogID = click.TrackingLogID
+	c.TrackingID = click.TrackingID
+	c.AffiliateID = click.AffiliateID
+	c.Params = click.Params
+	c.BannerID = click.BannerID
+	c.Variant = click.Variant
+	c.FlagReason = click.FlagReason
+
+	// Duplicates are acknowledged with 200 so the operator stops retrying.
+	err = h.store.saveConversion(r.Context(), c)
+	switch {
+	case errors.Is(err, ErrDuplicateConversion):
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("duplicate"))
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
+func (h *postbackHandler) parse(r *http.Request) (*conversion, error) {
+	c := &conversion{
+		ClickID:   strings.TrimSpace(r.FormValue("click_id")),
+		Type:      conversionType(r.FormValue("event")),
+		EventID:   r.FormValue("event_id"),
+		Currency:  strings.ToUpper(r.FormValue("currency")),
+		CreatedAt: h.now(),
+	}
+
+	if c.ClickID == "" {
+		return nil, errors.New("click_id is required")
+	}
+
+	switch c.Type {
+	case conversionRegistration:
+	case conversionFirstDeposit:
+		amount, err := strconv.ParseInt(r.FormValue("amount"), 10, 64)
+		if err != nil || amount <= 0 {
+			return nil, errors.New("amount must be a positive number of minor units")
+		}
+		if len(c.Currency) != 3 {
+			return nil, errors.New("currency is required")
+		}
+		c.Amount = amount
+	default:
+		return nil, errors.New("event must be registration or ftd")
+	}
+
+	c.DedupeKey = conversionDedupeKey(c.ClickID, c.Type)
+
+	return c, nil
+}
+
+// funnel is the clicks → signups → FTDs breakdown of a summary row.
+type funnel struct {
+	Clicks     int64
+	Signups    int64
+	FTDs       int64
+	FTDAmount  int64
+	SignupRate float64
+	FTDRate    float64
+	ClickToFTD float64
+}
+
+// buildFunnel counts the conversions of one summary row. SignupRate and
+// FTDRate are percentages of the previous step, ClickToFTD of all clicks.
+func buildFunnel(clicks int64, conversions []*conversion) funnel {
+	f := funnel{Clicks: clicks}
+	for _, c := range conversions {
+		switch c.Type {
+		case conversionRegistration:
+			f.Signups++
+		case conversionFirstDeposit:
+			f.FTDs++
+			f.FTDAmount += c.Amount
+		}
+	}
+
+	f.setRates()
+
+	return f
+}
+
+func (f *funnel) setRates() {
+	f.SignupRate = percentage(f.Signups, f.Clicks)
+	f.FTDRate = percentage(f.FTDs, f.Signups)
+	f.ClickToFTD = percentage(f.FTDs, f.Clicks)
+}
+
+func percentage(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+
+	return float64(part) * 100 / float64(whole)
+}
@@ -0,0 +1,205 @@
+package trackingimpl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeConversionStore enforces the unique dedupe key like the table does.
+type fakeConversionStore struct {
+	clicks      map[string]*clickRef
+	conversions map[string]*conversion
+	err         error
+}
+
+func newFakeConversionStore() *fakeConversionStore {
+	return &fakeConversionStore{
+		clicks: map[string]*clickRef{
+			"c1": {TrackingLogID: 11, TrackingID: 7, AffiliateID: 3, Params: map[string]string{"sub1": "spring"}, BannerID: 12, Variant: "B"},
+			"c2": {TrackingLogID: 12, TrackingID: 7, AffiliateID: 3, FlagReason: fraudIPRateLimit},
+		},
+		conversions: map[string]*conversion{},
+	}
+}
+
+func (f *fakeConversionStore) getClick(_ context.Context, clickID string) (*clickRef, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	click, ok := f.clicks[clickID]
+	if !ok {
+		return nil, ErrUnknownClick
+	}
+	return click, nil
+}
+
+func (f *fakeConversionStore) saveConversion(_ context.Context, c *conversion) error {
+	if _, ok := f.conversions[c.DedupeKey]; ok {
+		return ErrDuplicateConversion
+	}
+	f.conversions[c.DedupeKey] = c
+	return nil
+}
+
+func TestPostbackHandler(t *testing.T) {
+	testCases := []struct {
+		name           string
+		query          string
+		header         string
+		expectedStatus int
+		expectedBody   string
+		expectedSaved  int
+	}{
+		{
+			name:           "postback success - registration",
+			query:          "secret=s3cret&click_id=c1&event=registration&event_id=r-1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+			expectedSaved:  1,
+		},
+		{
+			name:           "postback success - ftd with header secret",
+			query:          "click_id=c1&event=ftd&amount=5000&currency=usd",
+			header:         "s3cret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+			expectedSaved:  1,
+		},
+		{
+			name:           "postback error - bad secret",
+			query:          "secret=wrong&click_id=c1&event=registration",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "postback error - missing secret",
+			query:          "click_id=c1&event=registration",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "postback error - unknown click id",
+			query:          "secret=s3cret&click_id=nope&event=registration",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "postback error - unknown event",
+			query:          "secret=s3cret&click_id=c1&event=login",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "postback error - ftd without amount",
+			query:          "secret=s3cret&click_id=c1&event=ftd&currency=USD",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newFakeConversionStore()
+			handler := newPostbackHandler("s3cret", store)
+
+			req := httptest.NewRequest(http.MethodGet, "/postback?"+tc.query, nil)
+			if tc.header != "" {
+				req.Header.Set("X-Postback-Secret", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("expected status %v, got %v", tc.expectedStatus, rec.Code)
+			}
+			if tc.expectedBody != "" && rec.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, rec.Body.String())
+			}
+			if len(store.conversions) != tc.expectedSaved {
+				t.Errorf("expected %d conversions, got %d", tc.expectedSaved, len(store.conversions))
+			}
+		})
+	}
+}
+
+func TestPostbackHandlerDuplicate(t *testing.T) {
+	store := newFakeConversionStore()
+	handler := newPostbackHandler("s3cret", store)
+
+	send := func(eventID string) *httptest.ResponseRecorder {
+		form := url.Values{"secret": {"s3cret"}, "click_id": {"c1"}, "event": {"registration"}, "event_id": {eventID}}
+		req := httptest.NewRequest(http.MethodPost, "/postback", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := send("r-1")
+	second := send("r-2")
+
+	if first.Code != http.StatusOK || first.Body.String() != "ok" {
+		t.Errorf("expected first postback ok, got %v %q", first.Code, first.Body.String())
+	}
+	if second.Code != http.StatusOK || second.Body.String() != "duplicate" {
+		t.Errorf("expected second postback duplicate, got %v %q", second.Code, second.Body.String())
+	}
+	if len(store.conversions) != 1 {
+		t.Fatalf("expected 1 conversion, got %d", len(store.conversions))
+	}
+
+	saved := store.conversions[conversionDedupeKey("c1", conversionRegistration)]
+	if saved.EventID != "r-1" || saved.TrackingLogID != 11 || saved.TrackingID != 7 || saved.AffiliateID != 3 {
+		t.Errorf("unexpected conversion %+v", saved)
+	}
+	if saved.Params["sub1"] != "spring" || saved.BannerID != 12 || saved.Variant != "B" {
+		t.Errorf("expected the click params, banner and variant on the conversion, got %v %d %q", saved.Params, saved.BannerID, saved.Variant)
+	}
+}
+
+func TestPostbackHandlerFlaggedClick(t *testing.T) {
+	store := newFakeConversionStore()
+	handler := newPostbackHandler("s3cret", store)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/postback?secret=s3cret&click_id=c2&event=registration", nil))
+
+	saved := store.conversions[conversionDedupeKey("c2", conversionRegistration)]
+	if rec.Code != http.StatusOK || saved == nil || saved.FlagReason != fraudIPRateLimit {
+		t.Errorf("expected the click's flag %q on the conversion, got %v %+v", fraudIPRateLimit, rec.Code, saved)
+	}
+}
+
+func TestPostbackHandlerStoreError(t *testing.T) {
+	store := newFakeConversionStore()
+	store.err = errors.New("test error")
+	handler := newPostbackHandler("s3cret", store)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/postback?secret=s3cret&click_id=c1&event=registration", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %v, got %v", http.StatusInternalServerError, rec.Code)
+	}
+}
+
+func TestBuildFunnel(t *testing.T) {
+	conversions := []*conversion{
+		{Type: conversionRegistration},
+		{Type: conversionRegistration},
+		{Type: conversionRegistration},
+		{Type: conversionRegistration},
+		{Type: conversionFirstDeposit, Amount: 2500},
+	}
+
+	result := buildFunnel(200, conversions)
+
+	expected := funnel{Clicks: 200, Signups: 4, FTDs: 1, FTDAmount: 2500, SignupRate: 2, FTDRate: 25, ClickToFTD: 0.5}
+	if result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+
+	if empty := buildFunnel(0, nil); empty != (funnel{}) {
+		t.Errorf("expected empty funnel, got %+v", empty)
+	}
+}
@@ -0,0 +1,579 @@
+package trackingimpl
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var ErrQRDataTooLong = errors.New("data does not fit in a version 10 QR code")
+
+const (
+	qrMaxVersion = 10
+	qrQuietZone  = 4
+	qrMaxScale   = 40
+)
+
+// qrBlocks is the level M block layout of one version: Count blocks of
+// DataCodewords each.
+type qrBlocks struct {
+	Count         int
+	DataCodewords int
+}
+
+type qrVersionInfo struct {
+	ECPerBlock int
+	Groups     []qrBlocks
+	Alignment  []int
+}
+
+// qrVersions holds error correction level M, versions 1 to 10, from
+// ISO/IEC 18004 tables 9 and E.1.
+var qrVersions = [qrMaxVersion + 1]qrVersionInfo{
+	1:  {ECPerBlock: 10, Groups: []qrBlocks{{1, 16}}},
+	2:  {ECPerBlock: 16, Groups: []qrBlocks{{1, 28}}, Alignment: []int{6, 18}},
+	3:  {ECPerBlock: 26, Groups: []qrBlocks{{1, 44}}, Alignment: []int{6, 22}},
+	4:  {ECPerBlock: 18, Groups: []qrBlocks{{2, 32}}, Alignment: []int{6, 26}},
+	5:  {ECPerBlock: 24, Groups: []qrBlocks{{2, 43}}, Alignment: []int{6, 30}},
+	6:  {ECPerBlock: 16, Groups: []qrBlocks{{4, 27}}, Alignment: []int{6, 34}},
+	7:  {ECPerBlock: 18, Groups: []qrBlocks{{4, 31}}, Alignment: []int{6, 22, 38}},
+	8:  {ECPerBlock: 22, Groups: []qrBlocks{{2, 38}, {2, 39}}, Alignment: []int{6, 24, 42}},
+	9:  {ECPerBlock: 22, Groups: []qrBlocks{{3, 36}, {2, 37}}, Alignment: []int{6, 26, 46}},
+	10: {ECPerBlock: 26, Groups: []qrBlocks{{4, 43}, {1, 44}}, Alignment: []int{6, 28, 50}},
+}
+
+func (v qrVersionInfo) dataCodewords() int {
+	n := 0
+	for _, g := range v.Groups {
+		n += g.Count * g.DataCodewords
+	}
+	return n
+}
+
+// qrCountBits is the length of the byte mode character count.
+func qrCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// qrCode is an encoded symbol; Modules[y][x] is true for dark.
+type qrCode struct {
+	Version int
+	Mask    int
+	Size    int
+	Modules [][]bool
+}
+
+// encodeQR encodes data in byte mode at level M in the smallest version
+// that fits, choosing the mask with the lowest penalty.
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 0
+	for v := 1; v <= qrMaxVersion; v++ {
+		if 4+qrCountBits(v)+8*len(data) <= 8*qrVersions[v].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrQRDataTooLong, len(data))
+	}
+
+	m := newQRMatrix(version)
+	m.placeCodewords(qrCodewords(version, data))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		m.applyMask(mask)
+		m.drawFormat(mask)
+		if p := m.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		m.applyMask(mask)
+	}
+	m.applyMask(best)
+	m.drawFormat(best)
+
+	return &qrCode{Version: version, Mask: best, Size: m.size, Modules: m.modules}, nil
+}
+
+// qrCodewords builds the data bit stream, splits it into blocks and
+// interleaves the data and error correction codewords.
+func qrCodewords(version int, data []byte) []byte {
+	info := qrVersions[version]
+	capacity := info.dataCodewords()
+
+	var bits qrBitBuffer
+	bits.append(0b0100, 4)
+	bits.append(len(data), qrCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, 8*capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < 8*capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	stream := bits.bytes()
+
+	divisor := rsDivisor(info.ECPerBlock)
+	var dataBlocks, ecBlocks [][]byte
+	for _, g := range info.Groups {
+		for i := 0; i < g.Count; i++ {
+			block := stream[:g.DataCodewords]
+			stream = stream[g.DataCodewords:]
+			dataBlocks = append(dataBlocks, block)
+			ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+		}
+	}
+
+	result := make([]byte, 0, capacity+len(dataBlocks)*info.ECPerBlock)
+	for i := 0; i < info.Groups[len(info.Groups)-1].DataCodewords; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < info.ECPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+type qrBitBuffer []bool
+
+func (b *qrBitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 == 1)
+	}
+}
+
+func (b qrBitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			result[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(256) modulo x^8+x^4+x^3+x^2+1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the generator polynomial of the given degree, highest
+// coefficient first with the leading 1 dropped.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// qrFormatBits returns the 15 format bits for level M and the mask.
+func qrFormatBits(mask int) int {
+	data := 0<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// qrVersionBits returns the 18 version bits drawn from version 7 up.
+func qrVersionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+// qrMatrix is a symbol under construction. function marks the modules
+// that are not data.
+type qrMatrix struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// newQRMatrix draws the finder, timing and alignment patterns and the
+// version information, and reserves the format areas.
+func newQRMatrix(version int) *qrMatrix {
+	size := version*4 + 17
+	m := &qrMatrix{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range m.modules {
+		m.modules[y] = make([]bool, size)
+		m.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		m.set(6, i, i%2 == 0)
+		m.set(i, 6, i%2 == 0)
+	}
+
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				m.set(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	pos := qrVersions[version].Alignment
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					m.set(pos[i]+dx, pos[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	m.drawFormat(0)
+
+	if version >= 7 {
+		bits := qrVersionBits(version)
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := size-11+i%3, i/3
+			m.set(a, b, dark)
+			m.set(b, a, dark)
+		}
+	}
+
+	return m
+}
+
+func (m *qrMatrix) set(x, y int, dark bool) {
+	m.modules[y][x] = dark
+	m.function[y][x] = true
+}
+
+// drawFormat writes both copies of the format bits and the dark module.
+func (m *qrMatrix) drawFormat(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		m.set(8, i, bit(i))
+	}
+	m.set(8, 7, bit(6))
+	m.set(8, 8, bit(7))
+	m.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		m.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		m.set(m.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		m.set(8, m.size-15+i, bit(i))
+	}
+	m.set(8, m.size-8, true)
+}
+
+// eachDataModule visits the data modules in placement order: two-column
+// strips from the right, alternating up and down, skipping the vertical
+// timing column.
+func (m *qrMatrix) eachDataModule(fn func(x, y int)) {
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < m.size; vert++ {
+			y := vert
+			if upward {
+				y = m.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				if x := right - j; !m.function[y][x] {
+					fn(x, y)
+				}
+			}
+		}
+	}
+}
+
+// placeCodewords fills the data modules; any remainder bits stay light.
+func (m *qrMatrix) placeCodewords(codewords []byte) {
+	i := 0
+	m.eachDataModule(func(x, y int) {
+		if i < len(codewords)*8 {
+			m.modules[y][x] = codewords[i/8]&(0x80>>(i%8)) != 0
+			i++
+		}
+	})
+}
+
+func qrMaskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// applyMask flips the data modules the mask selects; applying it twice
+// undoes it.
+func (m *qrMatrix) applyMask(mask int) {
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			if !m.function[y][x] && qrMaskBit(mask, x, y) {
+				m.modules[y][x] = !m.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol with the four mask evaluation rules.
+func (m *qrMatrix) penalty() int {
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return m.modules[x][y]
+		}
+		return m.modules[y][x]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	score := 0
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < m.size; y++ {
+			run := 1
+			for x := 1; x <= m.size; x++ {
+				if x < m.size && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for x := 0; x+7 <= m.size; x++ {
+				match := true
+				for i, dark := range finderLike {
+					if at(x+i, y, vertical) != dark {
+						match = false
+						break
+					}
+				}
+				if match && (m.lightRun(x-4, x, y, vertical) || m.lightRun(x+7, x+11, y, vertical)) {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			if m.modules[y][x] {
+				dark++
+			}
+			if x+1 < m.size && y+1 < m.size {
+				c := m.modules[y][x]
+				if c == m.modules[y][x+1] && c == m.modules[y+1][x] && c == m.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+
+	total := m.size * m.size
+	score += ((abs(dark*20-total*10)+total-1)/total - 1) * 10
+
+	return score
+}
+
+// lightRun reports whether positions [from, to) of the line are light,
+// counting the quiet zone outside the symbol as light.
+func (m *qrMatrix) lightRun(from, to, line int, vertical bool) bool {
+	for i := from; i < to; i++ {
+		if i < 0 || i >= m.size {
+			continue
+		}
+		if (vertical && m.modules[i][line]) || (!vertical && m.modules[line][i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// writePNG draws the code scale pixels per module with a four module quiet
+// zone.
+func (c *qrCode) writePNG(w io.Writer, scale int) error {
+	side := (c.Size + 2*qrQuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y, row := range c.Modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetColorIndex((x+qrQuietZone)*scale+px, (y+qrQuietZone)*scale+py, 1)
+				}
+			}
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+// writeSVG draws the code as a single path over a white background, one
+// subpath per horizontal run of dark modules.
+func (c *qrCode) writeSVG(w io.Writer, scale int) error {
+	side := c.Size + 2*qrQuietZone
+
+	var path strings.Builder
+	for y, row := range c.Modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start+qrQuietZone, y+qrQuietZone, x-start, x-start)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		side*scale, side*scale, side, side, side, side, path.String())
+	return err
+}
+
+// qrHandler serves the QR code of a tracking link's short URL as
+// /<prefix>/<code>?format=png|svg&scale=n.
+type qrHandler struct {
+	pathPrefix string
+	// baseURL is the short link host, e.g. "https://go.example.com".
+	baseURL  string
+	resolver trackingResolver
+}
+
+func (h *qrHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code := strings.Trim(strings.TrimPrefix(r.URL.Path, h.pathPrefix), "/")
+	if code == "" || strings.Contains(code, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	scale := 8
+	if s := query.Get("scale"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > qrMaxScale {
+			http.Error(w, fmt.Sprintf("scale must be 1 to %d", qrMaxScale), http.StatusBadRequest)
+			return
+		}
+		scale = n
+	}
+
+	format := query.Get("format")
+	if format != "" && format != "png" && format != "svg" {
+		http.Error(w, "format must be png or svg", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.resolver.resolveTracking(r.Context(), code); errors.Is(err, ErrTrackingNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	qr, err := encodeQR([]byte(strings.TrimSuffix(h.baseURL, "/") + "/" + code))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		_ = qr.writeSVG(w, scale)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	_ = qr.writePNG(w, scale)
+}
@@ -0,0 +1,321 @@
+package trackingimpl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// decodeQR reads a symbol back: it finds the mask from the format bits,
+// unmasks, collects the codewords in placement order, de-interleaves the
+// data blocks and parses the byte mode segment.
+func decodeQR(t *testing.T, modules [][]bool) []byte {
+	t.Helper()
+
+	size := len(modules)
+	version := (size - 17) / 4
+	m := newQRMatrix(version)
+
+	format := 0
+	for i := 0; i <= 5; i++ {
+		if modules[i][8] {
+			format |= 1 << i
+		}
+	}
+	for i, p := range [][2]int{{8, 7}, {8, 8}, {7, 8}} {
+		if modules[p[1]][p[0]] {
+			format |= 1 << (6 + i)
+		}
+	}
+	for i := 9; i < 15; i++ {
+		if modules[8][14-i] {
+			format |= 1 << i
+		}
+	}
+	mask := -1
+	for candidate := 0; candidate < 8; candidate++ {
+		if qrFormatBits(candidate) == format {
+			mask = candidate
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("format bits %015b match no level M mask", format)
+	}
+
+	for y := range modules {
+		copy(m.modules[y], modules[y])
+	}
+	m.applyMask(mask)
+
+	info := qrVersions[version]
+	blocks := 0
+	for _, g := range info.Groups {
+		blocks += g.Count
+	}
+	total := info.dataCodewords() + blocks*info.ECPerBlock
+
+	codewords := make([]byte, total)
+	i := 0
+	m.eachDataModule(func(x, y int) {
+		if i < total*8 && m.modules[y][x] {
+			codewords[i/8] |= 0x80 >> (i % 8)
+		}
+		i++
+	})
+
+	dataBlocks := make([][]byte, 0, blocks)
+	for _, g := range info.Groups {
+		for b := 0; b < g.Count; b++ {
+			dataBlocks = append(dataBlocks, make([]byte, 0, g.DataCodewords))
+		}
+	}
+	next := 0
+	for round := 0; round < info.Groups[len(info.Groups)-1].DataCodewords; round++ {
+		for b, g := range blockSizes(info) {
+			if round < g {
+				dataBlocks[b] = append(dataBlocks[b], codewords[next])
+				next++
+			}
+		}
+	}
+	stream := bytes.Join(dataBlocks, nil)
+
+	bit := func(pos int) int { return int(stream[pos/8]>>(7-pos%8)) & 1 }
+	read := func(pos, n int) int {
+		v := 0
+		for k := 0; k < n; k++ {
+			v = v<<1 | bit(pos+k)
+		}
+		return v
+	}
+	if modeBits := read(0, 4); modeBits != 0b0100 {
+		t.Fatalf("expected byte mode, got %04b", modeBits)
+	}
+	count := read(4, qrCountBits(version))
+	result := make([]byte, count)
+	for k := range result {
+		result[k] = byte(read(4+qrCountBits(version)+8*k, 8))
+	}
+
+	return result
+}
+
+func blockSizes(info qrVersionInfo) []int {
+	var sizes []int
+	for _, g := range info.Groups {
+		for b := 0; b < g.Count; b++ {
+			sizes = append(sizes, g.DataCodewords)
+		}
+	}
+	return sizes
+}
+
+func TestRSRemainder(t *testing.T) {
+	// "HELLO WORLD" at 1-M from the ISO/IEC 18004 annex I example.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	result := rsRemainder(data, rsDivisor(10))
+	if !bytes.Equal(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestQRFormatAndVersionBits(t *testing.T) {
+	if result := strconv.FormatInt(int64(qrFormatBits(0)), 2); result != "101010000010010" {
+		t.Errorf("expected format bits 101010000010010, got %s", result)
+	}
+	if result := qrVersionBits(7); result != 0x07C94 {
+		t.Errorf("expected version bits 0x07C94, got %#05x", result)
+	}
+}
+
+func TestEncodeQR(t *testing.T) {
+	testCases := []struct {
+		name            string
+		data            string
+		expectedVersion int
+		expectedError   error
+	}{
+		{name: "encode qr success - version 1", data: "go.example/Ab3", expectedVersion: 1},
+		{name: "encode qr success - version 2", data: "https://go.ex/aB3dE5f", expectedVersion: 2},
+		{name: "encode qr success - version 7 with version info", data: "https://go.example.com/t/" + strings.Repeat("x", 95), expectedVersion: 7},
+		{name: "encode qr success - version 8 unequal blocks", data: strings.Repeat("y", 150), expectedVersion: 8},
+		{name: "encode qr success - version 10 long count", data: strings.Repeat("z", 213), expectedVersion: 10},
+		{name: "encode qr error - too long", data: strings.Repeat("z", 214), expectedError: ErrQRDataTooLong},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := encodeQR([]byte(tc.data))
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if result.Version != tc.expectedVersion {
+				t.Errorf("expected version %d, got %d", tc.expectedVersion, result.Version)
+			}
+			if result.Size != tc.expectedVersion*4+17 || len(result.Modules) != result.Size {
+				t.Errorf("expected size %d, got %d", tc.expectedVersion*4+17, result.Size)
+			}
+			if !result.Modules[result.Size-8][8] {
+				t.Errorf("expected the dark module to be set")
+			}
+			if decoded := decodeQR(t, result.Modules); string(decoded) != tc.data {
+				t.Errorf("expected to decode %q, got %q", tc.data, decoded)
+			}
+		})
+	}
+}
+
+func TestEncodeQRMasks(t *testing.T) {
+	data := []byte("https://go.example.com/spring-promo")
+	code, err := encodeQR(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	best := (&qrMatrix{size: code.Size, modules: code.Modules}).penalty()
+
+	// Every mask must round-trip, not just the one the penalty picked.
+	for mask := 0; mask < 8; mask++ {
+		m := newQRMatrix(code.Version)
+		m.placeCodewords(qrCodewords(code.Version, data))
+		m.applyMask(mask)
+		m.drawFormat(mask)
+
+		if decoded := decodeQR(t, m.modules); !bytes.Equal(decoded, data) {
+			t.Errorf("mask %d: expected to decode %q, got %q", mask, data, decoded)
+		}
+		if p := m.penalty(); p < best {
+			t.Errorf("mask %d scores %d, below the chosen mask %d at %d", mask, p, code.Mask, best)
+		}
+	}
+}
+
+func TestQRCodeWritePNG(t *testing.T) {
+	code, err := encodeQR([]byte("https://go.example.com/aB3dE5f"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := code.writePNG(&buf, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	side := (code.Size + 2*qrQuietZone) * 3
+	if b := img.Bounds(); b.Dx() != side || b.Dy() != side {
+		t.Fatalf("expected %dx%d, got %dx%d", side, side, b.Dx(), b.Dy())
+	}
+
+	isDark := func(x, y int) bool {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r == 0
+	}
+	if isDark(0, 0) || isDark(qrQuietZone*3-1, qrQuietZone*3-1) {
+		t.Errorf("expected a light quiet zone")
+	}
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			px, py := (x+qrQuietZone)*3+1, (y+qrQuietZone)*3+1
+			if isDark(px, py) != code.Modules[y][x] {
+				t.Fatalf("module (%d, %d): expected dark %v", x, y, code.Modules[y][x])
+			}
+		}
+	}
+}
+
+func TestQRCodeWriteSVG(t *testing.T) {
+	code := &qrCode{Size: 3, Modules: [][]bool{
+		{true, true, false},
+		{false, false, false},
+		{true, false, true},
+	}}
+
+	var buf bytes.Buffer
+	if err := code.writeSVG(&buf, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `<svg xmlns="http://www.w3.org/2000/svg" width="22" height="22" viewBox="0 0 11 11" shape-rendering="crispEdges">` +
+		`<rect width="11" height="11" fill="#fff"/><path d="M4 4h2v1h-2zM4 6h1v1h-1zM6 6h1v1h-1z" fill="#000"/></svg>`
+	if buf.String() != expected {
+		t.Errorf("expected %s, got %s", expected, buf.String())
+	}
+}
+
+func TestQRHandler(t *testing.T) {
+	testCases := []struct {
+		name                string
+		path                string
+		err                 error
+		expectedStatus      int
+		expectedContentType string
+	}{
+		{name: "serve qr success - png by default", path: "/qr/aB3dE5f", expectedStatus: http.StatusOK, expectedContentType: "image/png"},
+		{name: "serve qr success - svg", path: "/qr/aB3dE5f?format=svg&scale=4", expectedStatus: http.StatusOK, expectedContentType: "image/svg+xml"},
+		{name: "serve qr error - unknown code", path: "/qr/missing", expectedStatus: http.StatusNotFound},
+		{name: "serve qr error - bad format", path: "/qr/aB3dE5f?format=gif", expectedStatus: http.StatusBadRequest},
+		{name: "serve qr error - bad scale", path: "/qr/aB3dE5f?scale=0", expectedStatus: http.StatusBadRequest},
+		{name: "serve qr error - resolver", path: "/qr/aB3dE5f", err: errors.New("test error"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &qrHandler{
+				pathPrefix: "/qr/",
+				baseURL:    "https://go.example.com/",
+				resolver: &fakeResolver{
+					targets: map[string]*redirectTarget{"aB3dE5f": {TrackingID: 1}},
+					err:     tc.err,
+				},
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequestWithContext(context.Background(), http.MethodGet, tc.path, nil))
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatus, rec.Code)
+			}
+			if tc.expectedContentType == "" {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tc.expectedContentType {
+				t.Errorf("expected content type %q, got %q", tc.expectedContentType, ct)
+			}
+			if tc.expectedContentType == "image/png" {
+				img, err := png.Decode(rec.Body)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				modules := make([][]bool, 0)
+				size := img.Bounds().Dx()/8 - 2*qrQuietZone
+				for y := 0; y < size; y++ {
+					row := make([]bool, size)
+					for x := range row {
+						r, _, _, _ := img.At((x+qrQuietZone)*8, (y+qrQuietZone)*8).RGBA()
+						row[x] = r == 0
+					}
+					modules = append(modules, row)
+				}
+				if decoded := decodeQR(t, modules); string(decoded) != "https://go.example.com/aB3dE5f" {
+					t.Errorf("expected the short URL, got %q", decoded)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package trackingimpl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrTrackingNotFound = errors.New("tracking link not found")
+
+// clickIDParam is added to the destination so operator postbacks can refer
+// back to the click.
+const clickIDParam = "click_id"
+
+var subIDParams = []string{"sub1", "sub2", "sub3", "sub4", "sub5"}
+
+type redirectTarget struct {
+	TrackingID  int64
+	AffiliateID int64
+	Destination string
+	// Variants, when set, replace Destination with a weighted rotation.
+	Variants  []destinationVariant
+	Lifecycle trackingLifecycle
+}
+
+type trackingResolver interface {
+	resolveTracking(ctx context.Context, code string) (*redirectTarget, error)
+}
+
+type clickEvent struct {
+	ClickID     string
+	TrackingID  int64
+	AffiliateID int64
+	IP          string
+	UserAgent   string
+	Referrer    string
+	// Params holds the sub-ID and UTM parameters of the click URL.
+	Params map[string]string
+	// BannerID is the banner the click came from, 0 when it did not come
+	// from banner embed code.
+	BannerID   int64
+	Variant    string
+	FlagReason fraudReason
+	Country    string
+	Region     string
+	DeviceType string
+	OS         string
+	Browser    string
+	CreatedAt  time.Time
+}
+
+type clickSink interface {
+	record(e *clickEvent)
+}
+
+type redirectConfig struct {
+	// PathPrefix is stripped from the request path to get the tracking code.
+	PathPrefix string
+	// StatusCode is one of the redirect codes; 302 when zero.
+	StatusCode int
+	// Passthrough lists the query parameters copied to the destination; "*"
+	// copies all of them.
+	Passthrough []string
+	// TrustedProxies are the proxies in front of the handler. When the
+	// request comes from one of them the client IP is taken from
+	// X-Forwarded-For, skipping the addresses the proxies appended.
+	TrustedProxies []netip.Prefix
+	// Fraud, when set, flags suspicious clicks before they are recorded.
+	Fraud *fraudConfig
+}
+
+type redirectHandler struct {
+	cfg       redirectConfig
+	resolver  trackingResolver
+	sink      clickSink
+	filter    *clickFilter
+	now       func() time.Time
+	clickID   func() string
+	visitorID func() string
+}
+
+func newRedirectHandler(cfg redirectConfig, resolver trackingResolver, sink clickSink) (*redirectHandler, error) {
+	switch cfg.StatusCode {
+	case 0:
+		cfg.StatusCode = http.StatusFound
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("redirect status code %d is not a redirect", cfg.StatusCode)
+	}
+
+	h := &redirectHandler{
+		cfg:       cfg,
+		resolver:  resolver,
+		sink:      sink,
+		now:       time.Now,
+		clickID:   newClickID,
+		visitorID: newClickID,
+	}
+	if cfg.Fraud != nil {
+		h.filter = newClickFilter(*cfg.Fraud)
+	}
+
+	return h, nil
+}
+
+func (h *redirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	code := strings.Trim(strings.TrimPrefix(r.URL.Path, h.cfg.PathPrefix), "/")
+	if code == "" || strings.Contains(code, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	target, err := h.resolver.resolveTracking(r.Context(), code)
+	if errors.Is(err, ErrTrackingNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Inactive links send visitors to the fallback without counting a click.
+	if state := target.Lifecycle.state(h.now()); state != lifecycleActive {
+		switch {
+		case target.Lifecycle.FallbackURL != "":
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, target.Lifecycle.FallbackURL, http.StatusFound)
+		case state == lifecycleExpired:
+			http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	query := r.URL.Query()
+	event := &clickEvent{
+		ClickID:     h.clickID(),
+		TrackingID:  target.TrackingID,
+		AffiliateID: target.AffiliateID,
+		IP:          clientIP(r, h.cfg.TrustedProxies),
+		UserAgent:   r.UserAgent(),
+		Referrer:    r.Referer(),
+		Params:      captureParams(query),
+		BannerID:    captureBannerID(query),
+		CreatedAt:   h.now(),
+	}
+
+	destination := target.Destination
+	if len(target.Variants) > 0 {
+		v := pickVariant(target.Variants, target.TrackingID, visitorID(w, r, h.visitorID))
+		destination = v.URL
+		event.Variant = v.Variant
+	}
+
+	location, err := h.destination(destination, query, event.ClickID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Flagged clicks are still redirected and stored with their reason.
+	if h.filter != nil {
+		event.FlagReason = h.filter.check(event)
+	}
+	h.sink.record(event)
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, location, h.cfg.StatusCode)
+}
+
+// destination appends the passthrough parameters and the click ID. The
+// destination's own parameters win over incoming ones.
+func (h *redirectHandler) destination(raw string, query url.Values, clickID string) (string, error) {
+	dest, err := url.Parse(raw)
+	if err != nil || !dest.IsAbs() {
+		return "", fmt.Errorf("bad destination %q", raw)
+	}
+
+	values := dest.Query()
+	for _, name := range h.cfg.Passthrough {
+		if name == "*" {
+			for k, v := range query {
+				if _, ok := values[k]; !ok {
+					values[k] = v
+				}
+			}
+			continue
+		}
+		if _, ok := values[name]; !ok && query.Has(name) {
+			values[name] = query[name]
+		}
+	}
+	values.Set(clickIDParam, clickID)
+	dest.RawQuery = values.Encode()
+
+	return dest.String(), nil
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is read only
+// when the peer is a trusted proxy, and then from the right: every entry is
+// appended by the hop that received the request, so the rightmost address
+// that is not a trusted proxy is the client. Entries left of it could have
+// been sent by the client itself.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, trusted) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop, trusted) {
+			return hop
+		}
+		host = hop
+	}
+
+	return host
+}
+
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newClickID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// clickRecorder writes click events to the tracking log in the background
+// so a slow database does not delay the redirect. Events are written in
+// batches; when the buffer is full new events are dropped and counted.
+type clickRecorder struct {
+	events  chan *clickEvent
+	save    func(ctx context.Context, events []*clickEvent) error
+	onError func(error)
+	batch   int
+	flush   time.Duration
+
+	mu      sync.Mutex
+	dropped int64
+	closed  bool
+	done    chan struct{}
+}
+
+// newClickRecorder starts the background writer. When enricher is set,
+// clicks are enriched in the writer just before they are saved.
+func newClickRecorder(buffer, batch int, flush time.Duration, enricher *clickEnricher, save func(context.Context, []*clickEvent) error, onError func(error)) *clickRecorder {
+	if enricher != nil {
+		save = enricher.wrap(save)
+	}
+
+	r := &clickRecorder{
+		events:  make(chan *clickEvent, buffer),
+		save:    save,
+		onError: onError,
+		batch:   batch,
+		flush:   flush,
+		done:    make(chan struct{}),
+	}
+	go r.loop()
+
+	return r
+}
+
+// record queues e. Events recorded after Close are dropped and counted.
+func (r *clickRecorder) record(e *clickEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		r.dropped++
+		return
+	}
+
+	select {
+	case r.events <- e:
+	default:
+		r.dropped++
+	}
+}
+
+func (r *clickRecorder) Dropped() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.dropped
+}
+
+// Close stops accepting events and waits until the buffered ones are saved.
+// Calling it again only waits.
+func (r *clickRecorder) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.events)
+	}
+	r.mu.Unlock()
+
+	<-r.done
+}
+
+func (r *clickRecorder) loop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.flush)
+	defer ticker.Stop()
+
+	pending := make([]*clickEvent, 0, r.batch)
+	write := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := r.save(context.Background(), pending); err != nil && r.onError != nil {
+			r.onError(err)
+		}
+		pending = make([]*clickEvent, 0, r.batch)
+	}
+
+	for {
+		select {
+		case e, ok := <-r.events:
+			if !ok {
+				write()
+				return
+			}
+			pending = append(pending, e)
+			if len(pending) >= r.batch {
+				write()
+			}
+		case <-ticker.C:
+			write()
+		}
+	}
+}
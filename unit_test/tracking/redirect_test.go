@@ -0,0 +1,285 @@
+package trackingimpl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	targets map[string]*redirectTarget
+	err     error
+}
+
+func (f *fakeResolver) resolveTracking(_ context.Context, code string) (*redirectTarget, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	target, ok := f.targets[code]
+	if !ok {
+		return nil, ErrTrackingNotFound
+	}
+	return target, nil
+}
+
+type fakeSink struct {
+	mu     sync.Mutex
+	events []*clickEvent
+}
+
+func (f *fakeSink) record(e *clickEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, e)
+}
+
+func TestRedirectHandler(t *testing.T) {
+	resolver := &fakeResolver{targets: map[string]*redirectTarget{
+		"abc123": {TrackingID: 7, AffiliateID: 3, Destination: "https://brand.example/landing?lang=en"},
+	}}
+
+	testCases := []struct {
+		name             string
+		cfg              redirectConfig
+		resolver         *fakeResolver
+		target           string
+		expectedStatus   int
+		expectedLocation string
+		expectedClick    bool
+	}{
+		{
+			name:             "redirect success",
+			cfg:              redirectConfig{PathPrefix: "/t/", Passthrough: []string{"sub1", "sub2"}},
+			resolver:         resolver,
+			target:           "/t/abc123?sub1=spring&sub2=fb&other=x",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://brand.example/landing?click_id=c1&lang=en&sub1=spring&sub2=fb",
+			expectedClick:    true,
+		},
+		{
+			name:             "redirect success - permanent with all params",
+			cfg:              redirectConfig{PathPrefix: "/t/", StatusCode: http.StatusMovedPermanently, Passthrough: []string{"*"}},
+			resolver:         resolver,
+			target:           "/t/abc123?lang=de&other=x",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "https://brand.example/landing?click_id=c1&lang=en&other=x",
+			expectedClick:    true,
+		},
+		{
+			name:             "redirect success - no passthrough",
+			cfg:              redirectConfig{PathPrefix: "/t/", StatusCode: http.StatusTemporaryRedirect},
+			resolver:         resolver,
+			target:           "/t/abc123?sub1=spring",
+			expectedStatus:   http.StatusTemporaryRedirect,
+			expectedLocation: "https://brand.example/landing?click_id=c1&lang=en",
+			expectedClick:    true,
+		},
+		{
+			name:           "redirect error - not found",
+			cfg:            redirectConfig{PathPrefix: "/t/"},
+			resolver:       resolver,
+			target:         "/t/unknown",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "redirect error",
+			cfg:            redirectConfig{PathPrefix: "/t/"},
+			resolver:       &fakeResolver{err: errors.New("test error")},
+			target:         "/t/abc123",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &fakeSink{}
+			handler, err := newRedirectHandler(tc.cfg, tc.resolver, sink)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			handler.clickID = func() string { return "c1" }
+
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("expected status %v, got %v", tc.expectedStatus, rec.Code)
+			}
+			if location := rec.Header().Get("Location"); location != tc.expectedLocation {
+				t.Errorf("expected location %q, got %q", tc.expectedLocation, location)
+			}
+			if (len(sink.events) == 1) != tc.expectedClick {
+				t.Errorf("expected click recorded %v, got %d events", tc.expectedClick, len(sink.events))
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerClickEvent(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	resolver := &fakeResolver{targets: map[string]*redirectTarget{
+		"abc123": {TrackingID: 7, AffiliateID: 3, Destination: "https://brand.example/"},
+	}}
+	sink := &fakeSink{}
+
+	proxies := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("10.0.0.0/8")}
+	handler, err := newRedirectHandler(redirectConfig{PathPrefix: "/t/", TrustedProxies: proxies}, resolver, sink)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.now = func() time.Time { return now }
+	handler.clickID = func() string { return "c1" }
+
+	req := httptest.NewRequest(http.MethodGet, "/t/abc123?sub1=spring&sub5=v2&utm_source=newsletter&banner_id=12&other=x", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Referer", "https://blog.example/post")
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(sink.events) != 1 {
+		t.Fatalf("expected 1 click, got %d", len(sink.events))
+	}
+	e := sink.events[0]
+	if e.ClickID != "c1" || e.TrackingID != 7 || e.AffiliateID != 3 || !e.CreatedAt.Equal(now) {
+		t.Errorf("unexpected click %+v", e)
+	}
+	if e.IP != "203.0.113.9" || e.UserAgent != "Mozilla/5.0" || e.Referrer != "https://blog.example/post" {
+		t.Errorf("unexpected request fields %+v", e)
+	}
+	if len(e.Params) != 3 || e.Params["sub1"] != "spring" || e.Params["sub5"] != "v2" || e.Params["utm_source"] != "newsletter" {
+		t.Errorf("unexpected params %v", e.Params)
+	}
+	if e.BannerID != 12 {
+		t.Errorf("expected banner %d, got %d", 12, e.BannerID)
+	}
+}
+
+func TestNewRedirectHandlerStatusCode(t *testing.T) {
+	_, err := newRedirectHandler(redirectConfig{StatusCode: http.StatusOK}, &fakeResolver{}, &fakeSink{})
+	if err == nil {
+		t.Errorf("expected error for status %v, got none", http.StatusOK)
+	}
+}
+
+func TestClickRecorder(t *testing.T) {
+	var mu sync.Mutex
+	saved := make([][]*clickEvent, 0)
+	save := func(_ context.Context, events []*clickEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = append(saved, events)
+		return nil
+	}
+
+	recorder := newClickRecorder(10, 2, time.Hour, nil, save, nil)
+	for _, id := range []string{"a", "b", "c"} {
+		recorder.record(&clickEvent{ClickID: id})
+	}
+	recorder.Close()
+
+	if len(saved) != 2 || len(saved[0]) != 2 || len(saved[1]) != 1 || saved[1][0].ClickID != "c" {
+		t.Errorf("expected batches of 2 and 1, got %v", saved)
+	}
+}
+
+func TestClickRecorderAfterClose(t *testing.T) {
+	recorder := newClickRecorder(10, 5, time.Hour, nil, func(context.Context, []*clickEvent) error { return nil }, nil)
+	recorder.Close()
+	recorder.Close()
+
+	recorder.record(&clickEvent{ClickID: "late"})
+	if recorder.Dropped() != 1 {
+		t.Errorf("expected %d dropped, got %d", 1, recorder.Dropped())
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expectedIP string
+	}{
+		{
+			name:       "client ip - direct",
+			remoteAddr: "203.0.113.9:4000",
+			expectedIP: "203.0.113.9",
+		},
+		{
+			name:       "client ip - untrusted peer ignores forwarded",
+			remoteAddr: "203.0.113.9:4000",
+			forwarded:  []string{"198.51.100.1"},
+			expectedIP: "203.0.113.9",
+		},
+		{
+			name:       "client ip - rightmost untrusted hop",
+			remoteAddr: "10.0.0.2:4000",
+			forwarded:  []string{"198.51.100.1, 203.0.113.9, 10.0.0.1"},
+			expectedIP: "203.0.113.9",
+		},
+		{
+			name:       "client ip - several headers",
+			remoteAddr: "10.0.0.2:4000",
+			forwarded:  []string{"198.51.100.1", "203.0.113.9"},
+			expectedIP: "203.0.113.9",
+		},
+		{
+			name:       "client ip - only proxies",
+			remoteAddr: "10.0.0.2:4000",
+			forwarded:  []string{"10.0.0.1"},
+			expectedIP: "10.0.0.1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+
+			if ip := clientIP(req, proxies); ip != tc.expectedIP {
+				t.Errorf("expected %v, got %v", tc.expectedIP, ip)
+			}
+		})
+	}
+}
+
+func TestClickRecorderSaveError(t *testing.T) {
+	var got error
+	save := func(context.Context, []*clickEvent) error { return errors.New("test error") }
+
+	recorder := newClickRecorder(10, 5, time.Hour, nil, save, func(err error) { got = err })
+	recorder.record(&clickEvent{ClickID: "a"})
+	recorder.Close()
+
+	if got == nil || got.Error() != "test error" {
+		t.Errorf("expected %v, got %v", "test error", got)
+	}
+}
+
+func TestRedirectDestinationEscaping(t *testing.T) {
+	handler, _ := newRedirectHandler(redirectConfig{Passthrough: []string{"sub1"}}, &fakeResolver{}, &fakeSink{})
+
+	location, err := handler.destination("https://brand.example/", url.Values{"sub1": {"a&b=c"}}, "c1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if location != "https://brand.example/?click_id=c1&sub1=a%26b%3Dc" {
+		t.Errorf("unexpected location %q", location)
+	}
+
+	if _, err := handler.destination("/relative", nil, "c1"); err == nil {
+		t.Errorf("expected error for relative destination, got none")
+	}
+}
@@ -0,0 +1,422 @@
+package trackingimpl
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrUnalignedBackfill  = errors.New("backfill range must start and end at midnight UTC")
+	ErrInvalidRollupBatch = errors.New("rollup batch size must be positive")
+)
+
+type rollupGrain string
+
+const (
+	rollupHourly rollupGrain = "hour"
+	rollupDaily  rollupGrain = "day"
+)
+
+func (g rollupGrain) bucket(t time.Time) time.Time {
+	t = t.UTC()
+	if g == rollupDaily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	return t.Truncate(time.Hour)
+}
+
+// rollupLog is the part of a tracking log row or a conversion the rollups
+// count. Conversion is empty for a click; a conversion carries the
+// country, device and flag of the click it came from.
+type rollupLog struct {
+	ID          int64
+	Conversion  conversionType
+	Amount      int64
+	TrackingID  int64
+	AffiliateID int64
+	Country     string
+	DeviceType  string
+	Flagged     bool
+	CreatedAt   time.Time
+}
+
+// rollupLogRef names a rolled up log; clicks and conversions are numbered
+// separately.
+type rollupLogRef struct {
+	ID         int64
+	Conversion bool
+}
+
+func (l *rollupLog) ref() rollupLogRef {
+	return rollupLogRef{ID: l.ID, Conversion: l.Conversion != ""}
+}
+
+type rollupKey struct {
+	Grain       rollupGrain
+	Bucket      time.Time
+	TrackingID  int64
+	AffiliateID int64
+	Country     string
+	DeviceType  string
+}
+
+// rollupRow holds the funnel counts of a bucket. The Flagged counts are the
+// part of each count that came from flagged clicks.
+type rollupRow struct {
+	rollupKey
+	Clicks           int64
+	Flagged          int64
+	Signups          int64
+	FlaggedSignups   int64
+	FTDs             int64
+	FlaggedFTDs      int64
+	FTDAmount        int64
+	FlaggedFTDAmount int64
+}
+
+func (r *rollupRow) add(log *rollupLog) {
+	switch log.Conversion {
+	case "":
+		r.Clicks++
+		if log.Flagged {
+			r.Flagged++
+		}
+	case conversionRegistration:
+		r.Signups++
+		if log.Flagged {
+			r.FlaggedSignups++
+		}
+	case conversionFirstDeposit:
+		r.FTDs++
+		r.FTDAmount += log.Amount
+		if log.Flagged {
+			r.FlaggedFTDs++
+			r.FlaggedFTDAmount += log.Amount
+		}
+	}
+}
+
+type rollupStore interface {
+	// getUnrolledLogs returns up to limit logs that are not rolled up yet.
+	getUnrolledLogs(ctx context.Context, limit int) ([]*rollupLog, error)
+	// addRollups adds the rows to the stored counts and marks the logs
+	// rolled up in one transaction.
+	addRollups(ctx context.Context, rows []*rollupRow, logs []rollupLogRef) error
+	// getLogsBetween pages through the logs in [from, to), clicks before
+	// conversions and each by ID, starting after the given log.
+	getLogsBetween(ctx context.Context, from, to time.Time, after *rollupLog, limit int) ([]*rollupLog, error)
+	// replaceRollups deletes the rollups with buckets in [from, to), inserts
+	// rows in their place and marks the logs rolled up in one transaction.
+	replaceRollups(ctx context.Context, from, to time.Time, rows []*rollupRow, logs []rollupLogRef) error
+	getRollups(ctx context.Context, grain rollupGrain, from, to time.Time) ([]*rollupRow, error)
+}
+
+// rollupAccumulator counts logs into hourly and daily rows as they are
+// read, keeping the rows and the references of the counted logs only.
+type rollupAccumulator struct {
+	rows map[rollupKey]*rollupRow
+	logs []rollupLogRef
+}
+
+func newRollupAccumulator() *rollupAccumulator {
+	return &rollupAccumulator{rows: map[rollupKey]*rollupRow{}}
+}
+
+func (a *rollupAccumulator) add(logs []*rollupLog) {
+	for _, log := range logs {
+		for _, grain := range []rollupGrain{rollupHourly, rollupDaily} {
+			key := rollupKey{
+				Grain:       grain,
+				Bucket:      grain.bucket(log.CreatedAt),
+				TrackingID:  log.TrackingID,
+				AffiliateID: log.AffiliateID,
+				Country:     log.Country,
+				DeviceType:  log.DeviceType,
+			}
+			row, ok := a.rows[key]
+			if !ok {
+				row = &rollupRow{rollupKey: key}
+				a.rows[key] = row
+			}
+			row.add(log)
+		}
+		a.logs = append(a.logs, log.ref())
+	}
+}
+
+func (a *rollupAccumulator) sorted() []*rollupRow {
+	result := make([]*rollupRow, 0, len(a.rows))
+	for _, row := range a.rows {
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].rollupKey, result[j].rollupKey
+		if a.Grain != b.Grain {
+			return a.Grain < b.Grain
+		}
+		if !a.Bucket.Equal(b.Bucket) {
+			return a.Bucket.Before(b.Bucket)
+		}
+		if a.TrackingID != b.TrackingID {
+			return a.TrackingID < b.TrackingID
+		}
+		if a.Country != b.Country {
+			return a.Country < b.Country
+		}
+		return a.DeviceType < b.DeviceType
+	})
+
+	return result
+}
+
+// aggregateLogs counts logs into hourly and daily rows.
+func aggregateLogs(logs []*rollupLog) []*rollupRow {
+	acc := newRollupAccumulator()
+	acc.add(logs)
+
+	return acc.sorted()
+}
+
+// rollupJob folds the logs that are not rolled up yet into the rollups.
+// Logs are marked rather than read past a checkpoint, so a log committed
+// after a newer one is still counted.
+type rollupJob struct {
+	store rollupStore
+	batch int
+}
+
+// run processes batches until it is caught up and returns how many logs it
+// rolled up.
+func (j *rollupJob) run(ctx context.Context) (int, error) {
+	if j.batch <= 0 {
+		return 0, ErrInvalidRollupBatch
+	}
+
+	processed := 0
+	for {
+		logs, err := j.store.getUnrolledLogs(ctx, j.batch)
+		if err != nil {
+			return processed, err
+		}
+		if len(logs) == 0 {
+			return processed, nil
+		}
+
+		acc := newRollupAccumulator()
+		acc.add(logs)
+		if err := j.store.addRollups(ctx, acc.sorted(), acc.logs); err != nil {
+			return processed, err
+		}
+		processed += len(logs)
+
+		if len(logs) < j.batch {
+			return processed, nil
+		}
+	}
+}
+
+// backfillRollups rebuilds the rollups for whole days in [from, to) from
+// the raw logs, replacing whatever was there. Days are rebuilt one at a
+// time and each is counted as its pages are read, so only one day's rows
+// are held in memory.
+func backfillRollups(ctx context.Context, store rollupStore, from, to time.Time, batch int) (int, error) {
+	if batch <= 0 {
+		return 0, ErrInvalidRollupBatch
+	}
+	if !from.Equal(rollupDaily.bucket(from)) || !to.Equal(rollupDaily.bucket(to)) || !from.Before(to) {
+		return 0, ErrUnalignedBackfill
+	}
+
+	processed := 0
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+
+		acc := newRollupAccumulator()
+		var after *rollupLog
+		for {
+			page, err := store.getLogsBetween(ctx, day, next, after, batch)
+			if err != nil {
+				return processed, err
+			}
+			acc.add(page)
+			if len(page) < batch {
+				break
+			}
+			after = page[len(page)-1]
+		}
+
+		if err := store.replaceRollups(ctx, day, next, acc.sorted(), acc.logs); err != nil {
+			return processed, err
+		}
+		processed += len(acc.logs)
+	}
+
+	return processed, nil
+}
+
+// parseBackfillArgs reads the backfill command's -from and -to dates
+// (YYYY-MM-DD, UTC); -to is inclusive.
+func parseBackfillArgs(args []string) (time.Time, time.Time, error) {
+	fs := flag.NewFlagSet("tracking-rollup-backfill", flag.ContinueOnError)
+	fromArg := fs.String("from", "", "first day to rebuild, YYYY-MM-DD")
+	toArg := fs.String("to", "", "last day to rebuild, YYYY-MM-DD")
+	if err := fs.Parse(args); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	from, err := time.Parse("2006-01-02", *fromArg)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("bad -from: %w", err)
+	}
+	to, err := time.Parse("2006-01-02", *toArg)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("bad -to: %w", err)
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("-to %s is before -from %s", *toArg, *fromArg)
+	}
+
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+type rollupRange struct {
+	Grain rollupGrain
+	From  time.Time
+	To    time.Time
+}
+
+// planRollupRanges covers [from, to) with daily rollups for whole days and
+// hourly rollups for the partial days at either end. Both ends are
+// truncated to the hour.
+func planRollupRanges(from, to time.Time) []rollupRange {
+	from, to = rollupHourly.bucket(from), rollupHourly.bucket(to)
+	if !from.Before(to) {
+		return nil
+	}
+
+	firstDay := rollupDaily.bucket(from)
+	if firstDay.Before(from) {
+		firstDay = firstDay.AddDate(0, 0, 1)
+	}
+	lastDay := rollupDaily.bucket(to)
+
+	if !firstDay.Before(lastDay) {
+		return []rollupRange{{Grain: rollupHourly, From: from, To: to}}
+	}
+
+	ranges := make([]rollupRange, 0, 3)
+	if from.Before(firstDay) {
+		ranges = append(ranges, rollupRange{Grain: rollupHourly, From: from, To: firstDay})
+	}
+	ranges = append(ranges, rollupRange{Grain: rollupDaily, From: firstDay, To: lastDay})
+	if lastDay.Before(to) {
+		ranges = append(ranges, rollupRange{Grain: rollupHourly, From: lastDay, To: to})
+	}
+
+	return ranges
+}
+
+type rollupSummaryQuery struct {
+	From           time.Time
+	To             time.Time
+	AffiliateID    int64
+	Country        string
+	DeviceType     string
+	IncludeFlagged bool
+	// GroupBy splits each tracking link's row by "country" and/or
+	// "device_type".
+	GroupBy []string
+}
+
+type rollupSummaryRow struct {
+	TrackingID  int64
+	AffiliateID int64
+	Country     string
+	DeviceType  string
+	funnel
+}
+
+// summarizeFromRollups answers the tracking summary from the rollups
+// instead of the raw logs, one funnel row per tracking link and group.
+func summarizeFromRollups(ctx context.Context, store rollupStore, q rollupSummaryQuery) ([]*rollupSummaryRow, error) {
+	var byCountry, byDevice bool
+	for _, g := range q.GroupBy {
+		switch g {
+		case "country":
+			byCountry = true
+		case "device_type":
+			byDevice = true
+		default:
+			return nil, fmt.Errorf("unknown group by %q", g)
+		}
+	}
+
+	type groupKey struct {
+		trackingID int64
+		country    string
+		deviceType string
+	}
+	totals := map[groupKey]*rollupSummaryRow{}
+
+	for _, r := range planRollupRanges(q.From, q.To) {
+		rows, err := store.getRollups(ctx, r.Grain, r.From, r.To)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			if (q.AffiliateID != 0 && row.AffiliateID != q.AffiliateID) ||
+				(q.Country != "" && row.Country != q.Country) ||
+				(q.DeviceType != "" && row.DeviceType != q.DeviceType) {
+				continue
+			}
+
+			key := groupKey{trackingID: row.TrackingID}
+			if byCountry {
+				key.country = row.Country
+			}
+			if byDevice {
+				key.deviceType = row.DeviceType
+			}
+
+			total, ok := totals[key]
+			if !ok {
+				total = &rollupSummaryRow{TrackingID: row.TrackingID, AffiliateID: row.AffiliateID, Country: key.country, DeviceType: key.deviceType}
+				totals[key] = total
+			}
+			total.Clicks += row.Clicks
+			total.Signups += row.Signups
+			total.FTDs += row.FTDs
+			total.FTDAmount += row.FTDAmount
+			if !q.IncludeFlagged {
+				total.Clicks -= row.Flagged
+				total.Signups -= row.FlaggedSignups
+				total.FTDs -= row.FlaggedFTDs
+				total.FTDAmount -= row.FlaggedFTDAmount
+			}
+		}
+	}
+
+	result := make([]*rollupSummaryRow, 0, len(totals))
+	for _, total := range totals {
+		total.setRates()
+		result = append(result, total)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.TrackingID != b.TrackingID {
+			return a.TrackingID < b.TrackingID
+		}
+		if a.Country != b.Country {
+			return a.Country < b.Country
+		}
+		return a.DeviceType < b.DeviceType
+	})
+
+	return result, nil
+}
@@ -0,0 +1,408 @@
+package trackingimpl
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeRollupStore keeps logs and rollups in memory with the same semantics
+// as the tables.
+type fakeRollupStore struct {
+	logs    []*rollupLog
+	rolled  map[rollupLogRef]bool
+	rollups map[rollupKey]*rollupRow
+	err     error
+}
+
+func newFakeRollupStore(logs []*rollupLog) *fakeRollupStore {
+	return &fakeRollupStore{logs: logs, rolled: map[rollupLogRef]bool{}, rollups: map[rollupKey]*rollupRow{}}
+}
+
+func (f *fakeRollupStore) getUnrolledLogs(_ context.Context, limit int) ([]*rollupLog, error) {
+	result := make([]*rollupLog, 0)
+	for _, log := range f.logs {
+		if !f.rolled[log.ref()] && len(result) < limit {
+			result = append(result, log)
+		}
+	}
+	return result, f.err
+}
+
+func (f *fakeRollupStore) mark(logs []rollupLogRef) {
+	for _, ref := range logs {
+		f.rolled[ref] = true
+	}
+}
+
+func (f *fakeRollupStore) addRollups(_ context.Context, rows []*rollupRow, logs []rollupLogRef) error {
+	for _, row := range rows {
+		existing, ok := f.rollups[row.rollupKey]
+		if !ok {
+			existing = &rollupRow{rollupKey: row.rollupKey}
+			f.rollups[row.rollupKey] = existing
+		}
+		existing.Clicks += row.Clicks
+		existing.Flagged += row.Flagged
+		existing.Signups += row.Signups
+		existing.FlaggedSignups += row.FlaggedSignups
+		existing.FTDs += row.FTDs
+		existing.FlaggedFTDs += row.FlaggedFTDs
+		existing.FTDAmount += row.FTDAmount
+		existing.FlaggedFTDAmount += row.FlaggedFTDAmount
+	}
+	f.mark(logs)
+	return nil
+}
+
+func (f *fakeRollupStore) getLogsBetween(_ context.Context, from, to time.Time, after *rollupLog, limit int) ([]*rollupLog, error) {
+	less := func(a, b *rollupLog) bool {
+		if (a.Conversion == "") != (b.Conversion == "") {
+			return a.Conversion == ""
+		}
+		return a.ID < b.ID
+	}
+	sorted := slices.Clone(f.logs)
+	sort.Slice(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+
+	result := make([]*rollupLog, 0)
+	for _, log := range sorted {
+		if (after == nil || less(after, log)) && !log.CreatedAt.Before(from) && log.CreatedAt.Before(to) && len(result) < limit {
+			result = append(result, log)
+		}
+	}
+	return result, f.err
+}
+
+func (f *fakeRollupStore) replaceRollups(_ context.Context, from, to time.Time, rows []*rollupRow, logs []rollupLogRef) error {
+	for key := range f.rollups {
+		if !key.Bucket.Before(from) && key.Bucket.Before(to) {
+			delete(f.rollups, key)
+		}
+	}
+	for _, row := range rows {
+		copied := *row
+		f.rollups[row.rollupKey] = &copied
+	}
+	f.mark(logs)
+	return nil
+}
+
+func (f *fakeRollupStore) getRollups(_ context.Context, grain rollupGrain, from, to time.Time) ([]*rollupRow, error) {
+	result := make([]*rollupRow, 0)
+	for _, row := range f.rollups {
+		if row.Grain == grain && !row.Bucket.Before(from) && row.Bucket.Before(to) {
+			result = append(result, row)
+		}
+	}
+	return result, f.err
+}
+
+func rollupFixture() []*rollupLog {
+	at := func(day, hour int) time.Time {
+		return time.Date(2026, 10, day, hour, 15, 0, 0, time.UTC)
+	}
+
+	return []*rollupLog{
+		{ID: 1, TrackingID: 7, AffiliateID: 3, Country: "PH", DeviceType: "mobile", CreatedAt: at(17, 22)},
+		{ID: 2, TrackingID: 7, AffiliateID: 3, Country: "PH", DeviceType: "mobile", CreatedAt: at(18, 1)},
+		{ID: 3, TrackingID: 7, AffiliateID: 3, Country: "JP", DeviceType: "desktop", CreatedAt: at(18, 1)},
+		{ID: 4, TrackingID: 8, AffiliateID: 4, Country: "PH", DeviceType: "mobile", Flagged: true, CreatedAt: at(18, 9)},
+		{ID: 5, TrackingID: 8, AffiliateID: 4, Country: "PH", DeviceType: "desktop", CreatedAt: at(19, 3)},
+		{ID: 6, TrackingID: 7, AffiliateID: 3, Country: "PH", DeviceType: "mobile", CreatedAt: at(19, 23)},
+		{ID: 1, Conversion: conversionRegistration, TrackingID: 7, AffiliateID: 3, Country: "PH", DeviceType: "mobile", CreatedAt: at(18, 2)},
+		{ID: 2, Conversion: conversionFirstDeposit, Amount: 5000, TrackingID: 7, AffiliateID: 3, Country: "PH", DeviceType: "mobile", CreatedAt: at(18, 3)},
+		{ID: 3, Conversion: conversionRegistration, TrackingID: 8, AffiliateID: 4, Country: "PH", DeviceType: "mobile", Flagged: true, CreatedAt: at(18, 10)},
+	}
+}
+
+func TestAggregateLogs(t *testing.T) {
+	rows := aggregateLogs(rollupFixture()[1:3])
+
+	hour := time.Date(2026, 10, 18, 1, 0, 0, 0, time.UTC)
+	day := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	expected := []rollupRow{
+		{rollupKey: rollupKey{Grain: rollupDaily, Bucket: day, TrackingID: 7, AffiliateID: 3, Country: "JP", DeviceType: "desktop"}, Clicks: 1},
+		{rollupKey: rollupKey{Grain: rollupDaily, Bucket: day, TrackingID: 7, AffiliateID: 3, Country: "PH", DeviceType: "mobile"}, Clicks: 1},
+		{rollupKey: rollupKey{Grain: rollupHourly, Bucket: hour, TrackingID: 7, AffiliateID: 3, Country: "JP", DeviceType: "desktop"}, Clicks: 1},
+		{rollupKey: rollupKey{Grain: rollupHourly, Bucket: hour, TrackingID: 7, AffiliateID: 3, Country: "PH", DeviceType: "mobile"}, Clicks: 1},
+	}
+
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), len(rows))
+	}
+	for i, row := range rows {
+		if *row != expected[i] {
+			t.Errorf("row %d: expected %+v, got %+v", i, expected[i], *row)
+		}
+	}
+}
+
+func TestAggregateLogsConversions(t *testing.T) {
+	logs := rollupFixture()
+	rows := aggregateLogs([]*rollupLog{logs[3], logs[6], logs[7], logs[8]})
+
+	var total rollupRow
+	for _, row := range rows {
+		if row.Grain == rollupDaily {
+			total.Clicks += row.Clicks
+			total.Flagged += row.Flagged
+			total.Signups += row.Signups
+			total.FlaggedSignups += row.FlaggedSignups
+			total.FTDs += row.FTDs
+			total.FTDAmount += row.FTDAmount
+		}
+	}
+	expected := rollupRow{Clicks: 1, Flagged: 1, Signups: 2, FlaggedSignups: 1, FTDs: 1, FTDAmount: 5000}
+	if total != expected {
+		t.Errorf("expected %+v, got %+v", expected, total)
+	}
+}
+
+func TestRollupJobIncremental(t *testing.T) {
+	logs := rollupFixture()[:6]
+	// Log 3 commits after log 4 and is not visible to the first run.
+	store := newFakeRollupStore([]*rollupLog{logs[0], logs[1], logs[3]})
+	job := &rollupJob{store: store, batch: 2}
+
+	processed, err := job.run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if processed != 3 || len(store.rolled) != 3 {
+		t.Errorf("expected 3 logs rolled up, got %d and %d marked", processed, len(store.rolled))
+	}
+
+	store.logs = logs
+	processed, err = job.run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if processed != 3 || len(store.rolled) != 6 {
+		t.Errorf("expected 3 more logs rolled up, got %d and %d marked", processed, len(store.rolled))
+	}
+
+	late := rollupKey{Grain: rollupDaily, Bucket: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), TrackingID: 7, AffiliateID: 3, Country: "JP", DeviceType: "desktop"}
+	if row := store.rollups[late]; row == nil || row.Clicks != 1 {
+		t.Errorf("expected the late log counted in %+v, got %+v", late, row)
+	}
+	day := rollupKey{Grain: rollupDaily, Bucket: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), TrackingID: 7, AffiliateID: 3, Country: "PH", DeviceType: "mobile"}
+	if row := store.rollups[day]; row == nil || row.Clicks != 1 {
+		t.Errorf("expected 1 click in %+v, got %+v", day, row)
+	}
+
+	if processed, _ := job.run(context.Background()); processed != 0 {
+		t.Errorf("expected no logs on a caught up run, got %d", processed)
+	}
+}
+
+func TestRollupJobError(t *testing.T) {
+	store := newFakeRollupStore(nil)
+	store.err = errors.New("test error")
+
+	if _, err := (&rollupJob{store: store, batch: 10}).run(context.Background()); err == nil {
+		t.Errorf("expected %v, got none", store.err)
+	}
+}
+
+func TestRollupInvalidBatch(t *testing.T) {
+	store := newFakeRollupStore(rollupFixture())
+	from := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	for _, batch := range []int{0, -1} {
+		if _, err := (&rollupJob{store: store, batch: batch}).run(context.Background()); !errors.Is(err, ErrInvalidRollupBatch) {
+			t.Errorf("batch %d: expected %v, got %v", batch, ErrInvalidRollupBatch, err)
+		}
+		if _, err := backfillRollups(context.Background(), store, from, from.AddDate(0, 0, 1), batch); !errors.Is(err, ErrInvalidRollupBatch) {
+			t.Errorf("batch %d: expected %v, got %v", batch, ErrInvalidRollupBatch, err)
+		}
+	}
+}
+
+func TestBackfillRollups(t *testing.T) {
+	store := newFakeRollupStore(rollupFixture())
+	stale := rollupKey{Grain: rollupDaily, Bucket: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), TrackingID: 99}
+	store.rollups[stale] = &rollupRow{rollupKey: stale, Clicks: 50}
+
+	from, to, err := parseBackfillArgs([]string{"-from", "2026-10-18", "-to", "2026-10-18"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	processed, err := backfillRollups(context.Background(), store, from, to, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if processed != 6 || len(store.rolled) != 6 {
+		t.Errorf("expected 3 clicks and 3 conversions, got %d and %d marked", processed, len(store.rolled))
+	}
+	if _, ok := store.rollups[stale]; ok {
+		t.Errorf("expected stale rollup to be replaced")
+	}
+	if len(store.rollups) != 9 {
+		t.Errorf("expected 6 hourly and 3 daily rows, got %d", len(store.rollups))
+	}
+
+	if processed, _ := (&rollupJob{store: store, batch: 10}).run(context.Background()); processed != 3 {
+		t.Errorf("expected only the logs outside the backfill left, got %d", processed)
+	}
+}
+
+func TestBackfillRollupsDays(t *testing.T) {
+	store := newFakeRollupStore(rollupFixture())
+	from := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+	processed, err := backfillRollups(context.Background(), store, from, from.AddDate(0, 0, 3), 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if processed != 9 {
+		t.Errorf("expected 9 logs, got %d", processed)
+	}
+	day := rollupKey{Grain: rollupDaily, Bucket: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), TrackingID: 8, AffiliateID: 4, Country: "PH", DeviceType: "desktop"}
+	if row := store.rollups[day]; row == nil || row.Clicks != 1 {
+		t.Errorf("expected 1 click in %+v, got %+v", day, row)
+	}
+}
+
+func TestBackfillRollupsArgs(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "backfill error - missing from", args: []string{"-to", "2026-10-18"}},
+		{name: "backfill error - bad date", args: []string{"-from", "18/10/2026", "-to", "2026-10-18"}},
+		{name: "backfill error - reversed", args: []string{"-from", "2026-10-18", "-to", "2026-10-17"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := parseBackfillArgs(tc.args); err == nil {
+				t.Errorf("expected error, got none")
+			}
+		})
+	}
+
+	from := time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC)
+	if _, err := backfillRollups(context.Background(), newFakeRollupStore(nil), from, from.AddDate(0, 0, 1), 10); !errors.Is(err, ErrUnalignedBackfill) {
+		t.Errorf("expected %v, got %v", ErrUnalignedBackfill, err)
+	}
+}
+
+func TestPlanRollupRanges(t *testing.T) {
+	at := func(day, hour int) time.Time {
+		return time.Date(2026, 10, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name           string
+		from           time.Time
+		to             time.Time
+		expectedResult []rollupRange
+	}{
+		{
+			name: "plan partial days around whole days",
+			from: at(17, 20),
+			to:   at(19, 4),
+			expectedResult: []rollupRange{
+				{Grain: rollupHourly, From: at(17, 20), To: at(18, 0)},
+				{Grain: rollupDaily, From: at(18, 0), To: at(19, 0)},
+				{Grain: rollupHourly, From: at(19, 0), To: at(19, 4)},
+			},
+		},
+		{
+			name: "plan whole days",
+			from: at(17, 0),
+			to:   at(19, 0),
+			expectedResult: []rollupRange{
+				{Grain: rollupDaily, From: at(17, 0), To: at(19, 0)},
+			},
+		},
+		{
+			name: "plan within a day",
+			from: at(18, 2),
+			to:   at(18, 9),
+			expectedResult: []rollupRange{
+				{Grain: rollupHourly, From: at(18, 2), To: at(18, 9)},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := planRollupRanges(tc.from, tc.to)
+			if !reflect.DeepEqual(result, tc.expectedResult) {
+				t.Errorf("expected %+v, got %+v", tc.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestSummarizeFromRollups(t *testing.T) {
+	store := newFakeRollupStore(rollupFixture())
+	if _, err := (&rollupJob{store: store, batch: 100}).run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	from := time.Date(2026, 10, 17, 22, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 10, 19, 4, 0, 0, 0, time.UTC)
+	row := func(trackingID, affiliateID, clicks, signups, ftds, amount int64) *rollupSummaryRow {
+		f := funnel{Clicks: clicks, Signups: signups, FTDs: ftds, FTDAmount: amount}
+		f.setRates()
+		return &rollupSummaryRow{TrackingID: trackingID, AffiliateID: affiliateID, funnel: f}
+	}
+
+	testCases := []struct {
+		name           string
+		query          rollupSummaryQuery
+		expectedResult []*rollupSummaryRow
+	}{
+		{
+			name:  "summary from rollups",
+			query: rollupSummaryQuery{From: from, To: to},
+			expectedResult: []*rollupSummaryRow{
+				row(7, 3, 3, 1, 1, 5000),
+				row(8, 4, 1, 0, 0, 0),
+			},
+		},
+		{
+			name:  "summary from rollups - include flagged",
+			query: rollupSummaryQuery{From: from, To: to, IncludeFlagged: true},
+			expectedResult: []*rollupSummaryRow{
+				row(7, 3, 3, 1, 1, 5000),
+				row(8, 4, 2, 1, 0, 0),
+			},
+		},
+		{
+			name:  "summary from rollups - by country and device",
+			query: rollupSummaryQuery{From: from, To: to, Country: "PH", DeviceType: "mobile"},
+			expectedResult: []*rollupSummaryRow{
+				row(7, 3, 2, 1, 1, 5000),
+				row(8, 4, 0, 0, 0, 0),
+			},
+		},
+		{
+			name:  "summary from rollups - by affiliate",
+			query: rollupSummaryQuery{From: from, To: to, AffiliateID: 4},
+			expectedResult: []*rollupSummaryRow{
+				row(8, 4, 1, 0, 0, 0),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := summarizeFromRollups(context.Background(), store, tc.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tc.expectedResult) {
+				t.Errorf("expected %+v, got %+v", tc.expectedResult, result)
+			}
+		})
+	}
+}
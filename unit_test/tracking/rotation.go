@@ -0,0 +1,176 @@
+package trackingimpl
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidRotation = errors.New("invalid destination rotation")
+
+// visitorCookie keeps a visitor on the same variant across clicks.
+const (
+	visitorCookie    = "trk_vid"
+	visitorCookieAge = 365 * 24 * time.Hour
+)
+
+// Variants are compared only once each side has this many clicks, and a
+// difference counts as significant below this p-value.
+const (
+	minVariantClicks  = 100
+	significanceLevel = 0.05
+)
+
+// destinationVariant is one weighted landing page of a tracking link.
+type destinationVariant struct {
+	Variant string
+	URL     string
+	Weight  int
+}
+
+// validateRotation checks the variants a tracking link rotates between:
+// unique non-empty names, positive weights and absolute http(s) URLs.
+func validateRotation(variants []destinationVariant) error {
+	if len(variants) == 0 {
+		return fmt.Errorf("%w: no destinations", ErrInvalidRotation)
+	}
+
+	seen := map[string]bool{}
+	for _, v := range variants {
+		if v.Variant == "" || seen[v.Variant] {
+			return fmt.Errorf("%w: variant names must be unique and not empty", ErrInvalidRotation)
+		}
+		seen[v.Variant] = true
+
+		if v.Weight <= 0 {
+			return fmt.Errorf("%w: variant %s has weight %d", ErrInvalidRotation, v.Variant, v.Weight)
+		}
+
+		u, err := url.Parse(v.URL)
+		if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("%w: variant %s has bad url %q", ErrInvalidRotation, v.Variant, v.URL)
+		}
+	}
+
+	return nil
+}
+
+// pickVariant chooses a variant in proportion to the weights. The choice
+// is a hash of the tracking link and visitor, so a returning visitor gets
+// the same variant for as long as the weights do not change.
+func pickVariant(variants []destinationVariant, trackingID int64, visitorID string) destinationVariant {
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strconv.FormatInt(trackingID, 10) + ":" + visitorID))
+	point := int(h.Sum64() % uint64(total))
+
+	for _, v := range variants {
+		if point < v.Weight {
+			return v
+		}
+		point -= v.Weight
+	}
+
+	return variants[len(variants)-1]
+}
+
+// visitorID returns the visitor cookie, setting a new one when the request
+// has none.
+func visitorID(w http.ResponseWriter, r *http.Request, newID func() string) string {
+	if c, err := r.Cookie(visitorCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+
+	id := newID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     visitorCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(visitorCookieAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return id
+}
+
+type variantStats struct {
+	Variant        string
+	Clicks         int64
+	Conversions    int64
+	ConversionRate float64
+	// ZScore and PValue compare the variant with the control, the first
+	// variant. Significant is set when both have minVariantClicks and the
+	// p-value is below significanceLevel.
+	ZScore      float64
+	PValue      float64
+	Significant bool
+}
+
+// compareVariants reports the conversion rate of each variant in the order
+// given, testing each against the first with a two-proportion z-test.
+func compareVariants(variants []string, clicks, conversions map[string]int64) []*variantStats {
+	result := make([]*variantStats, 0, len(variants))
+	for _, name := range variants {
+		result = append(result, &variantStats{
+			Variant:        name,
+			Clicks:         clicks[name],
+			Conversions:    conversions[name],
+			ConversionRate: percentage(conversions[name], clicks[name]),
+			PValue:         1,
+		})
+	}
+	if len(result) < 2 {
+		return result
+	}
+
+	control := result[0]
+	for _, s := range result[1:] {
+		if control.Clicks == 0 || s.Clicks == 0 {
+			continue
+		}
+
+		n1, n2 := float64(control.Clicks), float64(s.Clicks)
+		p1, p2 := float64(control.Conversions)/n1, float64(s.Conversions)/n2
+		pooled := float64(control.Conversions+s.Conversions) / (n1 + n2)
+		se := math.Sqrt(pooled * (1 - pooled) * (1/n1 + 1/n2))
+		if se == 0 {
+			continue
+		}
+
+		s.ZScore = (p2 - p1) / se
+		s.PValue = math.Erfc(math.Abs(s.ZScore) / math.Sqrt2)
+		s.Significant = control.Clicks >= minVariantClicks && s.Clicks >= minVariantClicks && s.PValue < significanceLevel
+	}
+
+	return result
+}
+
+// variantCounts counts clicks and conversions of type t per variant for
+// compareVariants. Flagged clicks are left out.
+func variantCounts(clicks []*clickEvent, conversions []*conversion, t conversionType) (map[string]int64, map[string]int64) {
+	clickCounts := map[string]int64{}
+	for _, e := range clicks {
+		if e.FlagReason == "" && e.Variant != "" {
+			clickCounts[e.Variant]++
+		}
+	}
+
+	conversionCounts := map[string]int64{}
+	for _, c := range conversions {
+		if c.Type == t && c.Variant != "" {
+			conversionCounts[c.Variant]++
+		}
+	}
+
+	return clickCounts, conversionCounts
+}
@@ -0,0 +1,216 @@
+package trackingimpl
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestValidateRotation(t *testing.T) {
+	testCases := []struct {
+		name          string
+		variants      []destinationVariant
+		expectedError error
+	}{
+		{
+			name: "validate rotation success",
+			variants: []destinationVariant{
+				{Variant: "A", URL: "https://brand.example/a", Weight: 70},
+				{Variant: "B", URL: "https://brand.example/b", Weight: 30},
+			},
+		},
+		{name: "validate rotation error - empty", expectedError: ErrInvalidRotation},
+		{
+			name: "validate rotation error - duplicate variant",
+			variants: []destinationVariant{
+				{Variant: "A", URL: "https://brand.example/a", Weight: 1},
+				{Variant: "A", URL: "https://brand.example/b", Weight: 1},
+			},
+			expectedError: ErrInvalidRotation,
+		},
+		{
+			name:          "validate rotation error - zero weight",
+			variants:      []destinationVariant{{Variant: "A", URL: "https://brand.example/a"}},
+			expectedError: ErrInvalidRotation,
+		},
+		{
+			name:          "validate rotation error - relative url",
+			variants:      []destinationVariant{{Variant: "A", URL: "/a", Weight: 1}},
+			expectedError: ErrInvalidRotation,
+		},
+		{
+			name:          "validate rotation error - javascript url",
+			variants:      []destinationVariant{{Variant: "A", URL: "javascript:alert(1)", Weight: 1}},
+			expectedError: ErrInvalidRotation,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRotation(tc.variants)
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("expected %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestPickVariant(t *testing.T) {
+	variants := []destinationVariant{
+		{Variant: "A", URL: "https://brand.example/a", Weight: 70},
+		{Variant: "B", URL: "https://brand.example/b", Weight: 20},
+		{Variant: "C", URL: "https://brand.example/c", Weight: 10},
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 20000; i++ {
+		visitor := "visitor-" + strconv.Itoa(i)
+		v := pickVariant(variants, 7, visitor)
+		if again := pickVariant(variants, 7, visitor); again != v {
+			t.Fatalf("visitor %s got %s then %s", visitor, v.Variant, again.Variant)
+		}
+		counts[v.Variant]++
+	}
+
+	for _, v := range variants {
+		share := float64(counts[v.Variant]) / 20000 * 100
+		if math.Abs(share-float64(v.Weight)) > 2 {
+			t.Errorf("variant %s: expected about %d%%, got %.1f%%", v.Variant, v.Weight, share)
+		}
+	}
+}
+
+func TestRedirectHandlerRotation(t *testing.T) {
+	resolver := &fakeResolver{targets: map[string]*redirectTarget{
+		"abc123": {TrackingID: 7, AffiliateID: 3, Variants: []destinationVariant{
+			{Variant: "A", URL: "https://brand.example/a", Weight: 50},
+			{Variant: "B", URL: "https://brand.example/b", Weight: 50},
+		}},
+	}}
+	sink := &fakeSink{}
+
+	handler, err := newRedirectHandler(redirectConfig{PathPrefix: "/t/"}, resolver, sink)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.clickID = func() string { return "c1" }
+	handler.visitorID = func() string { return "v1" }
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/t/abc123", nil))
+
+	cookies := first.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != visitorCookie || cookies[0].Value != "v1" || !cookies[0].HttpOnly {
+		t.Fatalf("expected visitor cookie v1, got %v", cookies)
+	}
+
+	expected := pickVariant(resolver.targets["abc123"].Variants, 7, "v1")
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/t/abc123", nil)
+		req.AddCookie(cookies[0])
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if len(rec.Result().Cookies()) != 0 {
+			t.Errorf("expected the visitor cookie to be kept, got %v", rec.Result().Cookies())
+		}
+		if location := rec.Header().Get("Location"); location != expected.URL+"?click_id=c1" {
+			t.Errorf("expected %s, got %s", expected.URL+"?click_id=c1", location)
+		}
+	}
+
+	for _, e := range sink.events {
+		if e.Variant != expected.Variant {
+			t.Errorf("expected variant %s recorded, got %q", expected.Variant, e.Variant)
+		}
+	}
+}
+
+func TestCompareVariants(t *testing.T) {
+	testCases := []struct {
+		name                string
+		clicks              map[string]int64
+		conversions         map[string]int64
+		expectedZ           float64
+		expectedP           float64
+		expectedSignificant bool
+	}{
+		{
+			name:                "compare variants - significant",
+			clicks:              map[string]int64{"A": 1000, "B": 1000},
+			conversions:         map[string]int64{"A": 100, "B": 130},
+			expectedZ:           2.1027,
+			expectedP:           0.0355,
+			expectedSignificant: true,
+		},
+		{
+			name:        "compare variants - not significant",
+			clicks:      map[string]int64{"A": 1000, "B": 1000},
+			conversions: map[string]int64{"A": 100, "B": 110},
+			expectedZ:   0.7294,
+			expectedP:   0.4657,
+		},
+		{
+			name:        "compare variants - too few clicks",
+			clicks:      map[string]int64{"A": 50, "B": 50},
+			conversions: map[string]int64{"A": 2, "B": 15},
+			expectedZ:   3.4608,
+			expectedP:   0.0005,
+		},
+		{
+			name:        "compare variants - no clicks",
+			clicks:      map[string]int64{"A": 100},
+			conversions: map[string]int64{"A": 10},
+			expectedP:   1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := compareVariants([]string{"A", "B"}, tc.clicks, tc.conversions)
+			if len(result) != 2 {
+				t.Fatalf("expected 2 variants, got %d", len(result))
+			}
+
+			control, b := result[0], result[1]
+			if control.ZScore != 0 || control.PValue != 1 || control.Significant {
+				t.Errorf("expected the control untested, got %+v", control)
+			}
+			if want := percentage(tc.conversions["B"], tc.clicks["B"]); b.ConversionRate != want {
+				t.Errorf("expected conversion rate %v, got %v", want, b.ConversionRate)
+			}
+			if math.Abs(b.ZScore-tc.expectedZ) > 0.001 || math.Abs(b.PValue-tc.expectedP) > 0.001 {
+				t.Errorf("expected z %v p %v, got z %v p %v", tc.expectedZ, tc.expectedP, b.ZScore, b.PValue)
+			}
+			if b.Significant != tc.expectedSignificant {
+				t.Errorf("expected significant %v, got %v", tc.expectedSignificant, b.Significant)
+			}
+		})
+	}
+}
+
+func TestVariantCounts(t *testing.T) {
+	clicks := []*clickEvent{
+		{Variant: "A"},
+		{Variant: "A"},
+		{Variant: "A", FlagReason: fraudDuplicate},
+		{Variant: "B"},
+		{},
+	}
+	conversions := []*conversion{
+		{Variant: "A", Type: conversionRegistration},
+		{Variant: "A", Type: conversionFirstDeposit},
+		{Variant: "B", Type: conversionFirstDeposit},
+	}
+
+	clickCounts, conversionCounts := variantCounts(clicks, conversions, conversionFirstDeposit)
+	if len(clickCounts) != 2 || clickCounts["A"] != 2 || clickCounts["B"] != 1 {
+		t.Errorf("unexpected click counts %v", clickCounts)
+	}
+	if len(conversionCounts) != 2 || conversionCounts["A"] != 1 || conversionCounts["B"] != 1 {
+		t.Errorf("unexpected conversion counts %v", conversionCounts)
+	}
+}
@@ -0,0 +1,143 @@
+package trackingimpl
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrShortCodeTaken   = errors.New("short code is already taken")
+	ErrInvalidSlug      = errors.New("invalid vanity slug")
+	ErrShortCodeExhaust = errors.New("could not find a free short code")
+)
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const (
+	shortCodeLength   = 7
+	shortCodeAttempts = 5
+	slugMinLength     = 3
+	slugMaxLength     = 32
+)
+
+// reservedSlugs are paths the short link host serves itself.
+var reservedSlugs = map[string]bool{
+	"admin": true, "api": true, "app": true, "assets": true, "health": true,
+	"login": true, "postback": true, "qr": true, "static": true, "t": true,
+}
+
+type shortCodeStore interface {
+	// shortCodeTaken reports whether a tracking other than trackingID uses
+	// the code, like TrackingTaken does for names.
+	shortCodeTaken(ctx context.Context, code string, trackingID int64) (bool, error)
+	// saveShortCode returns ErrShortCodeTaken when the unique index rejects
+	// the code.
+	saveShortCode(ctx context.Context, trackingID int64, code string) error
+}
+
+// randomShortCode returns n base62 characters from crypto/rand. Bytes of
+// 248 and above are rejected so every character is equally likely.
+func randomShortCode(n int) (string, error) {
+	const limit = 256 - 256%len(base62Alphabet)
+
+	code := make([]byte, 0, n)
+	buf := make([]byte, n+n/2)
+	for len(code) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(code) < n {
+				code = append(code, base62Alphabet[int(b)%len(base62Alphabet)])
+			}
+		}
+	}
+
+	return string(code), nil
+}
+
+// validateVanitySlug normalizes a custom slug to lower case and checks it
+// is 3 to 32 letters, digits or single inner hyphens and not reserved.
+func validateVanitySlug(slug string) (string, error) {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+
+	if len(slug) < slugMinLength || len(slug) > slugMaxLength {
+		return "", fmt.Errorf("%w: must be %d to %d characters", ErrInvalidSlug, slugMinLength, slugMaxLength)
+	}
+	for i := 0; i < len(slug); i++ {
+		c := slug[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return "", fmt.Errorf("%w: %q is not allowed", ErrInvalidSlug, c)
+		}
+	}
+	if slug[0] == '-' || slug[len(slug)-1] == '-' || strings.Contains(slug, "--") {
+		return "", fmt.Errorf("%w: hyphens must separate words", ErrInvalidSlug)
+	}
+	if reservedSlugs[slug] {
+		return "", fmt.Errorf("%w: %q is reserved", ErrInvalidSlug, slug)
+	}
+
+	return slug, nil
+}
+
+// shortCodeAssigner gives a tracking link its short code.
+type shortCodeAssigner struct {
+	store  shortCodeStore
+	random func(n int) (string, error)
+}
+
+func newShortCodeAssigner(store shortCodeStore) *shortCodeAssigner {
+	return &shortCodeAssigner{store: store, random: randomShortCode}
+}
+
+// assign saves the vanity slug when one is given, otherwise a random code.
+// Random codes are retried on collision, growing by one character after
+// every shortCodeAttempts tries.
+func (a *shortCodeAssigner) assign(ctx context.Context, trackingID int64, vanity string) (string, error) {
+	if vanity != "" {
+		slug, err := validateVanitySlug(vanity)
+		if err != nil {
+			return "", err
+		}
+
+		taken, err := a.store.shortCodeTaken(ctx, slug, trackingID)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "", fmt.Errorf("%w: %q", ErrShortCodeTaken, slug)
+		}
+
+		return slug, a.store.saveShortCode(ctx, trackingID, slug)
+	}
+
+	for length := shortCodeLength; length < shortCodeLength+3; length++ {
+		for attempt := 0; attempt < shortCodeAttempts; attempt++ {
+			code, err := a.random(length)
+			if err != nil {
+				return "", err
+			}
+
+			taken, err := a.store.shortCodeTaken(ctx, code, trackingID)
+			if err != nil {
+				return "", err
+			}
+			if taken {
+				continue
+			}
+
+			// Another request may have saved the same code since the check.
+			err = a.store.saveShortCode(ctx, trackingID, code)
+			if errors.Is(err, ErrShortCodeTaken) {
+				continue
+			}
+
+			return code, err
+		}
+	}
+
+	return "", ErrShortCodeExhaust
+}
@@ -0,0 +1,194 @@
+package trackingimpl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeShortCodeStore has a unique index on the code. raceOn codes pass the
+// taken check but fail on save, as if another request saved them first.
+type fakeShortCodeStore struct {
+	codes  map[string]int64
+	raceOn map[string]bool
+	err    error
+}
+
+func (f *fakeShortCodeStore) shortCodeTaken(_ context.Context, code string, trackingID int64) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	owner, ok := f.codes[code]
+	return ok && owner != trackingID, nil
+}
+
+func (f *fakeShortCodeStore) saveShortCode(_ context.Context, trackingID int64, code string) error {
+	if owner, ok := f.codes[code]; (ok && owner != trackingID) || f.raceOn[code] {
+		return ErrShortCodeTaken
+	}
+	f.codes[code] = trackingID
+	return nil
+}
+
+func sequence(codes ...string) func(int) (string, error) {
+	return func(int) (string, error) {
+		code := codes[0]
+		codes = codes[1:]
+		return code, nil
+	}
+}
+
+func TestRandomShortCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		code, err := randomShortCode(shortCodeLength)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(code) != shortCodeLength {
+			t.Fatalf("expected %d characters, got %q", shortCodeLength, code)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(base62Alphabet, c) {
+				t.Fatalf("unexpected character %q in %q", c, code)
+			}
+		}
+		seen[code] = true
+	}
+
+	if len(seen) < 200 {
+		t.Errorf("expected 200 distinct codes, got %d", len(seen))
+	}
+}
+
+func TestShortCodeAssignerGenerated(t *testing.T) {
+	testCases := []struct {
+		name           string
+		codes          []string
+		taken          map[string]int64
+		raceOn         map[string]bool
+		expectedResult string
+		expectedError  error
+	}{
+		{
+			name:           "assign short code success",
+			codes:          []string{"aB3dE5f"},
+			taken:          map[string]int64{},
+			expectedResult: "aB3dE5f",
+		},
+		{
+			name:           "assign short code success - collision retried",
+			codes:          []string{"aaaaaaa", "bbbbbbb"},
+			taken:          map[string]int64{"aaaaaaa": 2},
+			expectedResult: "bbbbbbb",
+		},
+		{
+			name:           "assign short code success - lost race retried",
+			codes:          []string{"ccccccc", "ddddddd"},
+			taken:          map[string]int64{},
+			raceOn:         map[string]bool{"ccccccc": true},
+			expectedResult: "ddddddd",
+		},
+		{
+			name:           "assign short code success - longer after repeated collisions",
+			codes:          []string{"x", "x", "x", "x", "x", "eeeeeeee"},
+			taken:          map[string]int64{"x": 2},
+			expectedResult: "eeeeeeee",
+		},
+		{
+			name:          "assign short code error - exhausted",
+			codes:         []string{"x", "x", "x", "x", "x", "x", "x", "x", "x", "x", "x", "x", "x", "x", "x"},
+			taken:         map[string]int64{"x": 2},
+			expectedError: ErrShortCodeExhaust,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &fakeShortCodeStore{codes: tc.taken, raceOn: tc.raceOn}
+			lengths := make([]int, 0)
+			codes := sequence(tc.codes...)
+			assigner := &shortCodeAssigner{store: store, random: func(n int) (string, error) {
+				lengths = append(lengths, n)
+				return codes(n)
+			}}
+
+			result, err := assigner.assign(context.Background(), 1, "")
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if result != tc.expectedResult {
+				t.Errorf("expected %q, got %q", tc.expectedResult, result)
+			}
+			if tc.expectedError == nil && store.codes[result] != 1 {
+				t.Errorf("expected %q saved for tracking 1, got %v", result, store.codes)
+			}
+			if len(lengths) > shortCodeAttempts && lengths[shortCodeAttempts] != shortCodeLength+1 {
+				t.Errorf("expected length %d after %d collisions, got %v", shortCodeLength+1, shortCodeAttempts, lengths)
+			}
+		})
+	}
+}
+
+func TestValidateVanitySlug(t *testing.T) {
+	testCases := []struct {
+		name           string
+		slug           string
+		expectedResult string
+		expectedError  error
+	}{
+		{name: "validate slug success", slug: "spring-promo", expectedResult: "spring-promo"},
+		{name: "validate slug success - normalized", slug: " Summer2026 ", expectedResult: "summer2026"},
+		{name: "validate slug error - too short", slug: "ab", expectedError: ErrInvalidSlug},
+		{name: "validate slug error - too long", slug: strings.Repeat("a", 33), expectedError: ErrInvalidSlug},
+		{name: "validate slug error - bad character", slug: "promo_1", expectedError: ErrInvalidSlug},
+		{name: "validate slug error - slash", slug: "promo/1", expectedError: ErrInvalidSlug},
+		{name: "validate slug error - leading hyphen", slug: "-promo", expectedError: ErrInvalidSlug},
+		{name: "validate slug error - double hyphen", slug: "pro--mo", expectedError: ErrInvalidSlug},
+		{name: "validate slug error - reserved", slug: "Admin", expectedError: ErrInvalidSlug},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := validateVanitySlug(tc.slug)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if result != tc.expectedResult {
+				t.Errorf("expected %q, got %q", tc.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestShortCodeAssignerVanity(t *testing.T) {
+	testCases := []struct {
+		name          string
+		trackingID    int64
+		slug          string
+		expectedError error
+	}{
+		{name: "assign vanity success", trackingID: 1, slug: "Spring-Promo"},
+		{name: "assign vanity success - own slug", trackingID: 2, slug: "taken-slug"},
+		{name: "assign vanity error - taken", trackingID: 1, slug: "taken-slug", expectedError: ErrShortCodeTaken},
+		{name: "assign vanity error - invalid", trackingID: 1, slug: "a b", expectedError: ErrInvalidSlug},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &fakeShortCodeStore{codes: map[string]int64{"taken-slug": 2}}
+			assigner := newShortCodeAssigner(store)
+
+			_, err := assigner.assign(context.Background(), tc.trackingID, tc.slug)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+
+	store := &fakeShortCodeStore{err: errors.New("test error")}
+	if _, err := newShortCodeAssigner(store).assign(context.Background(), 1, "spring-promo"); err == nil {
+		t.Errorf("expected %v, got none", store.err)
+	}
+}
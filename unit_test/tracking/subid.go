@@ -0,0 +1,146 @@
+package trackingimpl
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidSubIDParam = errors.New("not a sub-ID or UTM parameter")
+
+var utmParams = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+// maxParamLength matches the varchar(255) columns the values are stored in.
+const maxParamLength = 255
+
+// isTrackingParam reports whether name is one of sub1..sub5 or the UTM
+// parameters.
+func isTrackingParam(name string) bool {
+	return slices.Contains(subIDParams, name) || slices.Contains(utmParams, name)
+}
+
+// captureParams copies the non-empty sub-ID and UTM parameters from the
+// click URL, trimmed and cut to maxParamLength bytes on a rune boundary.
+func captureParams(query url.Values) map[string]string {
+	params := map[string]string{}
+	for _, names := range [][]string{subIDParams, utmParams} {
+		for _, name := range names {
+			v := strings.TrimSpace(query.Get(name))
+			if v == "" {
+				continue
+			}
+			if len(v) > maxParamLength {
+				cut := maxParamLength
+				for cut > 0 && !utf8.RuneStart(v[cut]) {
+					cut--
+				}
+				v = v[:cut]
+			}
+			params[name] = v
+		}
+	}
+
+	return params
+}
+
+// bannerParam is added to tracking URLs by banner embed code.
+const bannerParam = "banner_id"
+
+// captureBannerID returns the banner the click came from, or 0 when
+// bannerParam is missing or not a positive ID.
+func captureBannerID(query url.Values) int64 {
+	id, err := strconv.ParseInt(strings.TrimSpace(query.Get(bannerParam)), 10, 64)
+	if err != nil || id <= 0 {
+		return 0
+	}
+
+	return id
+}
+
+type subIDSummaryQuery struct {
+	AffiliateID int64
+	// Filters keeps clicks and conversions whose parameter equals the value.
+	Filters map[string]string
+	// GroupBy lists the parameters to break the summary down by, in order.
+	GroupBy []string
+	// IncludeFlagged counts flagged clicks and their conversions.
+	IncludeFlagged bool
+}
+
+// subIDSummaryRow is one combination of GroupBy values; Group holds them in
+// GroupBy order with "" for clicks that did not pass the parameter.
+type subIDSummaryRow struct {
+	Group []string
+	funnel
+}
+
+// summarizeBySubID breaks one affiliate's clicks and conversions down by
+// the sub-ID and UTM parameters they were captured with.
+func summarizeBySubID(q subIDSummaryQuery, clicks []*clickEvent, conversions []*conversion) ([]*subIDSummaryRow, error) {
+	for name := range q.Filters {
+		if !isTrackingParam(name) {
+			return nil, fmt.Errorf("%w: filter %q", ErrInvalidSubIDParam, name)
+		}
+	}
+	for _, name := range q.GroupBy {
+		if !isTrackingParam(name) {
+			return nil, fmt.Errorf("%w: group by %q", ErrInvalidSubIDParam, name)
+		}
+	}
+
+	matches := func(affiliateID int64, params map[string]string) bool {
+		if affiliateID != q.AffiliateID {
+			return false
+		}
+		for name, want := range q.Filters {
+			if params[name] != want {
+				return false
+			}
+		}
+		return true
+	}
+	groupOf := func(params map[string]string) []string {
+		group := make([]string, len(q.GroupBy))
+		for i, name := range q.GroupBy {
+			group[i] = params[name]
+		}
+		return group
+	}
+
+	clickCounts := map[string]int64{}
+	grouped := map[string][]*conversion{}
+	groups := map[string][]string{}
+	for _, e := range clicks {
+		if (e.FlagReason != "" && !q.IncludeFlagged) || !matches(e.AffiliateID, e.Params) {
+			continue
+		}
+		group := groupOf(e.Params)
+		key := fmt.Sprintf("%q", group)
+		groups[key] = group
+		clickCounts[key]++
+	}
+	for _, c := range conversions {
+		if (c.FlagReason != "" && !q.IncludeFlagged) || !matches(c.AffiliateID, c.Params) {
+			continue
+		}
+		group := groupOf(c.Params)
+		key := fmt.Sprintf("%q", group)
+		groups[key] = group
+		grouped[key] = append(grouped[key], c)
+	}
+
+	result := make([]*subIDSummaryRow, 0, len(groups))
+	for key, group := range groups {
+		result = append(result, &subIDSummaryRow{Group: group, funnel: buildFunnel(clickCounts[key], grouped[key])})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return slices.Compare(result[i].Group, result[j].Group) < 0
+	})
+
+	return result, nil
+}
@@ -0,0 +1,129 @@
+package trackingimpl
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCaptureParams(t *testing.T) {
+	query := url.Values{
+		"sub1":         {" spring "},
+		"sub3":         {""},
+		"utm_campaign": {strings.Repeat("a", 254) + "é"},
+		"utm_source":   {"newsletter"},
+		"gclid":        {"abc"},
+	}
+
+	result := captureParams(query)
+
+	expected := map[string]string{
+		"sub1":         "spring",
+		"utm_campaign": strings.Repeat("a", 254),
+		"utm_source":   "newsletter",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestCaptureBannerID(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		expectedID int64
+	}{
+		{name: "capture banner id success", query: "banner_id=12&sub1=spring", expectedID: 12},
+		{name: "capture banner id success - missing", query: "sub1=spring"},
+		{name: "capture banner id success - malformed", query: "banner_id=abc"},
+		{name: "capture banner id success - not positive", query: "banner_id=-4"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tc.query)
+			if id := captureBannerID(query); id != tc.expectedID {
+				t.Errorf("expected %d, got %d", tc.expectedID, id)
+			}
+		})
+	}
+}
+
+func TestSummarizeBySubID(t *testing.T) {
+	spring := map[string]string{"sub1": "spring", "sub2": "banner", "utm_source": "newsletter"}
+	springText := map[string]string{"sub1": "spring", "sub2": "text"}
+	autumn := map[string]string{"sub1": "autumn", "sub2": "banner"}
+
+	clicks := []*clickEvent{
+		{AffiliateID: 3, Params: spring},
+		{AffiliateID: 3, Params: spring},
+		{AffiliateID: 3, Params: spring, FlagReason: fraudBotUserAgent},
+		{AffiliateID: 3, Params: springText},
+		{AffiliateID: 3, Params: autumn},
+		{AffiliateID: 3, Params: map[string]string{}},
+		{AffiliateID: 4, Params: spring},
+	}
+	conversions := []*conversion{
+		{AffiliateID: 3, Type: conversionRegistration, Params: spring},
+		{AffiliateID: 3, Type: conversionFirstDeposit, Amount: 5000, Params: spring},
+		{AffiliateID: 3, Type: conversionRegistration, Params: autumn},
+		{AffiliateID: 4, Type: conversionRegistration, Params: spring},
+		{AffiliateID: 3, Type: conversionRegistration, Params: spring, FlagReason: fraudBotUserAgent},
+	}
+
+	testCases := []struct {
+		name           string
+		query          subIDSummaryQuery
+		expectedError  error
+		expectedResult []*subIDSummaryRow
+	}{
+		{
+			name:  "summarize by sub id success - group by sub1",
+			query: subIDSummaryQuery{AffiliateID: 3, GroupBy: []string{"sub1"}},
+			expectedResult: []*subIDSummaryRow{
+				{Group: []string{""}, funnel: buildFunnel(1, nil)},
+				{Group: []string{"autumn"}, funnel: buildFunnel(1, conversions[2:3])},
+				{Group: []string{"spring"}, funnel: buildFunnel(3, conversions[0:2])},
+			},
+		},
+		{
+			name:  "summarize by sub id success - filter sub1 and group by sub2",
+			query: subIDSummaryQuery{AffiliateID: 3, Filters: map[string]string{"sub1": "spring"}, GroupBy: []string{"sub2", "utm_source"}},
+			expectedResult: []*subIDSummaryRow{
+				{Group: []string{"banner", "newsletter"}, funnel: buildFunnel(2, conversions[0:2])},
+				{Group: []string{"text", ""}, funnel: buildFunnel(1, nil)},
+			},
+		},
+		{
+			name:  "summarize by sub id success - including flagged",
+			query: subIDSummaryQuery{AffiliateID: 3, Filters: map[string]string{"sub2": "banner", "sub1": "spring"}, IncludeFlagged: true},
+			expectedResult: []*subIDSummaryRow{
+				{Group: []string{}, funnel: buildFunnel(3, []*conversion{conversions[0], conversions[1], conversions[4]})},
+			},
+		},
+		{
+			name:          "summarize by sub id error - unknown group by",
+			query:         subIDSummaryQuery{AffiliateID: 3, GroupBy: []string{"gclid"}},
+			expectedError: ErrInvalidSubIDParam,
+		},
+		{
+			name:          "summarize by sub id error - unknown filter",
+			query:         subIDSummaryQuery{AffiliateID: 3, Filters: map[string]string{"sub6": "x"}},
+			expectedError: ErrInvalidSubIDParam,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := summarizeBySubID(tc.query, clicks, conversions)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected %v, got %v", tc.expectedError, err)
+			}
+			if !reflect.DeepEqual(result, tc.expectedResult) {
+				t.Errorf("expected %+v, got %+v", tc.expectedResult, result)
+			}
+		})
+	}
+}